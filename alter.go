@@ -0,0 +1,190 @@
+package xmigrate
+
+import (
+	"regexp"
+
+	"github.com/akito0107/xsqlparser/sqlast"
+	errors "golang.org/x/xerrors"
+)
+
+// alterTable is ALTER TABLE statement in schema file, which is folded into
+// the CREATE TABLE statement after the whole file is read.
+type alterTable struct {
+	stmt *sqlast.AlterTableStmt
+	// collation is COLLATE clause of ADD COLUMN or ALTER COLUMN TYPE.
+	collation string
+
+	// EXCLUDE constraints are not understood by xsqlparser, so they are kept aside.
+	table     Identifier
+	exclusion *ExclusionDef
+}
+
+var (
+	alterTablePattern     = regexp.MustCompile(`(?is)^alter\s+table\s`)
+	alterTableOnlyPattern = regexp.MustCompile(`(?is)^alter\s+table\s+only\s`)
+	addExclusionPattern   = regexp.MustCompile(`(?is)^alter\s+table\s+(?:only\s+)?("(?:[^"]|"")+"|\w+)\s+add\s+constraint\s+("(?:[^"]|"")+"|\w+)\s+(exclude\b.*)$`)
+)
+
+func isAlterTable(stmt string) bool {
+	return alterTablePattern.MatchString(stmt)
+}
+
+func parseAlterTable(src string) (*alterTable, error) {
+	if m := addExclusionPattern.FindStringSubmatch(src); m != nil {
+		return &alterTable{
+			table:     ParseIdentifier(m[1]),
+			exclusion: &ExclusionDef{Name: ParseIdentifier(m[2]), Def: m[3]},
+		}, nil
+	}
+
+	s, collation := stripColumnCollation(alterTableOnlyPattern.ReplaceAllString(src, "ALTER TABLE "))
+	stmt, err := parseStatement(s)
+	if err != nil {
+		return nil, err
+	}
+	alter, ok := stmt.(*sqlast.AlterTableStmt)
+	if !ok {
+		return nil, errors.Errorf("unsupported sql: %s", src)
+	}
+
+	return &alterTable{
+		stmt:      alter,
+		collation: collation,
+		table:     ParseIdentifier(alter.TableName.ToSQLString()),
+	}, nil
+}
+
+// foldAlterTable applies ALTER TABLE statement to the CREATE TABLE statement
+// in targ, so that targ holds the state after the whole schema file.
+func foldAlterTable(targ *TargetTable, a *alterTable) error {
+	t := findCreateTable(targ, a.table)
+	if t == nil {
+		return errors.Errorf("ALTER TABLE on unknown table %s", a.table)
+	}
+	name := string(a.table)
+
+	if a.exclusion != nil {
+		targ.Exclusions[name] = append(targ.Exclusions[name], a.exclusion)
+		return nil
+	}
+
+	switch action := a.stmt.Action.(type) {
+	case *sqlast.AddConstraintTableAction:
+		return addTableConstraint(t, action.Constraint)
+	case *sqlast.AddColumnTableAction:
+		column := ParseIdentifier(action.Column.Name.ToSQLString())
+		if findColumnDef(t, column) != nil {
+			return errors.Errorf("column %s.%s already exists", a.table, column)
+		}
+		t.Elements = append(t.Elements, action.Column)
+		setColumnCollation(targ, name, string(column), a.collation)
+	case *sqlast.AlterColumnTableAction:
+		column := ParseIdentifier(action.ColumnName.ToSQLString())
+		c := findColumnDef(t, column)
+		if c == nil {
+			return errors.Errorf("ALTER COLUMN on unknown column %s.%s", a.table, column)
+		}
+		switch ca := action.Action.(type) {
+		case *sqlast.SetDefaultColumnAction:
+			c.Default = ca.Default
+		case *sqlast.DropDefaultColumnAction:
+			c.Default = nil
+		case *sqlast.PGAlterDataTypeColumnAction:
+			c.DataType = ca.DataType
+			setColumnCollation(targ, name, string(column), a.collation)
+		case *sqlast.PGSetNotNullColumnAction:
+			if !hasNotNullSpec(c) {
+				c.Constraints = append(c.Constraints, &sqlast.ColumnConstraint{Spec: &sqlast.NotNullColumnSpec{}})
+			}
+		case *sqlast.PGDropNotNullColumnAction:
+			var constraints []*sqlast.ColumnConstraint
+			for _, cc := range c.Constraints {
+				if _, ok := cc.Spec.(*sqlast.NotNullColumnSpec); !ok {
+					constraints = append(constraints, cc)
+				}
+			}
+			c.Constraints = constraints
+		default:
+			return errors.Errorf("unsupported ALTER COLUMN: %s", a.stmt.ToSQLString())
+		}
+	default:
+		return errors.Errorf("unsupported ALTER TABLE: %s", a.stmt.ToSQLString())
+	}
+
+	return nil
+}
+
+func setColumnCollation(targ *TargetTable, table, column, collation string) {
+	if collation == "" {
+		if c, ok := targ.ColumnCollations[table]; ok {
+			delete(c, column)
+		}
+		return
+	}
+	if _, ok := targ.ColumnCollations[table]; !ok {
+		targ.ColumnCollations[table] = make(map[string]string)
+	}
+	targ.ColumnCollations[table][column] = collation
+}
+
+func hasNotNullSpec(def *sqlast.ColumnDef) bool {
+	for _, c := range def.Constraints {
+		if _, ok := c.Spec.(*sqlast.NotNullColumnSpec); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func findCreateTable(targ *TargetTable, name Identifier) *sqlast.CreateTableStmt {
+	for _, t := range targ.TableDef {
+		if ParseIdentifier(t.Name.ToSQLString()) == name {
+			return t
+		}
+	}
+	return nil
+}
+
+func findColumnDef(t *sqlast.CreateTableStmt, name Identifier) *sqlast.ColumnDef {
+	for _, e := range t.Elements {
+		if c, ok := e.(*sqlast.ColumnDef); ok && ParseIdentifier(c.Name.ToSQLString()) == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// addTableConstraint adds the constraint to t. Single column PRIMARY KEY,
+// UNIQUE and FOREIGN KEY constraints become column constraints, in the same
+// way as PGDump reports them.
+func addTableConstraint(t *sqlast.CreateTableStmt, c *sqlast.TableConstraint) error {
+	var column *sqlast.Ident
+	var spec sqlast.ColumnConstraintSpec
+	switch s := c.Spec.(type) {
+	case *sqlast.UniqueTableConstraint:
+		if len(s.Columns) == 1 {
+			column = s.Columns[0]
+			spec = &sqlast.UniqueColumnSpec{IsPrimaryKey: s.IsPrimary}
+		}
+	case *sqlast.ReferentialTableConstraint:
+		if len(s.Columns) == 1 {
+			column = s.Columns[0]
+			spec = &sqlast.ReferencesColumnSpec{
+				TableName: sqlast.NewSQLObjectName(s.KeyExpr.TableName.ToSQLString()),
+				Columns:   s.KeyExpr.Columns,
+			}
+		}
+	}
+
+	if column == nil {
+		t.Elements = append(t.Elements, c)
+		return nil
+	}
+
+	def := findColumnDef(t, ParseIdentifier(column.ToSQLString()))
+	if def == nil {
+		return errors.Errorf("constraint on unknown column %s", column.ToSQLString())
+	}
+	def.Constraints = append(def.Constraints, &sqlast.ColumnConstraint{Name: c.Name, Spec: spec})
+	return nil
+}
@@ -0,0 +1,107 @@
+package xmigrate
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseSchema_AlterTable(t *testing.T) {
+	targ, err := ParseSchema(bytes.NewBufferString(`
+create table item (
+    id serial primary key,
+    account_id int,
+    name varchar(100),
+    period tstzrange
+);
+create table account (
+    id serial primary key,
+    latest_item_id int
+);
+alter table item add constraint item_account_id_fkey foreign key (account_id) references account (id);
+alter table account add constraint account_latest_item_id_fkey foreign key (latest_item_id) references item (id);
+alter table only item add column price int;
+alter table item add column label text collate "C";
+alter table item alter column price set not null;
+alter table item alter column price set default 0;
+alter table item alter column name type text;
+alter table item add constraint unique_price_and_name unique (price, name);
+alter table item add constraint no_overlap exclude using gist (account_id with =, period with &&);
+create unique index item_name_idx on item (name);
+`))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	current, err := ParseSchema(bytes.NewBufferString(`
+create table item (
+    id serial primary key,
+    account_id int references account (id),
+    name text,
+    period tstzrange,
+    price int not null default 0,
+    label text collate "C",
+    constraint unique_price_and_name unique (price, name),
+    constraint no_overlap exclude using gist (account_id with =, period with &&)
+);
+create table account (
+    id serial primary key,
+    latest_item_id int references item (id)
+);
+create unique index item_name_idx on item (name);
+`))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	diffs, _, err := DiffSchema(targ, current)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	for _, d := range diffs {
+		t.Errorf("unexpected diff: %s", d.Spec.ToSQLString())
+	}
+
+	if diff := cmp.Diff(map[string]string{"label": "C"}, targ.ColumnCollations["item"]); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+	if len(targ.Exclusions["item"]) != 1 {
+		t.Errorf("must be 1 exclusion but %d", len(targ.Exclusions["item"]))
+	}
+}
+
+func TestParseSchema_AlterTableError(t *testing.T) {
+	cases := []struct {
+		name string
+		src  string
+	}{
+		{
+			name: "unknown table",
+			src:  `alter table item add column price int;`,
+		},
+		{
+			name: "unknown column",
+			src: `create table item (id serial primary key);
+alter table item alter column price set not null;`,
+		},
+		{
+			name: "duplicated column",
+			src: `create table item (id serial primary key, price int);
+alter table item add column price int;`,
+		},
+		{
+			name: "unsupported action",
+			src: `create table item (id serial primary key, price int);
+alter table item drop column price;`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := ParseSchema(bytes.NewBufferString(c.src)); err == nil {
+				t.Errorf("must be error")
+			}
+		})
+	}
+}
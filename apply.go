@@ -0,0 +1,161 @@
+package xmigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+
+	"github.com/jmoiron/sqlx"
+	errors "golang.org/x/xerrors"
+)
+
+// ApplyPhase is a group of statements which are applied together.
+// A transactional phase is applied in a single transaction, and the others
+// statement by statement in autocommit mode.
+type ApplyPhase struct {
+	Transactional bool
+	Statements    []string
+}
+
+func (p *ApplyPhase) String() string {
+	if p.Transactional {
+		return "transaction"
+	}
+	return "non-transactional"
+}
+
+var (
+	// nonTransactionalPatterns are statements which can't run in a transaction block.
+	nonTransactionalPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`(?is)^\s*(create\s+(unique\s+)?|drop\s+)index\s+concurrently\b`),
+		regexp.MustCompile(`(?is)^\s*reindex\b.*\bconcurrently\b`),
+		regexp.MustCompile(`(?is)^\s*alter\s+table\s+.*\bdetach\s+partition\s+.*\bconcurrently\s*$`),
+		regexp.MustCompile(`(?is)^\s*(vacuum|alter\s+system|(create|drop)\s+(database|tablespace))\b`),
+	}
+	// addEnumValuePattern is ALTER TYPE ... ADD VALUE, which can run in a transaction on 12+.
+	addEnumValuePattern = regexp.MustCompile(`(?is)^\s*alter\s+type\s+.*\badd\s+value\b`)
+)
+
+// isTransactional reports whether stmt can run in a transaction block of the server.
+// An unknown version (0) is taken as an old one.
+func isTransactional(stmt string, version ServerVersion) bool {
+	for _, p := range nonTransactionalPatterns {
+		if p.MatchString(stmt) {
+			return false
+		}
+	}
+	if addEnumValuePattern.MatchString(stmt) && version < PG12 {
+		return false
+	}
+	return true
+}
+
+// SplitPhases splits statements into phases keeping their order. Consecutive
+// statements which can run in a transaction make one transactional phase,
+// so the whole plan is a single transaction unless it has statements such as
+// CREATE INDEX CONCURRENTLY, which are split out into their own phases.
+func SplitPhases(stmts []string, version ServerVersion) []*ApplyPhase {
+	var phases []*ApplyPhase
+	for _, s := range stmts {
+		tx := isTransactional(s, version)
+		if n := len(phases); n > 0 && tx && phases[n-1].Transactional {
+			phases[n-1].Statements = append(phases[n-1].Statements, s)
+			continue
+		}
+		phases = append(phases, &ApplyPhase{Transactional: tx, Statements: []string{s}})
+	}
+	return phases
+}
+
+// ApplyError reports the phase and the statement which failed. Phases before
+// Phase have been committed, and the failed phase has been rolled back if
+// it's transactional.
+type ApplyError struct {
+	// Phase is 1-origin index of the failed phase.
+	Phase     int
+	Phases    []*ApplyPhase
+	Statement string
+	Err       error
+}
+
+func (e *ApplyError) Error() string {
+	p := e.Phases[e.Phase-1]
+	state := "the statements before it in the phase were committed"
+	if p.Transactional {
+		state = "the phase was rolled back"
+	}
+	return fmt.Sprintf("phase %d/%d (%s) failed and %s, %d phase(s) before it were committed: %s: %v",
+		e.Phase, len(e.Phases), p, state, e.Phase-1, e.Statement, e.Err)
+}
+
+func (e *ApplyError) Unwrap() error {
+	return e.Err
+}
+
+type applyConfig struct {
+	hook func(phase int, stmt string)
+}
+
+type ApplyOption func(*applyConfig)
+
+// WithStatementHook makes Apply call hook before each statement runs, e.g. to print the progress.
+func WithStatementHook(hook func(phase int, stmt string)) ApplyOption {
+	return func(c *applyConfig) {
+		c.hook = hook
+	}
+}
+
+// Apply runs phases in order. A transactional phase is rolled back when any of
+// its statements fails, and Apply stops there with ApplyError.
+func Apply(ctx context.Context, db *sqlx.DB, phases []*ApplyPhase, opts ...ApplyOption) error {
+	c := &applyConfig{hook: func(int, string) {}}
+	for _, o := range opts {
+		o(c)
+	}
+
+	for i, p := range phases {
+		var err error
+		var failed string
+		if p.Transactional {
+			failed, err = applyTx(ctx, db, i+1, p, c)
+		} else {
+			failed, err = applyEach(ctx, db, i+1, p, c)
+		}
+		if err != nil {
+			return &ApplyError{Phase: i + 1, Phases: phases, Statement: failed, Err: err}
+		}
+	}
+
+	return nil
+}
+
+func applyTx(ctx context.Context, db *sqlx.DB, phase int, p *ApplyPhase, c *applyConfig) (string, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return "BEGIN", err
+	}
+	defer tx.Rollback()
+
+	if failed, err := applyEach(ctx, tx, phase, p, c); err != nil {
+		return failed, err
+	}
+	if err := tx.Commit(); err != nil {
+		return "COMMIT", err
+	}
+	return "", nil
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func applyEach(ctx context.Context, e execer, phase int, p *ApplyPhase, c *applyConfig) (string, error) {
+	for _, s := range p.Statements {
+		c.hook(phase, s)
+		if _, err := e.ExecContext(ctx, s); err != nil {
+			return s, errors.Errorf("exec failed: %w", err)
+		}
+	}
+	return "", nil
+}
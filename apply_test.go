@@ -0,0 +1,157 @@
+package xmigrate
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/akito0107/xsqlparser/sqlast"
+	"github.com/google/go-cmp/cmp"
+	errors "golang.org/x/xerrors"
+)
+
+func TestSplitPhases(t *testing.T) {
+	cases := []struct {
+		name    string
+		stmts   []string
+		version ServerVersion
+		phases  []*ApplyPhase
+	}{
+		{
+			name:    "single transaction",
+			stmts:   []string{"CREATE TABLE a (id int)", "ALTER TABLE a ADD COLUMN name text"},
+			version: PG12,
+			phases: []*ApplyPhase{
+				{Transactional: true, Statements: []string{"CREATE TABLE a (id int)", "ALTER TABLE a ADD COLUMN name text"}},
+			},
+		},
+		{
+			name: "concurrent index",
+			stmts: []string{
+				"CREATE TABLE a (id int)",
+				"CREATE INDEX CONCURRENTLY a_id_idx ON a (id)",
+				"CREATE UNIQUE INDEX concurrently a_uniq_idx ON a (id)",
+				"ALTER TABLE a ADD COLUMN name text",
+			},
+			version: PG12,
+			phases: []*ApplyPhase{
+				{Transactional: true, Statements: []string{"CREATE TABLE a (id int)"}},
+				{Transactional: false, Statements: []string{"CREATE INDEX CONCURRENTLY a_id_idx ON a (id)"}},
+				{Transactional: false, Statements: []string{"CREATE UNIQUE INDEX concurrently a_uniq_idx ON a (id)"}},
+				{Transactional: true, Statements: []string{"ALTER TABLE a ADD COLUMN name text"}},
+			},
+		},
+		{
+			name:    "add enum value on 11",
+			stmts:   []string{"ALTER TYPE mood ADD VALUE 'ok'", "CREATE TABLE a (id int)"},
+			version: PG11,
+			phases: []*ApplyPhase{
+				{Transactional: false, Statements: []string{"ALTER TYPE mood ADD VALUE 'ok'"}},
+				{Transactional: true, Statements: []string{"CREATE TABLE a (id int)"}},
+			},
+		},
+		{
+			name:    "add enum value on 12",
+			stmts:   []string{"ALTER TYPE mood ADD VALUE 'ok'", "CREATE TABLE a (id int)"},
+			version: PG12,
+			phases: []*ApplyPhase{
+				{Transactional: true, Statements: []string{"ALTER TYPE mood ADD VALUE 'ok'", "CREATE TABLE a (id int)"}},
+			},
+		},
+		{
+			name:    "empty",
+			version: PG12,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if diff := cmp.Diff(c.phases, SplitPhases(c.stmts, c.version)); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestSplitPhases_ConcurrentIndexInSchema(t *testing.T) {
+	targ, err := ParseSchema(bytes.NewBufferString(`create table account (id int, name int);
+		create index concurrently account_name_idx on account (name);`))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	current := []*TableDef{
+		{
+			Name: "account",
+			Columns: map[string]*sqlast.ColumnDef{
+				"id":   {Name: sqlast.NewIdent("id"), DataType: &sqlast.Int{}},
+				"name": {Name: sqlast.NewIdent("name"), DataType: &sqlast.Int{}},
+			},
+			ColumnOrder: []string{"id", "name"},
+		},
+	}
+	diffs, err := Diff(targ, current)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	var stmts []string
+	for _, d := range diffs {
+		stmts = append(stmts, d.Spec.ToSQLString())
+	}
+	expect := []*ApplyPhase{
+		{Transactional: false, Statements: []string{"CREATE INDEX CONCURRENTLY account_name_idx ON account (name)"}},
+	}
+	if diff := cmp.Diff(expect, SplitPhases(stmts, PG12)); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+}
+
+func TestApplyError(t *testing.T) {
+	phases := SplitPhases([]string{
+		"CREATE TABLE a (id int)",
+		"CREATE INDEX CONCURRENTLY a_id_idx ON a (id)",
+		"ALTER TABLE a ADD COLUMN name text",
+	}, PG12)
+	cause := errors.New("column \"name\" already exists")
+	err := &ApplyError{Phase: 3, Phases: phases, Statement: "ALTER TABLE a ADD COLUMN name text", Err: cause}
+
+	for _, s := range []string{"phase 3/3 (transaction)", "rolled back", "2 phase(s) before it were committed", "ALTER TABLE a ADD COLUMN name text"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Errorf("%q must contain %q", err.Error(), s)
+		}
+	}
+	if !errors.Is(err, cause) {
+		t.Errorf("must wrap the cause")
+	}
+}
+
+func TestApply_Rollback(t *testing.T) {
+	ctx := context.Background()
+	db, err := Connect(ctx, ConnInfo{"dbname": "xmigrate_test", "host": "localhost", "port": "5432", "user": "postgres"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer db.Close()
+	defer db.Exec("DROP TABLE IF EXISTS apply_test")
+
+	err = Apply(ctx, db, SplitPhases([]string{
+		"CREATE TABLE apply_test (id int)",
+		"ALTER TABLE apply_test ADD COLUMN id int",
+	}, PG12))
+	var applyErr *ApplyError
+	if !errors.As(err, &applyErr) {
+		t.Fatalf("must be ApplyError but %+v", err)
+	}
+	if applyErr.Phase != 1 || applyErr.Statement != "ALTER TABLE apply_test ADD COLUMN id int" {
+		t.Errorf("unexpected failure: %+v", applyErr)
+	}
+
+	var exists bool
+	if err := db.GetContext(ctx, &exists, "select exists (select 1 from pg_class where relname = 'apply_test')"); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if exists {
+		t.Errorf("CREATE TABLE must be rolled back")
+	}
+}
@@ -0,0 +1,204 @@
+package xmigrate
+
+import (
+	"context"
+
+	"github.com/akito0107/xsqlparser/sqlast"
+	errors "golang.org/x/xerrors"
+)
+
+// SchemaBuilder builds Schema in memory. It's a SchemaSource, so schemas
+// which are declared in Go can be compared with other sources.
+//
+//	b := NewSchemaBuilder()
+//	b.Table("account").
+//		Column("id", "serial", PrimaryKey()).
+//		Column("email", "varchar(255)", NotNull(), Unique()).
+//		Index("create index email_idx on account (email)")
+//
+// Names are folded as Identifier.
+// Errors are kept until Build (or Load) returns them.
+type SchemaBuilder struct {
+	schema *Schema
+	err    error
+}
+
+func NewSchemaBuilder() *SchemaBuilder {
+	return &SchemaBuilder{schema: &Schema{}}
+}
+
+// Table adds a table and returns the builder of it.
+func (b *SchemaBuilder) Table(name string) *TableBuilder {
+	t := &TableDef{
+		Name:             ParseIdentifier(name),
+		Columns:          make(map[string]*sqlast.ColumnDef),
+		Indexes:          make(map[string]*IndexDef),
+		ColumnCollations: make(map[string]string),
+	}
+	b.schema.Tables = append(b.schema.Tables, t)
+	return &TableBuilder{b: b, t: t}
+}
+
+func (b *SchemaBuilder) Extension(name string) *SchemaBuilder {
+	b.schema.Extensions = append(b.schema.Extensions, &ExtensionDef{Name: name})
+	return b
+}
+
+func (b *SchemaBuilder) Collation(def *CollationDef) *SchemaBuilder {
+	b.schema.Collations = append(b.schema.Collations, def)
+	return b
+}
+
+func (b *SchemaBuilder) Build() (*Schema, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	return b.schema, nil
+}
+
+func (b *SchemaBuilder) Load(ctx context.Context) (*Schema, error) {
+	return b.Build()
+}
+
+func (b *SchemaBuilder) setErr(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+type TableBuilder struct {
+	b *SchemaBuilder
+	t *TableDef
+}
+
+type columnBuilder struct {
+	def       *sqlast.ColumnDef
+	collation string
+	err       error
+}
+
+type ColumnOption func(*columnBuilder)
+
+func NotNull() ColumnOption {
+	return func(c *columnBuilder) {
+		c.def.Constraints = append(c.def.Constraints, &sqlast.ColumnConstraint{Spec: &sqlast.NotNullColumnSpec{}})
+	}
+}
+
+func PrimaryKey() ColumnOption {
+	return func(c *columnBuilder) {
+		c.def.Constraints = append(c.def.Constraints, &sqlast.ColumnConstraint{Spec: &sqlast.UniqueColumnSpec{IsPrimaryKey: true}})
+	}
+}
+
+func Unique() ColumnOption {
+	return func(c *columnBuilder) {
+		c.def.Constraints = append(c.def.Constraints, &sqlast.ColumnConstraint{Spec: &sqlast.UniqueColumnSpec{}})
+	}
+}
+
+func References(table, column string) ColumnOption {
+	return func(c *columnBuilder) {
+		c.def.Constraints = append(c.def.Constraints, &sqlast.ColumnConstraint{
+			Spec: &sqlast.ReferencesColumnSpec{
+				TableName: sqlast.NewSQLObjectName(ParseIdentifier(table).ToSQLString()),
+				Columns:   []*sqlast.Ident{sqlast.NewIdent(ParseIdentifier(column).ToSQLString())},
+			},
+		})
+	}
+}
+
+// Default sets the default expression given as sql.
+func Default(expr string) ColumnOption {
+	return func(c *columnBuilder) {
+		d, err := parseExpr(expr)
+		if err != nil {
+			c.err = err
+			return
+		}
+		c.def.Default = d
+	}
+}
+
+func Collate(collation string) ColumnOption {
+	return func(c *columnBuilder) {
+		c.collation = collation
+	}
+}
+
+// Column adds a column. tp is a type in sql such as "varchar(255)".
+func (tb *TableBuilder) Column(name, tp string, opts ...ColumnOption) *TableBuilder {
+	n := string(ParseIdentifier(name))
+	c := &columnBuilder{
+		def: &sqlast.ColumnDef{
+			Name:     sqlast.NewIdent(ParseIdentifier(name).ToSQLString()),
+			DataType: columnType(tp),
+		},
+	}
+	for _, o := range opts {
+		o(c)
+	}
+	if c.err != nil {
+		tb.b.setErr(errors.Errorf("column %s.%s: %w", tb.t.Name, n, c.err))
+	}
+	if c.collation != "" {
+		tb.t.ColumnCollations[n] = c.collation
+	}
+
+	tb.t.Columns[n] = c.def
+	tb.t.ColumnOrder = append(tb.t.ColumnOrder, n)
+	return tb
+}
+
+func (tb *TableBuilder) PrimaryKeyOn(name string, columns ...string) *TableBuilder {
+	tb.t.Constrains = append(tb.t.Constrains, &sqlast.TableConstraint{
+		Name: sqlast.NewIdent(ParseIdentifier(name).ToSQLString()),
+		Spec: &sqlast.UniqueTableConstraint{IsPrimary: true, Columns: foldedIdents(columns)},
+	})
+	return tb
+}
+
+func (tb *TableBuilder) UniqueOn(name string, columns ...string) *TableBuilder {
+	tb.t.Constrains = append(tb.t.Constrains, &sqlast.TableConstraint{
+		Name: sqlast.NewIdent(ParseIdentifier(name).ToSQLString()),
+		Spec: &sqlast.UniqueTableConstraint{Columns: foldedIdents(columns)},
+	})
+	return tb
+}
+
+func (tb *TableBuilder) ForeignKey(name string, columns []string, refTable string, refColumns []string) *TableBuilder {
+	tb.t.Constrains = append(tb.t.Constrains, &sqlast.TableConstraint{
+		Name: sqlast.NewIdent(ParseIdentifier(name).ToSQLString()),
+		Spec: &sqlast.ReferentialTableConstraint{
+			Columns: foldedIdents(columns),
+			KeyExpr: &sqlast.ReferenceKeyExpr{
+				TableName: sqlast.NewIdent(ParseIdentifier(refTable).ToSQLString()),
+				Columns:   foldedIdents(refColumns),
+			},
+		},
+	})
+	return tb
+}
+
+// Index adds an index given as CREATE INDEX statement.
+func (tb *TableBuilder) Index(sql string) *TableBuilder {
+	idx, err := parseIndexDef(sql)
+	if err != nil {
+		tb.b.setErr(errors.Errorf("table %s: %w", tb.t.Name, err))
+		return tb
+	}
+	if idx.Table != tb.t.Name {
+		tb.b.setErr(errors.Errorf("index %s is not on table %s", idx.Name, tb.t.Name))
+		return tb
+	}
+	tb.t.Indexes[string(idx.Name)] = idx
+	return tb
+}
+
+func foldedIdents(names []string) []*sqlast.Ident {
+	var idents []*sqlast.Ident
+	for _, n := range names {
+		idents = append(idents, sqlast.NewIdent(ParseIdentifier(n).ToSQLString()))
+	}
+	return idents
+}
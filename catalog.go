@@ -0,0 +1,447 @@
+package xmigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/akito0107/xsqlparser/sqlast"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	errors "golang.org/x/xerrors"
+)
+
+// The queries below read pg_catalog for every table in public schema at once.
+// Results are grouped by table name and assembled into TableDef by buildTableDef.
+
+type pgTable struct {
+	Name        string         `db:"relname"`
+	Persistence string         `db:"relpersistence"`
+	Options     pq.StringArray `db:"reloptions"`
+	Tablespace  sql.NullString `db:"spcname"`
+	KeyDef      sql.NullString `db:"partkeydef"`
+	Parent      sql.NullString `db:"parent"`
+	Bound       sql.NullString `db:"bound"`
+}
+
+const partitionColumns = `case when c.relkind = 'p' then pg_get_partkeydef(c.oid) end as partkeydef,
+					parent.relname as parent,
+					case when c.relispartition then pg_get_expr(c.relpartbound, c.oid) end as bound`
+
+const partitionJoins = `left join pg_inherits i on i.inhrelid = c.oid and c.relispartition
+				left join pg_class parent on parent.oid = i.inhparent`
+
+// tablesCondition restricts the query to tables when they are given.
+func tablesCondition(column string, tables []string) (string, []interface{}) {
+	if tables == nil {
+		return "", nil
+	}
+	return fmt.Sprintf("and %s = any($1)", column), []interface{}{pq.Array(tables)}
+}
+
+// getTables returns tables and partitioned tables ordered by name.
+func getTables(ctx context.Context, q sqlx.QueryerContext, version ServerVersion) ([]*pgTable, error) {
+	columns := "null as partkeydef, null as parent, null as bound"
+	joins := ""
+	// declarative partitioning (relkind p, relispartition) exists on 10+
+	if version >= PG10 {
+		columns = partitionColumns
+		joins = partitionJoins
+	}
+
+	var tables []*pgTable
+	if err := sqlx.SelectContext(ctx, q, &tables, fmt.Sprintf(`select
+					c.relname as relname,
+					c.relpersistence as relpersistence,
+					coalesce(c.reloptions, '{}') as reloptions,
+					ts.spcname as spcname,
+					%s
+				from
+					pg_class c
+				join pg_namespace n on n.oid = c.relnamespace
+				left join pg_tablespace ts on ts.oid = c.reltablespace
+				%s
+				where n.nspname = 'public' and c.relkind in ('r', 'p')
+				order by c.relname`, columns, joins)); err != nil {
+		return nil, errors.Errorf("selectContext failed: %w", err)
+	}
+
+	return tables, nil
+}
+
+type pgColumn struct {
+	TableName  string `db:"table_name"`
+	ColumnName string `db:"column_name"`
+	NotNull    bool   `db:"not_null"`
+	// FormattedType is the result of pg_catalog.format_type which keeps
+	// every type modifier (length, precision, interval fields, array ...).
+	FormattedType string         `db:"formatted_type"`
+	ColumnDefault sql.NullString `db:"column_default"`
+	// CollationName is null for the default collation.
+	CollationName sql.NullString `db:"collation_name"`
+	// Identity is attidentity, 'a' for ALWAYS and 'd' for BY DEFAULT.
+	Identity string `db:"identity"`
+	// Generated is attgenerated, 's' for stored generated columns.
+	Generated string `db:"generated"`
+}
+
+// getColumns returns columns of tables (all tables when nil) keyed by table name in the order of attnum.
+func getColumns(ctx context.Context, q sqlx.QueryerContext, version ServerVersion, tables []string) (map[string][]*pgColumn, error) {
+	columnDefault := "pg_get_expr(d.adbin, d.adrelid)"
+	identity := "''"
+	generated := "''"
+	// identity columns (attidentity) exist on 10+
+	if version >= PG10 {
+		identity = "a.attidentity::text"
+	}
+	// defaults of generated columns (attgenerated, 12+) are not defaults.
+	if version >= PG12 {
+		columnDefault = "case when a.attgenerated = '' then pg_get_expr(d.adbin, d.adrelid) end"
+		generated = "a.attgenerated::text"
+	}
+
+	cond, args := tablesCondition("c.relname", tables)
+	var columns []*pgColumn
+	if err := sqlx.SelectContext(ctx, q, &columns, fmt.Sprintf(`select
+					c.relname as table_name,
+					a.attname as column_name,
+					a.attnotnull as not_null,
+					pg_catalog.format_type(a.atttypid, a.atttypmod) as formatted_type,
+					%s as column_default,
+					case when not (nco.nspname = 'pg_catalog' and co.collname = 'default') then co.collname end as collation_name,
+					%s as identity,
+					%s as generated
+				from
+					pg_attribute a
+				join pg_class c on c.oid = a.attrelid
+				join pg_namespace n on n.oid = c.relnamespace
+				left join pg_attrdef d on d.adrelid = a.attrelid and d.adnum = a.attnum
+				left join (pg_collation co join pg_namespace nco on nco.oid = co.collnamespace) on co.oid = a.attcollation
+				where n.nspname = 'public' and c.relkind in ('r', 'p') and a.attnum > 0 and not a.attisdropped %s
+				order by c.relname, a.attnum`, columnDefault, identity, generated, cond), args...); err != nil {
+		return nil, errors.Errorf("selectContext failed: %w", err)
+	}
+
+	res := make(map[string][]*pgColumn)
+	for _, c := range columns {
+		res[c.TableName] = append(res[c.TableName], c)
+	}
+	return res, nil
+}
+
+type pgConstraint struct {
+	TableName  string         `db:"table_name"`
+	Name       string         `db:"conname"`
+	Type       string         `db:"contype"`
+	Columns    pq.StringArray `db:"columns"`
+	RefTable   sql.NullString `db:"ref_table"`
+	RefColumns pq.StringArray `db:"ref_columns"`
+	Def        string         `db:"def"`
+}
+
+// getConstraints returns primary key, unique, foreign key, check and exclusion
+// constraints of tables (all tables when nil) keyed by table name in the order of constraint name.
+func getConstraints(ctx context.Context, q sqlx.QueryerContext, tables []string) (map[string][]*pgConstraint, error) {
+	cond, args := tablesCondition("t.relname", tables)
+	var constraints []*pgConstraint
+	if err := sqlx.SelectContext(ctx, q, &constraints, fmt.Sprintf(`select
+					t.relname as table_name,
+					c.conname as conname,
+					c.contype as contype,
+					array(
+						select a.attname::text from unnest(c.conkey) with ordinality k(attnum, ord)
+						join pg_attribute a on a.attrelid = c.conrelid and a.attnum = k.attnum
+						order by k.ord
+					) as columns,
+					r.relname as ref_table,
+					array(
+						select a.attname::text from unnest(c.confkey) with ordinality k(attnum, ord)
+						join pg_attribute a on a.attrelid = c.confrelid and a.attnum = k.attnum
+						order by k.ord
+					) as ref_columns,
+					pg_get_constraintdef(c.oid) as def
+				from
+					pg_constraint c
+				join pg_class t on t.oid = c.conrelid
+				join pg_namespace n on n.oid = t.relnamespace
+				left join pg_class r on r.oid = c.confrelid
+				where n.nspname = 'public' and c.contype in ('p', 'u', 'f', 'c', 'x') %s
+				order by t.relname, c.conname`, cond), args...); err != nil {
+		return nil, errors.Errorf("selectContext failed: %w", err)
+	}
+
+	res := make(map[string][]*pgConstraint)
+	for _, c := range constraints {
+		res[c.TableName] = append(res[c.TableName], c)
+	}
+	return res, nil
+}
+
+type pgIndexInfo struct {
+	SchemaName string         `db:"schemaname"`
+	TableName  string         `db:"tablename"`
+	IndexName  string         `db:"indexname"`
+	IndexDef   string         `db:"indexdef"`
+	TableSpace sql.NullString `db:"tablespace"`
+}
+
+// getIndexes returns indexes of tables (all tables when nil) keyed by table name and index name.
+func getIndexes(ctx context.Context, q sqlx.QueryerContext, tables []string) (map[string]map[string]*IndexDef, error) {
+	cond, args := tablesCondition("i.tablename", tables)
+	var indexes []pgIndexInfo
+
+	// indexes which back constraints of their own table are dumped as constraints
+	// (foreign keys also refer to the unique index they reference), and indexes
+	// which are attached to an index of the parent partitioned table are
+	// managed by the parent one.
+	if err := sqlx.SelectContext(ctx, q, &indexes, fmt.Sprintf(`SELECT i.* from pg_indexes i
+				where i.schemaname = 'public'
+				and not exists (select 1 from pg_constraint c
+					where c.conindid = format('%%I.%%I', i.schemaname, i.indexname)::regclass
+					and c.contype in ('p', 'u', 'x')
+					and c.conrelid = format('%%I.%%I', i.schemaname, i.tablename)::regclass)
+				and not exists (select 1 from pg_inherits where inhrelid = format('%%I.%%I', i.schemaname, i.indexname)::regclass)
+				%s
+				order by i.tablename, i.indexname`, cond), args...); err != nil {
+		return nil, errors.Errorf("selectContext failed: %w", err)
+	}
+
+	res := make(map[string]map[string]*IndexDef)
+	for _, i := range indexes {
+		def, err := parseIndexDef(i.IndexDef)
+		if err != nil {
+			return nil, errors.Errorf("def: %s parse failed: %w", i.IndexDef, err)
+		}
+		// pg_get_indexdef omits TABLESPACE clause
+		def.Tablespace = i.TableSpace.String
+
+		if _, ok := res[i.TableName]; !ok {
+			res[i.TableName] = make(map[string]*IndexDef)
+		}
+		res[i.TableName][i.IndexName] = def
+	}
+
+	return res, nil
+}
+
+// getDefaultTablespace returns the tablespace which relations are stored in
+// when pg_tables and pg_indexes show no tablespace.
+func getDefaultTablespace(ctx context.Context, q sqlx.QueryerContext) (string, error) {
+	var name string
+	if err := sqlx.GetContext(ctx, q, &name, `select t.spcname from pg_database d
+				join pg_tablespace t on t.oid = d.dattablespace
+				where d.datname = current_database()`); err != nil {
+		return "", errors.Errorf("getContext failed: %w", err)
+	}
+	return name, nil
+}
+
+// getTimeZone returns the TimeZone setting which timestamptz literals without
+// offset are read in.
+func getTimeZone(ctx context.Context, q sqlx.QueryerContext) (string, error) {
+	var name string
+	if err := sqlx.GetContext(ctx, q, &name, `show timezone`); err != nil {
+		return "", errors.Errorf("getContext failed: %w", err)
+	}
+	return name, nil
+}
+
+func buildTableDef(t *pgTable, columns []*pgColumn, constraints []*pgConstraint, indexes map[string]*IndexDef) (*TableDef, error) {
+	columndefs, order, collations, err := buildColumns(columns)
+	if err != nil {
+		return nil, err
+	}
+
+	columnConstraints, tableConstraints, exclusions, unsupported := buildConstraints(constraints)
+
+	for name, c := range columndefs {
+		c.Constraints = append(c.Constraints, columnConstraints[name]...)
+
+		// convert to int with nextval to serial
+		if serial, ok := fixSerial(c); ok {
+			c.DataType = serial
+			c.Default = nil
+		}
+	}
+
+	if indexes == nil {
+		indexes = make(map[string]*IndexDef)
+	}
+
+	def := &TableDef{
+		Name:                   Identifier(t.Name),
+		Columns:                columndefs,
+		ColumnOrder:            order,
+		Constrains:             tableConstraints,
+		Indexes:                indexes,
+		ColumnCollations:       collations,
+		Exclusions:             exclusions,
+		UnsupportedConstraints: unsupported,
+	}
+	for _, c := range columns {
+		switch c.Identity {
+		case "a":
+			def.setIdentity(c.ColumnName, IdentityAlways)
+		case "d":
+			def.setIdentity(c.ColumnName, IdentityByDefault)
+		}
+		if c.Generated == "s" {
+			def.setGenerated(c.ColumnName)
+		}
+	}
+
+	if t.KeyDef.Valid {
+		key, err := parsePartitionKey(t.KeyDef.String)
+		if err != nil {
+			return nil, err
+		}
+		def.PartitionKey = key
+	}
+	if t.Bound.Valid {
+		def.PartitionOf = &PartitionDef{
+			Name:   Identifier(t.Name),
+			Parent: Identifier(t.Parent.String),
+			Bound:  t.Bound.String,
+			Key:    def.PartitionKey,
+		}
+	}
+
+	params, err := parseStorageParams(t.Options)
+	if err != nil {
+		return nil, err
+	}
+	def.Storage = TableStorage{
+		Unlogged:   t.Persistence == "u",
+		Tablespace: t.Tablespace.String,
+	}
+	if len(params) > 0 {
+		def.Storage.Params = params
+	}
+
+	return def, nil
+}
+
+func buildColumns(columns []*pgColumn) (map[string]*sqlast.ColumnDef, []string, map[string]string, error) {
+	columndefs := make(map[string]*sqlast.ColumnDef)
+	collations := make(map[string]string)
+	var order []string
+	for _, c := range columns {
+		tp := columnType(c.FormattedType)
+
+		var def sqlast.Node
+		if c.ColumnDefault.Valid {
+			d, err := parseExpr(c.ColumnDefault.String)
+			if err != nil {
+				return nil, nil, nil, errors.Errorf("parseDefault Value failed: %w", err)
+			}
+			def = d
+		}
+
+		if c.CollationName.Valid {
+			collations[c.ColumnName] = c.CollationName.String
+		}
+
+		var constrains []*sqlast.ColumnConstraint
+
+		if c.NotNull {
+			constrains = append(constrains, &sqlast.ColumnConstraint{
+				Spec: &sqlast.NotNullColumnSpec{},
+			})
+		}
+
+		columndefs[c.ColumnName] = &sqlast.ColumnDef{
+			DataType:    tp,
+			Default:     def,
+			Name:        sqlast.NewIdent(quoteIdent(c.ColumnName)),
+			Constraints: constrains,
+		}
+		order = append(order, c.ColumnName)
+	}
+
+	return columndefs, order, collations, nil
+}
+
+// buildConstraints returns constraints on a single column as column constraints
+// (keyed by column name) and the others as table constraints.
+// Names of constraints which xmigrate doesn't manage (CHECK) are returned as unsupported.
+func buildConstraints(constraints []*pgConstraint) (map[string][]*sqlast.ColumnConstraint, []*sqlast.TableConstraint, []*ExclusionDef, []string) {
+	columnConstraints := make(map[string][]*sqlast.ColumnConstraint)
+	var tableConstraints []*sqlast.TableConstraint
+	var exclusions []*ExclusionDef
+	var unsupported []string
+
+	for _, c := range constraints {
+		name := sqlast.NewIdent(quoteIdent(c.Name))
+
+		switch c.Type {
+		case "x":
+			// EXCLUDE constraints are not understood by xsqlparser
+			exclusions = append(exclusions, &ExclusionDef{Name: Identifier(c.Name), Def: c.Def})
+			continue
+		case "p", "u", "f":
+		default:
+			unsupported = append(unsupported, c.Name)
+			continue
+		}
+
+		if len(c.Columns) == 1 {
+			column := c.Columns[0]
+			var spec sqlast.ColumnConstraintSpec
+			switch c.Type {
+			case "f":
+				spec = &sqlast.ReferencesColumnSpec{
+					TableName: sqlast.NewSQLObjectName(quoteIdent(c.RefTable.String)),
+					Columns:   quoteIdents(c.RefColumns),
+				}
+			case "u":
+				spec = &sqlast.UniqueColumnSpec{}
+			case "p":
+				spec = &sqlast.UniqueColumnSpec{IsPrimaryKey: true}
+			}
+			columnConstraints[column] = append(columnConstraints[column], &sqlast.ColumnConstraint{
+				Name: name,
+				Spec: spec,
+			})
+			continue
+		}
+
+		var spec sqlast.TableConstraintSpec
+		switch c.Type {
+		case "f":
+			spec = &sqlast.ReferentialTableConstraint{
+				Columns: quoteIdents(c.Columns),
+				KeyExpr: &sqlast.ReferenceKeyExpr{
+					TableName: sqlast.NewIdent(quoteIdent(c.RefTable.String)),
+					Columns:   quoteIdents(c.RefColumns),
+				},
+			}
+		case "u":
+			spec = &sqlast.UniqueTableConstraint{
+				Columns: quoteIdents(c.Columns),
+			}
+		case "p":
+			spec = &sqlast.UniqueTableConstraint{
+				IsPrimary: true,
+				Columns:   quoteIdents(c.Columns),
+			}
+		}
+		tableConstraints = append(tableConstraints, &sqlast.TableConstraint{
+			Name: name,
+			Spec: spec,
+		})
+	}
+
+	return columnConstraints, tableConstraints, exclusions, unsupported
+}
+
+func unsupportedConstraintWarning(table Identifier, name string) string {
+	return fmt.Sprintf("constraint %s of %s is not supported by xmigrate and left as it is", quoteIdent(name), table.ToSQLString())
+}
+
+func quoteIdents(names []string) []*sqlast.Ident {
+	var idents []*sqlast.Ident
+	for _, n := range names {
+		idents = append(idents, sqlast.NewIdent(quoteIdent(n)))
+	}
+	return idents
+}
@@ -0,0 +1,138 @@
+package xmigrate
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/akito0107/xsqlparser/sqlast"
+	"github.com/google/go-cmp/cmp"
+	"github.com/lib/pq"
+)
+
+func TestBuildTableDef(t *testing.T) {
+	table := &pgTable{
+		Name:        "subitem",
+		Persistence: "u",
+		Options:     pq.StringArray{"fillfactor=70"},
+	}
+	columns := []*pgColumn{
+		{TableName: "subitem", ColumnName: "subitem_id", NotNull: true, FormattedType: "integer", ColumnDefault: sql.NullString{String: "nextval('subitem_subitem_id_seq'::regclass)", Valid: true}},
+		{TableName: "subitem", ColumnName: "price", NotNull: true, FormattedType: "integer"},
+		{TableName: "subitem", ColumnName: "name", FormattedType: "character varying", CollationName: sql.NullString{String: "C", Valid: true}},
+	}
+	constraints := []*pgConstraint{
+		{TableName: "subitem", Name: "fkey_item_id_name", Type: "f", Columns: pq.StringArray{"price", "name"}, RefTable: sql.NullString{String: "item", Valid: true}, RefColumns: pq.StringArray{"price", "name"}},
+		{TableName: "subitem", Name: "no_overlap", Type: "x", Columns: pq.StringArray{"price"}, Def: "EXCLUDE USING gist (price WITH =)"},
+		{TableName: "subitem", Name: "subitem_pkey", Type: "p", Columns: pq.StringArray{"subitem_id"}},
+	}
+
+	def, err := buildTableDef(table, columns, constraints, nil)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if diff := cmp.Diff([]string{"subitem_id", "price", "name"}, def.ColumnOrder); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+	if CanonicalType(def.Columns["subitem_id"].DataType) != "serial" || def.Columns["subitem_id"].Default != nil {
+		t.Errorf("subitem_id must be serial")
+	}
+	pk := def.Columns["subitem_id"].Constraints
+	if len(pk) != 2 {
+		t.Fatalf("subitem_id must be not null primary key but %+v", pk)
+	}
+	if spec, ok := pk[1].Spec.(*sqlast.UniqueColumnSpec); !ok || !spec.IsPrimaryKey {
+		t.Errorf("unexpected spec %+v", pk[1].Spec)
+	}
+
+	if len(def.Constrains) != 1 {
+		t.Fatalf("must be 1 table constraint but %d", len(def.Constrains))
+	}
+	fk, ok := def.Constrains[0].Spec.(*sqlast.ReferentialTableConstraint)
+	if !ok || len(fk.Columns) != 2 || len(fk.KeyExpr.Columns) != 2 {
+		t.Errorf("unexpected spec %+v", def.Constrains[0].Spec)
+	}
+
+	if diff := cmp.Diff([]*ExclusionDef{{Name: "no_overlap", Def: "EXCLUDE USING gist (price WITH =)"}}, def.Exclusions); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+	if diff := cmp.Diff(map[string]string{"name": "C"}, def.ColumnCollations); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+	if diff := cmp.Diff(TableStorage{Unlogged: true, Params: map[string]string{"fillfactor": "70"}}, def.Storage); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+}
+
+func TestBuildConstraints_Check(t *testing.T) {
+	columnConstraints, tableConstraints, _, unsupported := buildConstraints([]*pgConstraint{
+		{TableName: "item", Name: "item_pkey", Type: "p", Columns: []string{"id"}},
+		{TableName: "item", Name: "positive_price", Type: "c", Columns: []string{"price"}},
+	})
+	if len(columnConstraints["id"]) != 1 || len(columnConstraints["price"]) != 0 || len(tableConstraints) != 0 {
+		t.Errorf("unexpected constraints %+v %+v", columnConstraints, tableConstraints)
+	}
+	if diff := cmp.Diff([]string{"positive_price"}, unsupported); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+}
+
+func TestDiff_UnsupportedConstraint(t *testing.T) {
+	current := []*TableDef{
+		{
+			Name: "item",
+			Columns: map[string]*sqlast.ColumnDef{
+				"price": {Name: sqlast.NewIdent("price"), DataType: &sqlast.Int{}},
+			},
+			ColumnOrder:            []string{"price"},
+			UnsupportedConstraints: []string{"positive_price"},
+		},
+	}
+	targ := &TargetTable{TableDef: []*sqlast.CreateTableStmt{{
+		Name:     sqlast.NewSQLObjectName("item"),
+		Elements: []sqlast.TableElement{&sqlast.ColumnDef{Name: sqlast.NewIdent("price"), DataType: &sqlast.Int{}}},
+	}}}
+
+	var warnings []string
+	diffs, err := Diff(targ, current, WithWarnings(func(msg string) { warnings = append(warnings, msg) }))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(diffs) != 0 {
+		t.Errorf("must be no diff but %d", len(diffs))
+	}
+	expect := []string{"constraint positive_price of item is not supported by xmigrate and left as it is"}
+	if diff := cmp.Diff(expect, warnings); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+}
+
+func TestGetIndexes_ReferencedByForeignKey(t *testing.T) {
+	ctx := context.Background()
+	db, err := Connect(ctx, ConnInfo{"dbname": "xmigrate_test", "host": "localhost", "port": "5432", "user": "postgres"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer db.Close()
+	defer db.Exec("DROP TABLE IF EXISTS catalog_ref, catalog_item")
+
+	for _, s := range []string{
+		"CREATE TABLE catalog_item (id int, code text)",
+		"CREATE UNIQUE INDEX catalog_item_code_idx ON catalog_item (code)",
+		"CREATE TABLE catalog_ref (id int, item_code text REFERENCES catalog_item (code))",
+	} {
+		if _, err := db.ExecContext(ctx, s); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+
+	indexes, err := getIndexes(ctx, db, []string{"catalog_item", "catalog_ref"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	// the foreign key of catalog_ref depends on the index, but doesn't back it
+	if _, ok := indexes["catalog_item"]["catalog_item_code_idx"]; !ok {
+		t.Errorf("catalog_item_code_idx must be dumped but %+v", indexes)
+	}
+}
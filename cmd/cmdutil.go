@@ -2,61 +2,148 @@ package cmd
 
 import (
 	"context"
+	"io"
 	"os"
+	"strings"
 
+	"github.com/jmoiron/sqlx"
 	errors "golang.org/x/xerrors"
 
-	"github.com/akito0107/xsqlparser"
-	"github.com/akito0107/xsqlparser/dialect"
-	"github.com/akito0107/xsqlparser/sqlast"
-	"github.com/xo/dburl"
-
 	"github.com/akito0107/xmigrate"
+	"github.com/akito0107/xmigrate/toposort"
 )
 
-func GetDiff(ctx context.Context, schemapath string, url *dburl.URL) ([]*xmigrate.SchemaDiff, []*xmigrate.TableDef, error) {
-	schemafile, err := os.Open(schemapath)
+// ConnInfo returns the connection parameters of the db url src. DATABASE_URL is
+// used when src is empty, and PG* environment variables fill the rest on Connect.
+func ConnInfo(src string) (xmigrate.ConnInfo, error) {
+	if src == "" {
+		return xmigrate.ConnInfoFromEnv()
+	}
+	return xmigrate.ConnInfoFromURL(src)
+}
+
+// Connect connects to the database which the db url src (or DATABASE_URL) points to.
+func Connect(ctx context.Context, src string) (*sqlx.DB, error) {
+	info, err := ConnInfo(src)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	defer schemafile.Close()
+	return xmigrate.Connect(ctx, info)
+}
+
+func GetDiff(ctx context.Context, schemapath string, db *sqlx.DB, filter *xmigrate.Filter, dumpOpts []xmigrate.DumpOption, opts ...xmigrate.DiffOption) ([]*xmigrate.SchemaDiff, []*xmigrate.TableDef, error) {
+	dumper := xmigrate.NewPGDumpFromDB(db, append(dumpOpts, xmigrate.WithDumpFilter(filter))...)
+
+	return getSourceDiff(ctx, &xmigrate.SQLFileSource{Path: schemapath}, dumper, filter, opts...)
+}
 
-	parser, err := xsqlparser.NewParser(schemafile, &dialect.PostgresqlDialect{})
+// GetSourceDiff computes the migration which makes current match target.
+// Each of them is either a db url, a JSON snapshot (*.json) or a schema sql file.
+func GetSourceDiff(ctx context.Context, target, current string, filter *xmigrate.Filter, dumpOpts []xmigrate.DumpOption, opts ...xmigrate.DiffOption) ([]*xmigrate.SchemaDiff, []*xmigrate.TableDef, error) {
+	targ, err := NewSource(ctx, target, filter, dumpOpts...)
 	if err != nil {
 		return nil, nil, err
 	}
-	sqls, err := parser.ParseSQL()
+	defer CloseSource(targ)
+	curr, err := NewSource(ctx, current, filter, dumpOpts...)
 	if err != nil {
 		return nil, nil, err
 	}
+	defer CloseSource(curr)
 
-	var createTables []*sqlast.CreateTableStmt
-	var createIndexes []*sqlast.CreateIndexStmt
+	return getSourceDiff(ctx, targ, curr, filter, opts...)
+}
 
-	for _, s := range sqls {
+func getSourceDiff(ctx context.Context, target, current xmigrate.SchemaSource, filter *xmigrate.Filter, opts ...xmigrate.DiffOption) ([]*xmigrate.SchemaDiff, []*xmigrate.TableDef, error) {
+	diffs, schema, err := xmigrate.DiffSources(ctx, target, current, append(opts, xmigrate.WithFilter(filter))...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return diffs, schema.Tables, nil
+}
 
-		switch sql := s.(type) {
-		case *sqlast.CreateTableStmt:
-			createTables = append(createTables, sql)
-		case *sqlast.CreateIndexStmt:
-			createIndexes = append(createIndexes, sql)
-		default:
-			return nil, nil, errors.Errorf("unsupported sql: %s", s.ToSQLString())
+// NewSource returns SchemaSource which src points to.
+// It connects to the database when src is a db url, so it must be closed by CloseSource.
+func NewSource(ctx context.Context, src string, filter *xmigrate.Filter, opts ...xmigrate.DumpOption) (xmigrate.SchemaSource, error) {
+	switch {
+	case strings.Contains(src, "://"):
+		info, err := ConnInfo(src)
+		if err != nil {
+			return nil, err
 		}
+		return xmigrate.NewPGDumpFromConnInfo(ctx, info, append(opts, xmigrate.WithDumpFilter(filter))...)
+	case strings.HasSuffix(src, ".json"):
+		return &xmigrate.JSONSnapshotSource{Path: src}, nil
+	default:
+		return &xmigrate.SQLFileSource{Path: src}, nil
 	}
+}
 
-	dumper := xmigrate.NewPGDumpFromURL(url)
+// CloseSource closes the connection which NewSource opened.
+func CloseSource(src xmigrate.SchemaSource) error {
+	if c, ok := src.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
 
-	res, err := dumper.Dump(ctx)
+// FileChecksum returns xmigrate.SchemaChecksum of the file at path.
+func FileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		return nil, nil, err
+		return "", err
 	}
+	defer f.Close()
+	return xmigrate.SchemaChecksum(f)
+}
 
-	diffs, err := xmigrate.Diff(&xmigrate.TargetTable{TableDef: createTables, IndexDef: createIndexes}, res)
+// ResolvePlan orders diffs by their dependencies and returns the statements to apply.
+func ResolvePlan(diffs []*xmigrate.SchemaDiff) ([]string, error) {
+	resolved, err := toposort.ResolveGraph(xmigrate.CalcGraph(diffs))
 	if err != nil {
-		return nil, nil, err
+		return nil, err
+	}
+
+	var stmts []string
+	for _, n := range resolved.Nodes {
+		stmts = append(stmts, n.(*xmigrate.DiffNode).Diff.Spec.ToSQLString())
 	}
+	return stmts, nil
+}
 
-	return diffs, res, nil
+// ParseFilter builds xmigrate.Filter from rules formatted as "kind:pattern"
+// (e.g. "table:spatial_ref_sys", "index:/^tmp_/"), on top of xmigrate.DefaultFilter.
+func ParseFilter(includes, excludes []string) (*xmigrate.Filter, error) {
+	filter := xmigrate.DefaultFilter()
+	for _, r := range includes {
+		kind, pattern, err := parseFilterRule(r)
+		if err != nil {
+			return nil, err
+		}
+		if err := filter.Include(kind, pattern); err != nil {
+			return nil, err
+		}
+	}
+	for _, r := range excludes {
+		kind, pattern, err := parseFilterRule(r)
+		if err != nil {
+			return nil, err
+		}
+		if err := filter.Exclude(kind, pattern); err != nil {
+			return nil, err
+		}
+	}
+	return filter, nil
+}
 
+func parseFilterRule(rule string) (xmigrate.ObjectKind, string, error) {
+	kv := strings.SplitN(rule, ":", 2)
+	if len(kv) != 2 || kv[1] == "" {
+		return 0, "", errors.Errorf("filter rule must be kind:pattern but %s", rule)
+	}
+	kind, err := xmigrate.ParseObjectKind(kv[0])
+	if err != nil {
+		return 0, "", err
+	}
+	return kind, kv[1], nil
 }
@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -11,32 +10,27 @@ import (
 	"github.com/akito0107/xsqlparser"
 	"github.com/akito0107/xsqlparser/dialect"
 	"github.com/urfave/cli"
-	"github.com/xo/dburl"
 
 	"github.com/akito0107/xmigrate"
+	"github.com/akito0107/xmigrate/cmd"
 )
 
 func main() {
 	app := cli.NewApp()
 	app.Name = "pginverse"
 	app.Usage = "postgres db migration utility"
-	app.UsageText = "pginverse [db url] [OPTIONS]"
+	app.UsageText = "pginverse [db url (default: DATABASE_URL or PG* environment variables)] [OPTIONS]"
 	app.Flags = []cli.Flag{
 		cli.StringFlag{Name: "in,i", Value: "stdin", Usage: "input file name (default = stdin)"},
 		cli.StringFlag{Name: "out,o", Value: "stdout", Usage: "output file name (default = stdout)"},
 	}
 
 	app.Action = func(c *cli.Context) error {
-		dbsrc := c.Args().Get(0)
-		if dbsrc == "" {
-			return errors.New("db url is required")
-		}
-
-		u, err := dburl.Parse(dbsrc)
+		info, err := cmd.ConnInfo(c.Args().Get(0))
 		if err != nil {
 			return err
 		}
-		return diffAction(c, u)
+		return diffAction(c, info)
 	}
 
 	if err := app.Run(os.Args); err != nil {
@@ -44,7 +38,7 @@ func main() {
 	}
 }
 
-func diffAction(c *cli.Context, u *dburl.URL) error {
+func diffAction(c *cli.Context, info xmigrate.ConnInfo) error {
 	ctx := context.Background()
 
 	insrc := c.GlobalString("in")
@@ -91,7 +85,11 @@ func diffAction(c *cli.Context, u *dburl.URL) error {
 		return err
 	}
 
-	dumper := xmigrate.NewPGDumpFromURL(u)
+	dumper, err := xmigrate.NewPGDumpFromConnInfo(ctx, info)
+	if err != nil {
+		return err
+	}
+	defer dumper.Close()
 	current, err := dumper.Dump(ctx)
 	if err != nil {
 		return err
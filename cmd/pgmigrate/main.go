@@ -6,37 +6,79 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/urfave/cli"
-	"github.com/xo/dburl"
 
 	"github.com/akito0107/xmigrate"
 	"github.com/akito0107/xmigrate/cmd"
-	"github.com/akito0107/xmigrate/toposort"
 )
 
+// filterFlags choose the objects to read and how to read them. They are shared by sync, diff and dump.
+var filterFlags = []cli.Flag{
+	cli.StringSliceFlag{Name: "include", Usage: "manage only objects which match kind:pattern (e.g. table:app_*)"},
+	cli.StringSliceFlag{Name: "exclude", Usage: "leave objects which match kind:pattern alone (e.g. table:spatial_ref_sys, index:/^tmp_/)"},
+	cli.IntFlag{Name: "jobs, j", Value: 1, Usage: "number of connections which read tables of the database in parallel"},
+}
+
+// diffFlags are shared by sync and diff.
+var diffFlags = append([]cli.Flag{
+	cli.BoolFlag{Name: "unmanaged-partitions", Usage: "leave partitions to other tools (e.g. pg_partman)"},
+	cli.BoolFlag{Name: "reorder-columns", Usage: "rebuild tables whose column order differs from the schema"},
+}, filterFlags...)
+
 func main() {
 	app := cli.NewApp()
 	app.Name = "pgmigrate"
 	app.Usage = "postgres db migration utility"
-	app.UsageText = "pgmigrate [db url] [OPTIONS]"
-	app.Flags = []cli.Flag{
+	app.UsageText = "pgmigrate [db url (default: DATABASE_URL or PG* environment variables)] [OPTIONS]"
+	app.Version = xmigrate.Version
+	app.Flags = append([]cli.Flag{
 		cli.StringFlag{Name: "schemapath, f", Value: "schema.sql", Usage: "schema sql path"},
 		cli.BoolFlag{Name: "apply, a", Usage: "apply migration"},
+		cli.BoolFlag{Name: "no-transaction", Usage: "apply statements one by one in autocommit mode instead of a transaction"},
+	}, diffFlags...)
+
+	app.Commands = []cli.Command{
+		{
+			Name:      "diff",
+			Usage:     "print the migration which makes the second schema match the first",
+			ArgsUsage: "[target schema] [current schema] (schema sql, JSON snapshot or db url)",
+			Flags:     diffFlags,
+			Action:    diffAction,
+		},
+		{
+			Name:      "dump",
+			Usage:     "write the schema as schema sql or JSON snapshot",
+			ArgsUsage: "[schema] (db url, JSON snapshot or schema sql)",
+			Flags: append([]cli.Flag{
+				cli.StringFlag{Name: "output, o", Usage: "output path (default: stdout)"},
+				cli.StringFlag{Name: "format", Value: "sql", Usage: "output format, sql or json"},
+			}, filterFlags...),
+			Action: dumpAction,
+		},
+		{
+			Name:      "status",
+			Usage:     "show recent applies and whether the database matches the schema",
+			ArgsUsage: "[db url] (default: DATABASE_URL or PG* environment variables)",
+			Flags: append([]cli.Flag{
+				cli.StringFlag{Name: "schemapath, f", Value: "schema.sql", Usage: "schema sql path"},
+				cli.IntFlag{Name: "limit, n", Value: 10, Usage: "number of applies to show"},
+			}, diffFlags...),
+			Action: statusAction,
+		},
 	}
 
 	app.Action = func(c *cli.Context) error {
-		dbsrc := c.Args().Get(0)
-		if dbsrc == "" {
-			return errors.New("db url is required")
-		}
-
-		u, err := dburl.Parse(dbsrc)
+		db, err := cmd.Connect(context.Background(), c.Args().Get(0))
 		if err != nil {
 			return err
 		}
-		return syncAction(c, u)
+		defer db.Close()
+		return syncAction(c, db)
 	}
 
 	if err := app.Run(os.Args); err != nil {
@@ -44,48 +86,216 @@ func main() {
 	}
 }
 
-func syncAction(c *cli.Context, u *dburl.URL) error {
-	ctx := context.Background()
+func diffAction(c *cli.Context) error {
+	target, current := c.Args().Get(0), c.Args().Get(1)
+	if target == "" || current == "" {
+		return errors.New("target and current schema are required")
+	}
 
-	schemapath := c.String("schemapath")
+	filter, err := cmd.ParseFilter(c.StringSlice("include"), c.StringSlice("exclude"))
+	if err != nil {
+		return err
+	}
 
-	diffs, _, err := cmd.GetDiff(ctx, schemapath, u)
+	diffs, _, err := cmd.GetSourceDiff(context.Background(), target, current, filter, dumpOptions(c), diffOptions(c)...)
 	if err != nil {
 		return err
 	}
 
-	apply := c.Bool("apply")
-	if !apply {
-		fmt.Println("dry-run mode (with --apply flag will be exec below queries)")
+	stmts, err := cmd.ResolvePlan(diffs)
+	if err != nil {
+		return err
+	}
+	for _, s := range stmts {
+		fmt.Printf("%s;\n", s)
 	}
-	var db *sqlx.DB
 
-	if apply {
-		d, err := sqlx.Open(u.Driver, u.DSN)
+	return nil
+}
+
+func dumpAction(c *cli.Context) error {
+	src := c.Args().Get(0)
+	if src == "" {
+		return errors.New("schema is required")
+	}
+	format := c.String("format")
+	if format != "sql" && format != "json" {
+		return fmt.Errorf("unknown format %s", format)
+	}
+
+	filter, err := cmd.ParseFilter(c.StringSlice("include"), c.StringSlice("exclude"))
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	source, err := cmd.NewSource(ctx, src, filter, dumpOptions(c)...)
+	if err != nil {
+		return err
+	}
+	defer cmd.CloseSource(source)
+	schema, err := source.Load(ctx)
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if path := c.String("output"); path != "" {
+		f, err := os.Create(path)
 		if err != nil {
 			return err
 		}
-		db = d
-		defer db.Close()
+		defer f.Close()
+		out = f
+	}
+
+	schema = filter.FilterSchema(schema)
+	if format == "json" {
+		return xmigrate.WriteSnapshot(out, schema)
+	}
+	return xmigrate.WriteSchemaSQL(out, schema)
+}
+
+func dumpOptions(c *cli.Context) []xmigrate.DumpOption {
+	return []xmigrate.DumpOption{xmigrate.WithParallelism(c.Int("jobs"))}
+}
+
+func diffOptions(c *cli.Context) []xmigrate.DiffOption {
+	opts := []xmigrate.DiffOption{
+		xmigrate.WithWarnings(func(msg string) {
+			fmt.Fprintf(os.Stderr, "warning: %s\n", msg)
+		}),
+	}
+	if c.Bool("unmanaged-partitions") {
+		opts = append(opts, xmigrate.WithUnmanagedPartitions())
+	}
+	if c.Bool("reorder-columns") {
+		opts = append(opts, xmigrate.WithColumnReorder())
+	}
+
+	return opts
+}
+
+func syncAction(c *cli.Context, db *sqlx.DB) error {
+	ctx := context.Background()
+
+	schemapath := c.String("schemapath")
+	filter, err := cmd.ParseFilter(c.StringSlice("include"), c.StringSlice("exclude"))
+	if err != nil {
+		return err
 	}
-	graph := xmigrate.CalcGraph(diffs)
-	resolved, err := toposort.ResolveGraph(graph)
+
+	diffs, _, err := cmd.GetDiff(ctx, schemapath, db, filter, dumpOptions(c), diffOptions(c)...)
 	if err != nil {
 		return err
 	}
 
-	for _, n := range resolved.Nodes {
-		d := n.(*xmigrate.DiffNode)
-		sql := d.Diff.Spec.ToSQLString()
+	stmts, err := cmd.ResolvePlan(diffs)
+	if err != nil {
+		return err
+	}
+	version, err := xmigrate.NewPGDumpFromDB(db).ServerVersion(ctx)
+	if err != nil {
+		return err
+	}
+	phases := xmigrate.SplitPhases(stmts, version)
+	if c.Bool("no-transaction") {
+		phases = []*xmigrate.ApplyPhase{{Statements: stmts}}
+	}
 
-		fmt.Printf("applying: %s\n", sql)
+	// phases are shown only when the plan is not a single transaction
+	current := 0
+	printStatement := func(phase int, stmt string) {
+		if phase != current && len(phases) > 1 {
+			fmt.Printf("-- phase %d/%d (%s)\n", phase, len(phases), phases[phase-1])
+		}
+		current = phase
+		fmt.Printf("applying: %s\n", stmt)
+	}
 
-		if apply {
-			if _, err := db.Exec(sql); err != nil {
-				return err
+	if !c.Bool("apply") {
+		fmt.Println("dry-run mode (with --apply flag will be exec below queries)")
+		for i, p := range phases {
+			for _, s := range p.Statements {
+				printStatement(i+1, s)
 			}
 		}
+		return nil
 	}
 
+	checksum, err := cmd.FileChecksum(schemapath)
+	if err != nil {
+		return err
+	}
+	return xmigrate.NewHistory(db).Apply(ctx, checksum, phases, xmigrate.WithStatementHook(printStatement))
+}
+
+func statusAction(c *cli.Context) error {
+	ctx := context.Background()
+
+	db, err := cmd.Connect(ctx, c.Args().Get(0))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	entries, err := xmigrate.NewHistory(db).Recent(ctx, c.Int("limit"))
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Println("no applies are recorded")
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tSTARTED\tFINISHED\tOUTCOME\tDB USER\tOS USER\tVERSION\tSCHEMA\tSTATEMENTS")
+		for _, e := range entries {
+			finished := "-"
+			if e.FinishedAt.Valid {
+				finished = e.FinishedAt.Time.Local().Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\n", e.ID, e.StartedAt.Local().Format(time.RFC3339), finished,
+				e.Outcome, e.DBUser, e.OSUser, e.ToolVersion, shortChecksum(e.SchemaChecksum), len(e.Statements))
+		}
+		w.Flush()
+		if latest := entries[0]; latest.Error != nil {
+			fmt.Printf("apply %d failed: %s\n", latest.ID, *latest.Error)
+		}
+	}
+
+	schemapath := c.String("schemapath")
+	filter, err := cmd.ParseFilter(c.StringSlice("include"), c.StringSlice("exclude"))
+	if err != nil {
+		return err
+	}
+	diffs, _, err := cmd.GetDiff(ctx, schemapath, db, filter, dumpOptions(c), diffOptions(c)...)
+	if err != nil {
+		return err
+	}
+	stmts, err := cmd.ResolvePlan(diffs)
+	if err != nil {
+		return err
+	}
+	checksum, err := cmd.FileChecksum(schemapath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println()
+	if len(stmts) == 0 {
+		fmt.Printf("%s (%s): the database matches the schema\n", schemapath, shortChecksum(checksum))
+		return nil
+	}
+	fmt.Printf("%s (%s): %d statement(s) pending\n", schemapath, shortChecksum(checksum), len(stmts))
+	for _, s := range stmts {
+		fmt.Printf("  %s;\n", s)
+	}
 	return nil
 }
+
+// shortChecksum returns the first 12 hex digits of sha256:<hex>.
+func shortChecksum(checksum string) string {
+	s := strings.TrimPrefix(checksum, "sha256:")
+	if len(s) > 12 {
+		s = s[:12]
+	}
+	return s
+}
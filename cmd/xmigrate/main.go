@@ -6,13 +6,11 @@ import (
 	"log"
 	"os"
 
-	"github.com/akito0107/xsqlparser"
-	"github.com/akito0107/xsqlparser/dialect"
-	"github.com/akito0107/xsqlparser/sqlast"
 	"github.com/jmoiron/sqlx"
 	"github.com/urfave/cli"
 
 	"github.com/akito0107/xmigrate"
+	"github.com/akito0107/xmigrate/cmd"
 )
 
 func main() {
@@ -22,11 +20,17 @@ func main() {
 	app.UsageText = "xmigrate [GLOBAL OPTIONS] [COMMANDS] [sub command options]"
 
 	app.Flags = []cli.Flag{
-		cli.StringFlag{Name: "host", Value: "127.0.0.1", Usage: "db host"},
-		cli.StringFlag{Name: "port,p", Value: "5432", Usage: "db host"},
-		cli.StringFlag{Name: "dbname,d", Value: "", Usage: "dbname"},
-		cli.StringFlag{Name: "password,W", Value: "", Usage: "password"},
-		cli.StringFlag{Name: "username,U", Value: "postgres", Usage: "db user name"},
+		// flags override DB_* environment variables, which PGConfFromEnv loads,
+		// and the rest are taken from PG* ones on connect.
+		cli.StringFlag{Name: "host", Usage: "db host (default: DB_HOST, PGHOST or 127.0.0.1)"},
+		cli.StringFlag{Name: "port,p", Usage: "db port (default: DB_PORT, PGPORT or 5432)"},
+		cli.StringFlag{Name: "dbname,d", Usage: "dbname (default: DB_NAME or PGDATABASE)"},
+		cli.StringFlag{Name: "password,W", Usage: "password (default: DB_PASSWORD, PGPASSWORD or password file)"},
+		cli.StringFlag{Name: "username,U", Usage: "db user name (default: DB_USER_NAME, PGUSER or postgres)"},
+		cli.StringFlag{Name: "sslmode", Usage: "disable, allow, prefer (default), require, verify-ca or verify-full (default: DB_SSL_MODE or PGSSLMODE)"},
+		cli.StringFlag{Name: "sslrootcert", Usage: "root certificate path (default: DB_SSL_ROOT_CERT or PGSSLROOTCERT)"},
+		cli.StringFlag{Name: "sslcert", Usage: "client certificate path (default: DB_SSL_CERT or PGSSLCERT)"},
+		cli.StringFlag{Name: "sslkey", Usage: "client key path (default: DB_SSL_KEY or PGSSLKEY)"},
 		cli.BoolFlag{Name: "verbose"},
 	}
 
@@ -47,60 +51,49 @@ func main() {
 }
 
 func getConf(c *cli.Context) *xmigrate.PGConf {
-	host := c.GlobalString("host")
-	port := c.GlobalString("port")
-	dbname := c.GlobalString("dbname")
-	password := c.GlobalString("password")
-	username := c.GlobalString("username")
-	return &xmigrate.PGConf{
-		DBName:     dbname,
-		DBHost:     host,
-		DBPort:     port,
-		DBPassword: password,
-		UserName:   username,
-	}
-}
-
-func getDiff(ctx context.Context, schemapath string, conf *xmigrate.PGConf) ([]*xmigrate.SchemaDiff, []*xmigrate.TableDef, error) {
-	schemafile, err := os.Open(schemapath)
-	if err != nil {
-		return nil, nil, err
-	}
-	defer schemafile.Close()
-
-	parser, err := xsqlparser.NewParser(schemafile, &dialect.PostgresqlDialect{})
-	if err != nil {
-		return nil, nil, err
-	}
-	sqls, err := parser.ParseSQL()
-	if err != nil {
-		return nil, nil, err
-	}
-
-	var createTables []*sqlast.CreateTableStmt
-
-	for _, s := range sqls {
-		c, ok := s.(*sqlast.CreateTableStmt)
-		if !ok {
-			continue
+	conf := xmigrate.PGConfFromEnv()
+	for name, field := range map[string]*string{
+		"host":        &conf.DBHost,
+		"port":        &conf.DBPort,
+		"dbname":      &conf.DBName,
+		"password":    &conf.DBPassword,
+		"username":    &conf.UserName,
+		"sslmode":     &conf.SSLMode,
+		"sslrootcert": &conf.SSLRootCert,
+		"sslcert":     &conf.SSLCert,
+		"sslkey":      &conf.SSLKey,
+	} {
+		if c.GlobalIsSet(name) {
+			*field = c.GlobalString(name)
 		}
-		createTables = append(createTables, c)
 	}
-
-	dumper := xmigrate.NewPGDump(conf)
-
-	res, err := dumper.Dump(ctx)
-	if err != nil {
-		return nil, nil, err
+	// defaults of xmigrate, which PG* environment variables take precedence over
+	for env, d := range map[string]struct {
+		field *string
+		value string
+	}{
+		"PGHOST": {&conf.DBHost, "127.0.0.1"},
+		"PGPORT": {&conf.DBPort, "5432"},
+		"PGUSER": {&conf.UserName, "postgres"},
+	} {
+		if *d.field == "" && os.Getenv(env) == "" {
+			*d.field = d.value
+		}
 	}
+	return conf
+}
 
-	diffs, err := xmigrate.Diff(&xmigrate.TargetTable{TableDef: createTables}, res)
+// getDiff computes the migration which makes the database match the schema
+// at schemapath in the same way as pgmigrate sync.
+func getDiff(ctx context.Context, schemapath string, db *sqlx.DB) ([]*xmigrate.SchemaDiff, error) {
+	diffs, _, err := cmd.GetDiff(ctx, schemapath, db, xmigrate.DefaultFilter(), nil,
+		xmigrate.WithWarnings(func(msg string) {
+			fmt.Fprintf(os.Stderr, "warning: %s\n", msg)
+		}))
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-
-	return diffs, res, nil
-
+	return diffs, nil
 }
 
 func syncAction(c *cli.Context) error {
@@ -109,7 +102,18 @@ func syncAction(c *cli.Context) error {
 	conf := getConf(c)
 	schemapath := c.String("schema")
 
-	diffs, _, err := getDiff(ctx, schemapath, conf)
+	db, err := xmigrate.Connect(ctx, conf.ConnInfo())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	diffs, err := getDiff(ctx, schemapath, db)
+	if err != nil {
+		return err
+	}
+
+	stmts, err := cmd.ResolvePlan(diffs)
 	if err != nil {
 		return err
 	}
@@ -117,27 +121,22 @@ func syncAction(c *cli.Context) error {
 	apply := c.Bool("apply")
 	if !apply {
 		fmt.Println("dry-run mode (with --apply flag will be exec below queries)")
-	}
-	var db *sqlx.DB
-
-	if apply {
-		d, err := sqlx.Open("postgres", conf.Src())
-		if err != nil {
-			return err
+		for _, s := range stmts {
+			fmt.Printf("applying: %s\n", s)
 		}
-		db = d
-		defer db.Close()
+		return nil
 	}
 
-	for _, d := range diffs {
-		sql := d.Spec.ToSQLString()
-		fmt.Printf("applying: %s\n", sql)
-		if apply {
-			if _, err := db.Exec(sql); err != nil {
-				return err
-			}
-		}
+	version, err := xmigrate.NewPGDumpFromDB(db).ServerVersion(ctx)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	checksum, err := cmd.FileChecksum(schemapath)
+	if err != nil {
+		return err
+	}
+	return xmigrate.NewHistory(db).Apply(ctx, checksum, xmigrate.SplitPhases(stmts, version), xmigrate.WithStatementHook(func(_ int, s string) {
+		fmt.Printf("applying: %s\n", s)
+	}))
 }
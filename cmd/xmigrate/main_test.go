@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/akito0107/xmigrate"
+)
+
+func TestGetDiff_DumpedSchema(t *testing.T) {
+	ctx := context.Background()
+	db, err := xmigrate.Connect(ctx, xmigrate.ConnInfo{"dbname": "xmigrate_test", "host": "localhost", "port": "5432", "user": "postgres"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer db.Close()
+	defer db.Exec("DROP TABLE IF EXISTS xmigrate_cli_event, xmigrate_cli_item")
+
+	for _, s := range []string{
+		`CREATE UNLOGGED TABLE xmigrate_cli_item (
+			id serial PRIMARY KEY,
+			name text COLLATE "C" NOT NULL,
+			area box,
+			EXCLUDE USING gist (area WITH &&)
+		) WITH (fillfactor = 70)`,
+		"CREATE INDEX xmigrate_cli_item_name_idx ON xmigrate_cli_item (lower(name))",
+		"CREATE TABLE xmigrate_cli_event (id int, created_at timestamptz) PARTITION BY RANGE (created_at)",
+		"CREATE TABLE xmigrate_cli_event_2019 PARTITION OF xmigrate_cli_event FOR VALUES FROM ('2019-01-01') TO ('2020-01-01')",
+	} {
+		if _, err := db.ExecContext(ctx, s); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+
+	schema, err := xmigrate.NewPGDumpFromDB(db).Load(ctx)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	f, err := ioutil.TempFile("", "xmigrate-schema-*.sql")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer os.Remove(f.Name())
+	if err := xmigrate.WriteSchemaSQL(f, schema); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	diffs, err := getDiff(ctx, f.Name(), db)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	for _, d := range diffs {
+		t.Errorf("must be no diff but %s", d.Spec.ToSQLString())
+	}
+}
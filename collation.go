@@ -0,0 +1,237 @@
+package xmigrate
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/akito0107/xsqlparser/sqlast"
+	errors "golang.org/x/xerrors"
+)
+
+// CollationDef is a collation created with CREATE COLLATION.
+type CollationDef struct {
+	Name string
+	// Provider is either icu or libc.
+	Provider      string
+	Locale        string
+	LcCollate     string
+	LcCtype       string
+	Deterministic bool
+}
+
+func (c *CollationDef) ToSQLString() string {
+	var opts []string
+	if c.Provider != "" {
+		opts = append(opts, "provider = "+c.Provider)
+	}
+	if c.Locale != "" {
+		opts = append(opts, "locale = "+quoteLiteral(c.Locale))
+	}
+	if c.LcCollate != "" {
+		opts = append(opts, "lc_collate = "+quoteLiteral(c.LcCollate))
+	}
+	if c.LcCtype != "" {
+		opts = append(opts, "lc_ctype = "+quoteLiteral(c.LcCtype))
+	}
+	if !c.Deterministic {
+		opts = append(opts, "deterministic = false")
+	}
+
+	return fmt.Sprintf("CREATE COLLATION %s (%s)", quoteIdent(c.Name), strings.Join(opts, ", "))
+}
+
+func (c *CollationDef) equal(o *CollationDef) bool {
+	if !strings.EqualFold(c.Provider, o.Provider) || c.Deterministic != o.Deterministic {
+		return false
+	}
+	// locale sets both lc_collate and lc_ctype, which are distinct for libc.
+	lcCollate := func(d *CollationDef) string {
+		if d.LcCollate != "" {
+			return d.LcCollate
+		}
+		return d.Locale
+	}
+	if !strings.EqualFold(c.Provider, "libc") {
+		return lcCollate(c) == lcCollate(o)
+	}
+	lcCtype := func(d *CollationDef) string {
+		if d.LcCtype != "" {
+			return d.LcCtype
+		}
+		return d.Locale
+	}
+	return lcCollate(c) == lcCollate(o) && lcCtype(c) == lcCtype(o)
+}
+
+var createCollationPattern = regexp.MustCompile(`(?is)^\s*create\s+collation\s+(?:if\s+not\s+exists\s+)?("(?:[^"]|"")+"|[\w.]+)\s*\((.*)\)\s*$`)
+
+// parseCreateCollation parses CREATE COLLATION statement.
+// It returns nil if stmt is not a CREATE COLLATION.
+func parseCreateCollation(stmt string) (*CollationDef, error) {
+	m := createCollationPattern.FindStringSubmatch(stmt)
+	if m == nil {
+		return nil, nil
+	}
+
+	def := &CollationDef{
+		Name:          collationName(m[1]),
+		Provider:      "libc",
+		Deterministic: true,
+	}
+
+	for _, opt := range splitTopLevel(m[2], ',') {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.Errorf("malformed collation option: %s", opt)
+		}
+		value := unquoteLiteral(strings.TrimSpace(kv[1]))
+
+		switch strings.ToLower(strings.TrimSpace(kv[0])) {
+		case "provider":
+			def.Provider = strings.ToLower(value)
+		case "locale":
+			def.Locale = value
+		case "lc_collate":
+			def.LcCollate = value
+		case "lc_ctype":
+			def.LcCtype = value
+		case "deterministic":
+			def.Deterministic = !strings.EqualFold(value, "false")
+		default:
+			return nil, errors.Errorf("unsupported collation option: %s", opt)
+		}
+	}
+
+	return def, nil
+}
+
+type CreateCollationSpec struct {
+	Def *CollationDef
+}
+
+func (c *CreateCollationSpec) ToSQLString() string {
+	return c.Def.ToSQLString()
+}
+
+type DropCollationSpec struct {
+	Name string
+}
+
+func (d *DropCollationSpec) ToSQLString() string {
+	return fmt.Sprintf("DROP COLLATION IF EXISTS %s", quoteIdent(d.Name))
+}
+
+// DiffCollations compares collations declared in schema with current ones.
+func DiffCollations(targ []*CollationDef, current []*CollationDef) ([]*SchemaDiff, error) {
+	var diffs []*SchemaDiff
+
+	currentState := make(map[string]*CollationDef)
+	for _, c := range current {
+		currentState[c.Name] = c
+	}
+	targetState := make(map[string]*CollationDef)
+	for _, t := range targ {
+		targetState[t.Name] = t
+	}
+
+	for _, t := range targ {
+		c, ok := currentState[t.Name]
+		if !ok {
+			diffs = append(diffs, &SchemaDiff{
+				Type: AddCollation,
+				Spec: &CreateCollationSpec{Def: t},
+			})
+			continue
+		}
+		if !t.equal(c) {
+			return nil, errors.Errorf("changing collation %s is not supported", t.Name)
+		}
+	}
+
+	for _, c := range current {
+		if _, ok := targetState[c.Name]; !ok {
+			diffs = append(diffs, &SchemaDiff{
+				Type: DropCollation,
+				Spec: &DropCollationSpec{Name: c.Name},
+			})
+		}
+	}
+
+	return diffs, nil
+}
+
+// collationName returns the name of collation as it is stored in catalog.
+// Unquoted names are folded to lower case and "default" means no collation.
+func collationName(src string) string {
+	src = strings.TrimSpace(src)
+	var name string
+	if strings.HasPrefix(src, `"`) && strings.HasSuffix(src, `"`) && len(src) > 1 {
+		name = strings.Replace(src[1:len(src)-1], `""`, `"`, -1)
+	} else {
+		name = strings.ToLower(src)
+	}
+	// collations are looked up in public or pg_catalog schema
+	name = strings.TrimPrefix(strings.TrimPrefix(name, "pg_catalog."), "public.")
+	if name == "default" {
+		return ""
+	}
+	return name
+}
+
+var columnCollatePattern = regexp.MustCompile(`(?is)^collate\s+("(?:[^"]|"")+"|[\w.]+)`)
+
+// stripColumnCollation cuts COLLATE clause off from column definition.
+func stripColumnCollation(elem string) (string, string) {
+	for i := 0; i < len(elem); {
+		if i > 0 && isSpaceByte(elem[i-1]) {
+			if m := columnCollatePattern.FindStringSubmatchIndex(elem[i:]); m != nil {
+				rest := strings.TrimSpace(strings.TrimSpace(elem[:i]) + " " + strings.TrimSpace(elem[i+m[1]:]))
+				return rest, collationName(elem[i+m[2] : i+m[3]])
+			}
+		}
+		i = skipQuoted(elem, i)
+	}
+	return elem, ""
+}
+
+// stripCollations cuts COLLATE clauses off from CREATE TABLE statement and
+// returns them keyed by column name folded as Identifier.
+func stripCollations(stmt string) (string, map[string]string, error) {
+	collations := make(map[string]string)
+	s, err := mapCreateTableElements(stmt, func(elem string) (string, error) {
+		if isTableConstraintElement(elem) {
+			return elem, nil
+		}
+		e, coll := stripColumnCollation(elem)
+		if coll != "" {
+			collations[elementName(e)] = coll
+		}
+		return e, nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	return s, collations, nil
+}
+
+// columnDefSQL renders column definition with its collation.
+func columnDefSQL(def *sqlast.ColumnDef, collation string) string {
+	s := def.ToSQLString()
+	if collation == "" {
+		return s
+	}
+	prefix := def.Name.ToSQLString() + " " + def.DataType.ToSQLString()
+	if !strings.HasPrefix(s, prefix) {
+		return s
+	}
+	return prefix + collateClause(collation) + s[len(prefix):]
+}
+
+func collateClause(collation string) string {
+	if collation == "default" {
+		return ` COLLATE "default"`
+	}
+	return " COLLATE " + quoteIdent(collation)
+}
@@ -0,0 +1,147 @@
+package xmigrate
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/akito0107/xsqlparser/sqlast"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseCreateCollation(t *testing.T) {
+	cases := []struct {
+		name   string
+		src    string
+		expect *CollationDef
+	}{
+		{
+			name: "icu",
+			src:  `CREATE COLLATION german_phonebook (provider = icu, locale = 'de-u-co-phonebk')`,
+			expect: &CollationDef{
+				Name:          "german_phonebook",
+				Provider:      "icu",
+				Locale:        "de-u-co-phonebk",
+				Deterministic: true,
+			},
+		},
+		{
+			name: "nondeterministic",
+			src:  `create collation "Case_Insensitive" (provider = icu, locale = 'und-u-ks-level2', deterministic = false)`,
+			expect: &CollationDef{
+				Name:     "Case_Insensitive",
+				Provider: "icu",
+				Locale:   "und-u-ks-level2",
+			},
+		},
+		{
+			name:   "not a collation",
+			src:    "create table a (id int)",
+			expect: nil,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			def, err := parseCreateCollation(c.src)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if diff := cmp.Diff(def, c.expect); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestStripCollations(t *testing.T) {
+	s, collations, err := stripCollations(`create table account (id int primary key, email varchar collate "C" not null, name text COLLATE german_phonebook)`)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if s != "create table account (id int primary key, email varchar not null, name text)" {
+		t.Errorf("unexpected statement %s", s)
+	}
+
+	if diff := cmp.Diff(collations, map[string]string{"email": "C", "name": "german_phonebook"}); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+}
+
+func TestDiff_Collation(t *testing.T) {
+	targ, err := ParseSchema(bytes.NewBufferString(`create table test1(id int primary key, name varchar collate "C");`))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	curr := genTableDef(t, "create table test1(id int primary key, name varchar);")
+
+	diff, err := Diff(targ, curr)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	expect := []*SchemaDiff{
+		{
+			Type: EditColumn,
+			Spec: &EditColumnSpec{
+				Type:       EditType,
+				TableName:  "test1",
+				ColumnName: "name",
+				SQL: &sqlast.AlterTableStmt{
+					TableName: sqlast.NewSQLObjectName("test1"),
+					Action: &sqlast.AlterColumnTableAction{
+						ColumnName: sqlast.NewIdent("name"),
+						Action: &sqlast.PGAlterDataTypeColumnAction{
+							DataType: &sqlast.VarcharType{},
+						},
+					},
+				},
+				Collation: "C",
+			},
+		},
+	}
+
+	if d := cmp.Diff(diff, expect, IgnoreMarker); d != "" {
+		t.Errorf("Diff: %s", d)
+	}
+}
+
+func TestDiffCollations(t *testing.T) {
+	targ := []*CollationDef{
+		{Name: "german_phonebook", Provider: "icu", Locale: "de-u-co-phonebk", Deterministic: true},
+	}
+	current := []*CollationDef{
+		{Name: "unused", Provider: "libc", LcCollate: "C", LcCtype: "C", Deterministic: true},
+	}
+
+	diffs, err := DiffCollations(targ, current)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	expect := []*SchemaDiff{
+		{Type: AddCollation, Spec: &CreateCollationSpec{Def: targ[0]}},
+		{Type: DropCollation, Spec: &DropCollationSpec{Name: "unused"}},
+	}
+	if d := cmp.Diff(diffs, expect); d != "" {
+		t.Errorf("Diff: %s", d)
+	}
+
+	if _, err := DiffCollations(targ, []*CollationDef{
+		{Name: "german_phonebook", Provider: "icu", Locale: "de-u-co", Deterministic: true},
+	}); err == nil {
+		t.Errorf("changing collation must be an error")
+	}
+
+	libc := []*CollationDef{{Name: "c_upper", Provider: "libc", LcCollate: "C", LcCtype: "C", Deterministic: true}}
+	if _, err := DiffCollations(libc, []*CollationDef{
+		{Name: "c_upper", Provider: "libc", LcCollate: "C", LcCtype: "en_US.utf8", Deterministic: true},
+	}); err == nil {
+		t.Errorf("changing lc_ctype must be an error")
+	}
+	if diffs, err := DiffCollations(libc, []*CollationDef{
+		{Name: "c_upper", Provider: "libc", Locale: "C", Deterministic: true},
+	}); err != nil || len(diffs) != 0 {
+		t.Errorf("locale must be same as lc_collate and lc_ctype but %v, %+v", diffs, err)
+	}
+}
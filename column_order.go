@@ -0,0 +1,265 @@
+package xmigrate
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/akito0107/xsqlparser/sqlast"
+	errors "golang.org/x/xerrors"
+)
+
+// columnOrder returns the column order of targ and the one which current
+// table will have after applying the usual diffs, in which added columns
+// are appended to the end of the table.
+func columnOrder(targ *sqlast.CreateTableStmt, current *TableDef) ([]string, []string) {
+	var targOrder []string
+	inTarget := make(map[string]struct{})
+	for _, e := range targ.Elements {
+		c, ok := e.(*sqlast.ColumnDef)
+		if !ok {
+			continue
+		}
+		name := string(ParseIdentifier(c.Name.ToSQLString()))
+		targOrder = append(targOrder, name)
+		inTarget[name] = struct{}{}
+	}
+
+	var result []string
+	for _, n := range current.ColumnOrder {
+		if _, ok := inTarget[n]; ok {
+			result = append(result, n)
+		}
+	}
+	for _, n := range targOrder {
+		if _, ok := current.Columns[n]; !ok {
+			result = append(result, n)
+		}
+	}
+
+	return targOrder, result
+}
+
+func sameOrder(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func columnOrderWarning(table Identifier, targ, result []string) string {
+	return fmt.Sprintf("column order of %s differs from the schema: expected (%s) but (%s)",
+		table.ToSQLString(), strings.Join(targ, ", "), strings.Join(result, ", "))
+}
+
+// RebuildStep is a statement of rebuilding a table.
+type RebuildStep int
+
+const (
+	// RebuildCopy copies the rows to a temporary table.
+	RebuildCopy RebuildStep = iota
+	// RebuildDrop drops the old table.
+	RebuildDrop
+	// RebuildCreate creates the table with the column order of the schema.
+	RebuildCreate
+	// RebuildRestore copies the rows back from the temporary table.
+	RebuildRestore
+	// RebuildRestartSequences restarts the sequences of serial columns after the copied rows.
+	RebuildRestartSequences
+	// RebuildDropCopy drops the temporary table.
+	RebuildDropCopy
+)
+
+// RebuildTableSpec is a step of re-creating a table to reorder its columns.
+// Rows are copied through a temporary table, so the whole table is rewritten
+// and locked while it runs. Each step is a statement, which the graph keeps
+// in order.
+// Foreign keys of other tables which reference the table make DROP TABLE
+// fail, so Diff rejects the rebuild while they exist.
+type RebuildTableSpec struct {
+	Step  RebuildStep
+	Table *AddTableSpec
+	// Columns are copied from the old table.
+	Columns []Identifier
+	// SerialColumns are columns whose sequence is restarted after copying.
+	// Identity columns are included since pg_get_serial_sequence finds their sequences too.
+	SerialColumns []Identifier
+	// OverridingSystemValue is set when the old table has GENERATED ALWAYS identity
+	// columns, whose values can be copied only with OVERRIDING SYSTEM VALUE.
+	OverridingSystemValue bool
+	// PrevCollations are non default collations of the old table, which are released by the rebuild.
+	PrevCollations map[string]string
+}
+
+func (r *RebuildTableSpec) tableName() Identifier {
+	return ParseIdentifier(r.Table.SQL.Name.ToSQLString())
+}
+
+func (r *RebuildTableSpec) ToSQLString() string {
+	name := r.tableName()
+	tmp := rebuildCopyName(name)
+
+	var cols []string
+	for _, c := range r.Columns {
+		cols = append(cols, c.ToSQLString())
+	}
+	columns := strings.Join(cols, ", ")
+
+	switch r.Step {
+	case RebuildCopy:
+		return fmt.Sprintf("CREATE TABLE %s AS TABLE %s", tmp.ToSQLString(), name.ToSQLString())
+	case RebuildDrop:
+		return fmt.Sprintf("DROP TABLE %s", name.ToSQLString())
+	case RebuildCreate:
+		return r.Table.ToSQLString()
+	case RebuildRestore:
+		overriding := ""
+		if r.OverridingSystemValue {
+			overriding = " OVERRIDING SYSTEM VALUE"
+		}
+		return fmt.Sprintf("INSERT INTO %s (%s)%s SELECT %s FROM %s", name.ToSQLString(), columns, overriding, columns, tmp.ToSQLString())
+	case RebuildRestartSequences:
+		var setvals []string
+		for _, c := range r.SerialColumns {
+			setvals = append(setvals, fmt.Sprintf("setval(pg_get_serial_sequence(%s, %s), coalesce(max(%s), 0) + 1, false)",
+				quoteLiteral(name.ToSQLString()), quoteLiteral(string(c)), c.ToSQLString()))
+		}
+		return fmt.Sprintf("SELECT %s FROM %s", strings.Join(setvals, ", "), name.ToSQLString())
+	default:
+		return fmt.Sprintf("DROP TABLE %s", tmp.ToSQLString())
+	}
+}
+
+// maxIdentifierLen is NAMEDATALEN - 1. PostgreSQL truncates longer names.
+const maxIdentifierLen = 63
+
+// rebuildCopyName returns the name of the temporary table which rows of table are copied to.
+// Names too long are shortened with the hash of the table name, so they don't collide.
+func rebuildCopyName(table Identifier) Identifier {
+	name := "xmigrate_rebuild_" + string(table)
+	if len(name) <= maxIdentifierLen {
+		return Identifier(name)
+	}
+	h := fnv.New32a()
+	h.Write([]byte(table))
+	suffix := fmt.Sprintf("_%08x", h.Sum32())
+	name = name[:maxIdentifierLen-len(suffix)]
+	// don't cut a multibyte character in the middle
+	for !utf8.ValidString(name) {
+		name = name[:len(name)-1]
+	}
+	return Identifier(name + suffix)
+}
+
+// createRebuildTableSpecs returns the steps of rebuilding current as table in order.
+// Rows must be copied as they are, so tables with stored generated columns and
+// new NOT NULL columns without default are rejected, and so are tables with
+// constraints which the schema can't create again.
+func createRebuildTableSpecs(table *AddTableSpec, current *TableDef) ([]*SchemaDiff, error) {
+	if len(current.UnsupportedConstraints) > 0 {
+		return nil, errors.Errorf("rebuilding %s drops constraints which xmigrate doesn't manage: %s",
+			current.Name, strings.Join(current.UnsupportedConstraints, ", "))
+	}
+	base := RebuildTableSpec{Table: table}
+	if len(current.ColumnCollations) > 0 {
+		base.PrevCollations = current.ColumnCollations
+	}
+	for _, e := range table.SQL.Elements {
+		c, ok := e.(*sqlast.ColumnDef)
+		if !ok {
+			continue
+		}
+		name := ParseIdentifier(c.Name.ToSQLString())
+		serial := false
+		switch CanonicalType(c.DataType) {
+		case "smallserial", "serial", "bigserial":
+			serial = true
+		}
+		if _, ok := current.Columns[string(name)]; !ok {
+			if !serial && c.Default == nil && isNotNullColumn(c) {
+				return nil, errors.Errorf("column %s of %s is NOT NULL without default, so rows can't be copied", name, current.Name)
+			}
+			if serial {
+				base.SerialColumns = append(base.SerialColumns, name)
+			}
+			continue
+		}
+		if _, ok := current.GeneratedColumns[string(name)]; ok {
+			return nil, errors.Errorf("column %s of %s is a generated column, so rows can't be copied", name, current.Name)
+		}
+		kind, identity := current.IdentityColumns[string(name)]
+		if serial || identity {
+			base.SerialColumns = append(base.SerialColumns, name)
+		}
+		if kind == IdentityAlways {
+			base.OverridingSystemValue = true
+		}
+		base.Columns = append(base.Columns, name)
+	}
+
+	var diffs []*SchemaDiff
+	for step := RebuildCopy; step <= RebuildDropCopy; step++ {
+		if step == RebuildRestartSequences && len(base.SerialColumns) == 0 {
+			continue
+		}
+		spec := base
+		spec.Step = step
+		diffs = append(diffs, &SchemaDiff{Type: RebuildTable, Spec: &spec})
+	}
+	return diffs, nil
+}
+
+// isNotNullColumn reports whether c is declared NOT NULL or PRIMARY KEY.
+func isNotNullColumn(c *sqlast.ColumnDef) bool {
+	for _, cons := range c.Constraints {
+		switch spec := cons.Spec.(type) {
+		case *sqlast.NotNullColumnSpec:
+			return true
+		case *sqlast.UniqueColumnSpec:
+			if spec.IsPrimaryKey {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// referencingForeignKeys returns foreign keys of the other tables which reference table.
+func referencingForeignKeys(table Identifier, tables []*TableDef) []string {
+	var fks []string
+	for _, t := range tables {
+		if t.Name == table {
+			continue
+		}
+		for _, c := range t.Constrains {
+			ref, ok := c.Spec.(*sqlast.ReferentialTableConstraint)
+			if ok && ParseIdentifier(ref.KeyExpr.TableName.ToSQLString()) == table {
+				fks = append(fks, foreignKeyName(t.Name, c.Name))
+			}
+		}
+		for _, col := range t.OrderedColumns() {
+			for _, c := range col.Constraints {
+				ref, ok := c.Spec.(*sqlast.ReferencesColumnSpec)
+				if ok && ParseIdentifier(ref.TableName.ToSQLString()) == table {
+					fks = append(fks, foreignKeyName(t.Name, c.Name))
+				}
+			}
+		}
+	}
+	sort.Strings(fks)
+	return fks
+}
+
+func foreignKeyName(table Identifier, name *sqlast.Ident) string {
+	if n := constraintName(name); n != "" {
+		return string(table) + "." + n
+	}
+	return string(table) + " (unnamed)"
+}
@@ -0,0 +1,281 @@
+package xmigrate
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/akito0107/xsqlparser/sqlast"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTableDef_OrderedColumns(t *testing.T) {
+	def := &TableDef{
+		Name: "account",
+		Columns: map[string]*sqlast.ColumnDef{
+			"id":    {Name: sqlast.NewIdent("id"), DataType: &sqlast.Int{}},
+			"name":  {Name: sqlast.NewIdent("name"), DataType: &sqlast.Int{}},
+			"email": {Name: sqlast.NewIdent("email"), DataType: &sqlast.Int{}},
+			"age":   {Name: sqlast.NewIdent("age"), DataType: &sqlast.Int{}},
+		},
+		ColumnOrder: []string{"name", "id"},
+	}
+
+	var act []string
+	for _, c := range def.OrderedColumns() {
+		act = append(act, c.Name.ToSQLString())
+	}
+
+	if diff := cmp.Diff([]string{"name", "id", "age", "email"}, act); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+}
+
+func TestDiff_ColumnOrder(t *testing.T) {
+	current := []*TableDef{
+		{
+			Name: "account",
+			Columns: map[string]*sqlast.ColumnDef{
+				"id":   {Name: sqlast.NewIdent("id"), DataType: &sqlast.Custom{Ty: sqlast.NewSQLObjectName("serial")}},
+				"age":  {Name: sqlast.NewIdent("age"), DataType: &sqlast.Int{}},
+				"name": {Name: sqlast.NewIdent("name"), DataType: &sqlast.Int{}},
+			},
+			ColumnOrder: []string{"id", "age", "name"},
+		},
+	}
+
+	t.Run("appended column", func(t *testing.T) {
+		targ, err := ParseSchema(bytes.NewBufferString("create table account (id serial, age int, name int, email int);"))
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		var warnings []string
+		diffs, err := Diff(targ, current, WithWarnings(func(msg string) { warnings = append(warnings, msg) }))
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if len(diffs) != 1 || diffs[0].Type != AddColumn {
+			t.Errorf("unexpected diffs %+v", diffs)
+		}
+		if len(warnings) != 0 {
+			t.Errorf("must be no warning but %v", warnings)
+		}
+	})
+
+	t.Run("warning", func(t *testing.T) {
+		targ, err := ParseSchema(bytes.NewBufferString("create table account (id serial, name int, age int);"))
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		var warnings []string
+		diffs, err := Diff(targ, current, WithWarnings(func(msg string) { warnings = append(warnings, msg) }))
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if len(diffs) != 0 {
+			t.Errorf("must be no diff but %d", len(diffs))
+		}
+		expect := []string{"column order of account differs from the schema: expected (id, name, age) but (id, age, name)"}
+		if diff := cmp.Diff(expect, warnings); diff != "" {
+			t.Errorf("diff: %s", diff)
+		}
+	})
+
+	t.Run("rebuild", func(t *testing.T) {
+		targ, err := ParseSchema(bytes.NewBufferString("create table account (id serial, name int, email int);"))
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		diffs, err := Diff(targ, current, WithColumnReorder())
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		var steps []RebuildStep
+		for _, d := range diffs {
+			if d.Type != RebuildTable {
+				t.Fatalf("unexpected diffs %+v", diffs)
+			}
+			steps = append(steps, d.Spec.(*RebuildTableSpec).Step)
+		}
+		expect := []RebuildStep{RebuildCopy, RebuildDrop, RebuildCreate, RebuildRestore, RebuildRestartSequences, RebuildDropCopy}
+		if diff := cmp.Diff(expect, steps); diff != "" {
+			t.Errorf("diff: %s", diff)
+		}
+		spec := diffs[0].Spec.(*RebuildTableSpec)
+		if diff := cmp.Diff([]Identifier{"id", "name"}, spec.Columns); diff != "" {
+			t.Errorf("diff: %s", diff)
+		}
+		if diff := cmp.Diff([]Identifier{"id"}, spec.SerialColumns); diff != "" {
+			t.Errorf("diff: %s", diff)
+		}
+	})
+
+	t.Run("referenced", func(t *testing.T) {
+		referencing := &TableDef{
+			Name: "session",
+			Columns: map[string]*sqlast.ColumnDef{
+				"account_id": {
+					Name:     sqlast.NewIdent("account_id"),
+					DataType: &sqlast.Int{},
+					Constraints: []*sqlast.ColumnConstraint{{
+						Name: sqlast.NewIdent("session_account_id_fkey"),
+						Spec: &sqlast.ReferencesColumnSpec{TableName: sqlast.NewSQLObjectName("account"), Columns: []*sqlast.Ident{sqlast.NewIdent("id")}},
+					}},
+				},
+			},
+			ColumnOrder: []string{"account_id"},
+		}
+		targ, err := ParseSchema(bytes.NewBufferString("create table account (id serial, name int, age int); create table session (account_id int references account (id));"))
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		_, err = Diff(targ, append(current, referencing), WithColumnReorder())
+		if err == nil || !strings.Contains(err.Error(), "session.session_account_id_fkey") {
+			t.Errorf("must be error naming the foreign key but %+v", err)
+		}
+	})
+}
+
+func TestRebuildTableSpec_ToSQLString(t *testing.T) {
+	spec := &RebuildTableSpec{
+		Table: &AddTableSpec{
+			SQL: &sqlast.CreateTableStmt{
+				Name: sqlast.NewSQLObjectName("account"),
+				Elements: []sqlast.TableElement{
+					&sqlast.ColumnDef{Name: sqlast.NewIdent("id"), DataType: &sqlast.Custom{Ty: sqlast.NewSQLObjectName("serial")}},
+					&sqlast.ColumnDef{Name: sqlast.NewIdent("name"), DataType: &sqlast.Int{}},
+				},
+			},
+		},
+		Columns:       []Identifier{"id", "name"},
+		SerialColumns: []Identifier{"id"},
+	}
+
+	expect := []string{
+		"CREATE TABLE xmigrate_rebuild_account AS TABLE account",
+		"DROP TABLE account",
+		"CREATE TABLE account (id serial, name int)",
+		"INSERT INTO account (id, name) SELECT id, name FROM xmigrate_rebuild_account",
+		"SELECT setval(pg_get_serial_sequence('account', 'id'), coalesce(max(id), 0) + 1, false) FROM account",
+		"DROP TABLE xmigrate_rebuild_account",
+	}
+
+	var act []string
+	for step := RebuildCopy; step <= RebuildDropCopy; step++ {
+		s := *spec
+		s.Step = step
+		act = append(act, s.ToSQLString())
+	}
+	if diff := cmp.Diff(expect, act); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+}
+
+func TestCreateRebuildTableSpecs(t *testing.T) {
+	table := func(columns ...*sqlast.ColumnDef) *AddTableSpec {
+		var elements []sqlast.TableElement
+		for _, c := range columns {
+			elements = append(elements, c)
+		}
+		return &AddTableSpec{SQL: &sqlast.CreateTableStmt{Name: sqlast.NewSQLObjectName("account"), Elements: elements}}
+	}
+	current := func() *TableDef {
+		return &TableDef{
+			Name: "account",
+			Columns: map[string]*sqlast.ColumnDef{
+				"id":   {Name: sqlast.NewIdent("id"), DataType: &sqlast.Int{}},
+				"name": {Name: sqlast.NewIdent("name"), DataType: &sqlast.Int{}},
+			},
+			ColumnOrder: []string{"id", "name"},
+		}
+	}
+	id := &sqlast.ColumnDef{Name: sqlast.NewIdent("id"), DataType: &sqlast.Int{}}
+	name := &sqlast.ColumnDef{Name: sqlast.NewIdent("name"), DataType: &sqlast.Int{}}
+
+	t.Run("not null column without default", func(t *testing.T) {
+		email := &sqlast.ColumnDef{
+			Name:        sqlast.NewIdent("email"),
+			DataType:    &sqlast.Int{},
+			Constraints: []*sqlast.ColumnConstraint{{Spec: &sqlast.NotNullColumnSpec{}}},
+		}
+		_, err := createRebuildTableSpecs(table(name, email, id), current())
+		if err == nil || !strings.Contains(err.Error(), "email") {
+			t.Errorf("must be error naming the column but %+v", err)
+		}
+	})
+
+	t.Run("identity always", func(t *testing.T) {
+		c := current()
+		c.IdentityColumns = map[string]string{"id": IdentityAlways}
+		diffs, err := createRebuildTableSpecs(table(name, id), c)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		spec := diffs[0].Spec.(*RebuildTableSpec)
+		if diff := cmp.Diff([]Identifier{"id"}, spec.SerialColumns); diff != "" {
+			t.Errorf("diff: %s", diff)
+		}
+		restore := *spec
+		restore.Step = RebuildRestore
+		expect := "INSERT INTO account (name, id) OVERRIDING SYSTEM VALUE SELECT name, id FROM xmigrate_rebuild_account"
+		if act := restore.ToSQLString(); act != expect {
+			t.Errorf("expect %s but %s", expect, act)
+		}
+	})
+
+	t.Run("identity by default", func(t *testing.T) {
+		c := current()
+		c.IdentityColumns = map[string]string{"id": IdentityByDefault}
+		diffs, err := createRebuildTableSpecs(table(name, id), c)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if spec := diffs[0].Spec.(*RebuildTableSpec); spec.OverridingSystemValue {
+			t.Errorf("must not override system value")
+		}
+	})
+
+	t.Run("generated column", func(t *testing.T) {
+		c := current()
+		c.GeneratedColumns = map[string]struct{}{"name": {}}
+		_, err := createRebuildTableSpecs(table(name, id), c)
+		if err == nil || !strings.Contains(err.Error(), "generated") {
+			t.Errorf("must be error about the generated column but %+v", err)
+		}
+	})
+}
+
+func TestRebuildCopyName(t *testing.T) {
+	long := Identifier(strings.Repeat("a", 50))
+	other := Identifier(strings.Repeat("a", 49) + "b")
+
+	if act := rebuildCopyName("account"); act != "xmigrate_rebuild_account" {
+		t.Errorf("unexpected name %s", act)
+	}
+	for _, n := range []Identifier{long, other, Identifier(strings.Repeat("あ", 20))} {
+		if act := rebuildCopyName(n); len(act) > maxIdentifierLen {
+			t.Errorf("%s is longer than %d bytes", act, maxIdentifierLen)
+		}
+	}
+	if rebuildCopyName(long) == rebuildCopyName(other) {
+		t.Errorf("names of different tables must differ")
+	}
+}
+
+func TestCreateRebuildTableSpecs_UnsupportedConstraint(t *testing.T) {
+	table := &AddTableSpec{SQL: &sqlast.CreateTableStmt{
+		Name:     sqlast.NewSQLObjectName("item"),
+		Elements: []sqlast.TableElement{&sqlast.ColumnDef{Name: sqlast.NewIdent("price"), DataType: &sqlast.Int{}}},
+	}}
+	current := &TableDef{
+		Name:                   "item",
+		Columns:                map[string]*sqlast.ColumnDef{"price": {Name: sqlast.NewIdent("price"), DataType: &sqlast.Int{}}},
+		ColumnOrder:            []string{"price"},
+		UnsupportedConstraints: []string{"positive_price"},
+	}
+
+	_, err := createRebuildTableSpecs(table, current)
+	if err == nil || !strings.Contains(err.Error(), "positive_price") {
+		t.Errorf("must be error naming the constraint but %+v", err)
+	}
+}
@@ -0,0 +1,166 @@
+package xmigrate
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/akito0107/xsqlparser"
+	"github.com/akito0107/xsqlparser/dialect"
+	"github.com/akito0107/xsqlparser/sqlast"
+)
+
+// typeAliases maps every alias of builtin types to the name which
+// pg_catalog.format_type reports.
+var typeAliases = map[string]string{
+	"int":               "integer",
+	"int4":              "integer",
+	"integer":           "integer",
+	"int2":              "smallint",
+	"smallint":          "smallint",
+	"int8":              "bigint",
+	"bigint":            "bigint",
+	"serial4":           "serial",
+	"serial":            "serial",
+	"serial2":           "smallserial",
+	"smallserial":       "smallserial",
+	"serial8":           "bigserial",
+	"bigserial":         "bigserial",
+	"float4":            "real",
+	"real":              "real",
+	"float8":            "double precision",
+	"float":             "double precision",
+	"double":            "double precision",
+	"double precision":  "double precision",
+	"decimal":           "numeric",
+	"numeric":           "numeric",
+	"bool":              "boolean",
+	"boolean":           "boolean",
+	"varchar":           "character varying",
+	"character varying": "character varying",
+	"char varying":      "character varying",
+	"char":              "character",
+	"character":         "character",
+	"bpchar":            "character",
+	"varbit":            "bit varying",
+	"bit varying":       "bit varying",
+	"timestamp":         "timestamp without time zone",
+	"timestamptz":       "timestamp with time zone",
+	"time":              "time without time zone",
+	"timetz":            "time with time zone",
+}
+
+var (
+	typeArrayPattern    = regexp.MustCompile(`(\[\d*\])+$`)
+	typeModifierPattern = regexp.MustCompile(`\s*\(([^)]*)\)`)
+	typeTimeZonePattern = regexp.MustCompile(`\s+(with|without) time zone$`)
+)
+
+// CanonicalType returns the canonical form of tp, in which every alias is
+// replaced with one representation (e.g. int, int4 and integer are all
+// "integer", timestamptz is "timestamp with time zone").
+func CanonicalType(tp sqlast.Type) string {
+	return canonicalTypeName(tp.ToSQLString())
+}
+
+func canonicalTypeName(src string) string {
+	s := strings.Join(strings.Fields(normalizeTypeCase(src)), " ")
+
+	// array dimensions are not enforced by PostgreSQL
+	var array string
+	if loc := typeArrayPattern.FindStringIndex(s); loc != nil {
+		array = strings.Repeat("[]", strings.Count(s[loc[0]:], "["))
+		s = strings.TrimSpace(s[:loc[0]])
+	}
+	if strings.HasPrefix(s, "array ") || s == "array" {
+		return s
+	}
+
+	var zone string
+	if m := typeTimeZonePattern.FindStringSubmatch(s); m != nil {
+		zone = m[1]
+		s = strings.TrimSuffix(s, m[0])
+	}
+
+	var mod string
+	if m := typeModifierPattern.FindStringSubmatchIndex(s); m != nil {
+		mod = strings.Replace(s[m[2]:m[3]], " ", "", -1)
+		s = strings.TrimSpace(s[:m[0]] + s[m[1]:])
+	}
+
+	name, ok := typeAliases[s]
+	if !ok {
+		name = strings.TrimPrefix(s, "pg_catalog.")
+	}
+
+	switch name {
+	case "timestamp without time zone", "time without time zone":
+		if zone == "with" {
+			name = strings.Replace(name, "without", "with", 1)
+		}
+	case "double precision":
+		// float(p) is real for p <= 24
+		if p, err := strconv.Atoi(mod); err == nil && p <= 24 && s == "float" {
+			name = "real"
+		}
+		mod = ""
+	case "character":
+		// char means char(1)
+		if mod == "" {
+			mod = "1"
+		}
+	case "numeric":
+		// numeric(p) means numeric(p,0)
+		if mod != "" && !strings.Contains(mod, ",") {
+			mod += ",0"
+		}
+	}
+
+	if mod != "" {
+		// the precision is placed before time zone: timestamp(3) with time zone
+		if i := strings.Index(name, " with"); i >= 0 && strings.HasPrefix(name, "time") {
+			return name[:i] + "(" + mod + ")" + name[i:] + array
+		}
+		return name + "(" + mod + ")" + array
+	}
+
+	return name + array
+}
+
+// normalizeTypeCase lower-cases type name except quoted parts.
+func normalizeTypeCase(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		if s[i] == '"' {
+			j := skipQuoted(s, i)
+			b.WriteString(s[i:j])
+			i = j
+			continue
+		}
+		b.WriteString(strings.ToLower(s[i : i+1]))
+		i++
+	}
+	return b.String()
+}
+
+// sameType reports whether both types are the same after resolving aliases.
+func sameType(a, b sqlast.Type) bool {
+	return CanonicalType(a) == CanonicalType(b)
+}
+
+// columnType builds sqlast.Type from the output of pg_catalog.format_type.
+// Types which xsqlparser can't parse or can't hold without loss (arrays,
+// bit(n), interval fields, user defined types ...) are kept as Custom, so that
+// every type reported by PostgreSQL round-trips unchanged.
+func columnType(formatted string) sqlast.Type {
+	parser, err := xsqlparser.NewParser(bytes.NewBufferString(formatted), &dialect.PostgresqlDialect{})
+	if err == nil {
+		tp, err := parser.ParseDataType()
+		if err == nil && canonicalTypeName(tp.ToSQLString()) == canonicalTypeName(formatted) {
+			return tp
+		}
+	}
+
+	return &sqlast.Custom{Ty: sqlast.NewSQLObjectName(formatted)}
+}
@@ -0,0 +1,102 @@
+package xmigrate
+
+import (
+	"testing"
+
+	"github.com/akito0107/xsqlparser/sqlast"
+)
+
+func TestCanonicalTypeName(t *testing.T) {
+	cases := []struct {
+		aliases []string
+		expect  string
+	}{
+		{aliases: []string{"int", "INTEGER", "int4"}, expect: "integer"},
+		{aliases: []string{"int8", "bigint"}, expect: "bigint"},
+		{aliases: []string{"varchar(255)", "character varying (255)", "CHARACTER VARYING(255)"}, expect: "character varying(255)"},
+		{aliases: []string{"varchar", "character varying"}, expect: "character varying"},
+		{aliases: []string{"char", "character(1)", "bpchar"}, expect: "character(1)"},
+		{aliases: []string{"timestamptz", "timestamp with time zone", "TIMESTAMP WITH TIME ZONE"}, expect: "timestamp with time zone"},
+		{aliases: []string{"timestamp", "timestamp without time zone"}, expect: "timestamp without time zone"},
+		{aliases: []string{"timestamptz(3)", "timestamp(3) with time zone", "timestamp (3) with time zone"}, expect: "timestamp(3) with time zone"},
+		{aliases: []string{"timetz", "time with time zone"}, expect: "time with time zone"},
+		{aliases: []string{"decimal(5, 2)", "numeric(5,2)"}, expect: "numeric(5,2)"},
+		{aliases: []string{"numeric(5)", "decimal(5,0)"}, expect: "numeric(5,0)"},
+		{aliases: []string{"float", "float8", "double precision", "float(53)"}, expect: "double precision"},
+		{aliases: []string{"float4", "real", "float(24)"}, expect: "real"},
+		{aliases: []string{"bool", "boolean"}, expect: "boolean"},
+		{aliases: []string{"int[]", "integer[]", "int4[3]"}, expect: "integer[]"},
+		{aliases: []string{"text[][]", "text[2][2]"}, expect: "text[][]"},
+		{aliases: []string{`"MoodType"`}, expect: `"MoodType"`},
+		{aliases: []string{"serial4", "serial"}, expect: "serial"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.expect, func(t *testing.T) {
+			for _, a := range c.aliases {
+				if act := canonicalTypeName(a); act != c.expect {
+					t.Errorf("%s: expect %s but %s", a, c.expect, act)
+				}
+			}
+		})
+	}
+}
+
+func TestComputeColumnDiff_Type(t *testing.T) {
+	cases := []struct {
+		name    string
+		targ    sqlast.Type
+		current sqlast.Type
+		hasDiff bool
+	}{
+		{
+			name:    "alias",
+			targ:    &sqlast.Int{},
+			current: &sqlast.Custom{Ty: sqlast.NewSQLObjectName("int4")},
+		},
+		{
+			name:    "custom types",
+			targ:    &sqlast.Custom{Ty: sqlast.NewSQLObjectName("mood")},
+			current: &sqlast.Custom{Ty: sqlast.NewSQLObjectName("text")},
+			hasDiff: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			targ := &sqlast.ColumnDef{Name: sqlast.NewIdent("col"), DataType: c.targ}
+			current := &sqlast.ColumnDef{Name: sqlast.NewIdent("col"), DataType: c.current}
+
+			hasDiff, _, err := computeColumnDiff("account", targ, current, "", "")
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if hasDiff != c.hasDiff {
+				t.Errorf("expect %v but %v", c.hasDiff, hasDiff)
+			}
+		})
+	}
+}
+
+func TestColumnType(t *testing.T) {
+	cases := []string{
+		"integer",
+		"character varying(255)",
+		"numeric(5,2)",
+		"character(3)",
+		"timestamp(3) with time zone",
+		"interval day to second(2)",
+		"bit(8)",
+		"integer[]",
+		"character varying(20)[]",
+		`"MoodType"`,
+	}
+
+	for _, c := range cases {
+		t.Run(c, func(t *testing.T) {
+			if act := CanonicalType(columnType(c)); act != canonicalTypeName(c) {
+				t.Errorf("expect %s but %s", canonicalTypeName(c), act)
+			}
+		})
+	}
+}
@@ -1,7 +1,7 @@
 package xmigrate
 
 import (
-	"reflect"
+	"fmt"
 	"strings"
 
 	errors "golang.org/x/xerrors"
@@ -21,11 +21,88 @@ const (
 	DropTableConstraint
 	AddIndex
 	RemoveIndex
+	AddPartition
+	AttachPartition
+	DetachPartition
+	AddCollation
+	DropCollation
+	AddExclusion
+	AddExtension
+	AlterStorage
+	RebuildTable
 )
 
 type TargetTable struct {
 	TableDef []*sqlast.CreateTableStmt
-	IndexDef []*sqlast.CreateIndexStmt
+	IndexDef []*IndexDef
+	// PartitionKeys holds PARTITION BY clauses keyed by table name folded as Identifier.
+	PartitionKeys map[string]*PartitionKey
+	Partitions    []*PartitionDef
+	// ColumnCollations holds COLLATE clauses keyed by table and column name folded as Identifier.
+	ColumnCollations map[string]map[string]string
+	Collations       []*CollationDef
+	// Exclusions holds EXCLUDE constraints keyed by table name folded as Identifier.
+	Exclusions map[string][]*ExclusionDef
+	Extensions []*ExtensionDef
+	// Storage holds UNLOGGED, WITH and TABLESPACE clauses keyed by table name folded as Identifier.
+	Storage map[string]*TableStorage
+	// IdentityColumns holds identity columns keyed by table and column name folded as Identifier.
+	// They are used to create tables, and identities of existing columns are not compared.
+	IdentityColumns map[string]map[string]string
+}
+
+type diffConfig struct {
+	unmanagedPartitions bool
+	reorderColumns      bool
+	warn                func(string)
+	filter              *Filter
+	// serverVersion is zero unless WithServerVersion is given.
+	serverVersion ServerVersion
+	// defaultTablespace is empty unless WithDefaultTablespace is given.
+	defaultTablespace string
+	// timeZone is empty unless WithTimeZone is given.
+	timeZone string
+}
+
+type DiffOption func(*diffConfig)
+
+func newDiffConfig(opts []DiffOption) *diffConfig {
+	conf := &diffConfig{}
+	for _, o := range opts {
+		o(conf)
+	}
+	return conf
+}
+
+// WithUnmanagedPartitions makes Diff leave partitions alone. It's useful when
+// partitions are created and dropped by another tool like pg_partman.
+func WithUnmanagedPartitions() DiffOption {
+	return func(c *diffConfig) {
+		c.unmanagedPartitions = true
+	}
+}
+
+// WithColumnReorder makes Diff rebuild tables whose column order differs
+// from the schema. Rebuilding copies every row, so it's opt-in.
+func WithColumnReorder() DiffOption {
+	return func(c *diffConfig) {
+		c.reorderColumns = true
+	}
+}
+
+// WithFilter makes Diff ignore objects which filter does not manage on both sides.
+func WithFilter(filter *Filter) DiffOption {
+	return func(c *diffConfig) {
+		c.filter = filter
+	}
+}
+
+// WithWarnings sets the function which receives differences which Diff does
+// not migrate, like column order.
+func WithWarnings(fn func(string)) DiffOption {
+	return func(c *diffConfig) {
+		c.warn = fn
+	}
 }
 
 type SchemaDiff struct {
@@ -33,47 +110,124 @@ type SchemaDiff struct {
 	Spec DiffSpec
 }
 
-func Diff(targ *TargetTable, currentTable []*TableDef) ([]*SchemaDiff, error) {
+func Diff(targ *TargetTable, currentTable []*TableDef, opts ...DiffOption) ([]*SchemaDiff, error) {
 	var diffs []*SchemaDiff
 
+	conf := newDiffConfig(opts)
+	targ = conf.filter.FilterTarget(targ)
+	currentTable = conf.filter.FilterTables(currentTable)
+
 	targetState := make(map[string]*sqlast.CreateTableStmt)
 	targetIndexes := partitionIndexByName(targ.IndexDef)
 
 	for _, t := range targ.TableDef {
-		targetState[strings.ToLower(t.Name.ToSQLString())] = t
+		targetState[string(ParseIdentifier(t.Name.ToSQLString()))] = t
+	}
+
+	targetPartitions := make(map[string]*PartitionDef)
+	for _, p := range targ.Partitions {
+		targetPartitions[string(p.Name)] = p
 	}
 
-	currentIndexes := make(map[string]*sqlast.CreateIndexStmt)
+	currentIndexes := make(map[string]*IndexDef)
 	currentState := make(map[string]*TableDef)
 	for _, c := range currentTable {
-		currentState[c.Name] = c
+		if c.PartitionOf != nil && conf.unmanagedPartitions {
+			continue
+		}
+		currentState[string(c.Name)] = c
 		for n, i := range c.Indexes {
 			currentIndexes[n] = i
 		}
 	}
 
+	rebuilt := make(map[string]struct{})
 	for n, v := range targetState {
+		key := targ.PartitionKeys[n]
 		c, ok := currentState[n]
 		if !ok {
 			spec := createAddTableSpec(v)
+			spec.PartitionKey = key
+			spec.Collations = targ.ColumnCollations[n]
+			spec.Identities = targ.IdentityColumns[n]
+			spec.Storage = targ.Storage[n]
 			diffs = append(diffs, &SchemaDiff{
 				Type: AddTable,
 				Spec: spec,
 			})
+			diffs = append(diffs, computeExclusionDiff(ParseIdentifier(v.Name.ToSQLString()), targ.Exclusions[n], nil)...)
 			continue
 		}
-		diff, err := computeTableDiff(v, c)
+		if !c.PartitionKey.equal(key) {
+			return nil, errors.Errorf("changing partition key of %s is not supported", n)
+		}
+		if conf.warn != nil {
+			for _, name := range c.UnsupportedConstraints {
+				conf.warn(unsupportedConstraintWarning(c.Name, name))
+			}
+		}
+		if targOrder, order := columnOrder(v, c); len(c.ColumnOrder) > 0 && !sameOrder(targOrder, order) {
+			// partitions share the column layout of the parent, so they can't be rebuilt
+			if conf.reorderColumns && key == nil && c.PartitionOf == nil {
+				if fks := referencingForeignKeys(c.Name, currentTable); len(fks) > 0 {
+					return nil, errors.Errorf("reordering columns of %s needs dropping foreign keys which reference it: %s", n, strings.Join(fks, ", "))
+				}
+				spec := createAddTableSpec(v)
+				spec.Collations = targ.ColumnCollations[n]
+				spec.Identities = targ.IdentityColumns[n]
+				spec.Storage = targ.Storage[n]
+				rebuild, err := createRebuildTableSpecs(spec, c)
+				if err != nil {
+					return nil, errors.Errorf("createRebuildTableSpecs failed: %w", err)
+				}
+				diffs = append(diffs, rebuild...)
+				diffs = append(diffs, computeExclusionDiff(c.Name, targ.Exclusions[n], nil)...)
+				// indexes are dropped with the table and created again from the schema
+				for i, idx := range currentIndexes {
+					if idx.Table == c.Name {
+						delete(currentIndexes, i)
+					}
+				}
+				rebuilt[n] = struct{}{}
+				continue
+			}
+			if conf.warn != nil {
+				conf.warn(columnOrderWarning(c.Name, targOrder, order))
+			}
+		}
+		if c.PartitionOf != nil {
+			diffs = append(diffs, &SchemaDiff{
+				Type: DetachPartition,
+				Spec: &DetachPartitionSpec{
+					TableName: c.Name,
+					Parent:    c.PartitionOf.Parent,
+				},
+			})
+		}
+		diff, err := computeTableDiff(v, c, targ.ColumnCollations[n])
 		if err != nil {
 			return nil, errors.Errorf("computeTableDiff failed: %w", err)
 		}
 
 		diffs = append(diffs, diff...)
+		diffs = append(diffs, computeExclusionDiff(c.Name, targ.Exclusions[n], c.Exclusions)...)
+		diffs = append(diffs, computeTableStorageDiff(c.Name, targ.Storage[n], c.Storage, conf.defaultTablespace)...)
+	}
 
+	if !conf.unmanagedPartitions {
+		diff, err := computePartitionDiff(targ.Partitions, currentState, conf.timeZone)
+		if err != nil {
+			return nil, errors.Errorf("computePartitionDiff failed: %w", err)
+		}
+		diffs = append(diffs, diff...)
 	}
 
 	for n, v := range currentState {
-		t, ok := targetState[strings.ToLower(n)]
+		t, ok := targetState[n]
 		if !ok {
+			if _, ok := targetPartitions[n]; ok {
+				continue
+			}
 			spec := createDropTableSpec(v)
 			diffs = append(diffs, &SchemaDiff{
 				Type: DropTable,
@@ -81,6 +235,9 @@ func Diff(targ *TargetTable, currentTable []*TableDef) ([]*SchemaDiff, error) {
 			})
 			continue
 		}
+		if _, ok := rebuilt[n]; ok {
+			continue
+		}
 
 		for _, currentConstraint := range v.Constrains {
 			var found bool
@@ -89,7 +246,7 @@ func Diff(targ *TargetTable, currentTable []*TableDef) ([]*SchemaDiff, error) {
 				if !ok {
 					continue
 				}
-				if strings.EqualFold(c.Name.ToSQLString(), currentConstraint.Name.ToSQLString()) {
+				if ParseIdentifier(c.Name.ToSQLString()) == ParseIdentifier(currentConstraint.Name.ToSQLString()) {
 					found = true
 					break
 				}
@@ -99,15 +256,21 @@ func Diff(targ *TargetTable, currentTable []*TableDef) ([]*SchemaDiff, error) {
 				diffs = append(diffs, &SchemaDiff{
 					Type: DropTableConstraint,
 					Spec: &DropTableConstraintSpec{
-						TableName:       t.Name.ToSQLString(),
-						ConstraintsName: currentConstraint.Name.ToSQLString(),
+						TableName:       v.Name,
+						ConstraintsName: ParseIdentifier(currentConstraint.Name.ToSQLString()),
 					},
 				})
 			}
 		}
 	}
 
-	diffs = append(diffs, computeIndexDiff(currentIndexes, targetIndexes)...)
+	diffs = append(diffs, computeIndexDiff(currentIndexes, targetIndexes, conf.defaultTablespace)...)
+
+	if conf.serverVersion > 0 {
+		if err := CheckServerVersion(diffs, conf.serverVersion); err != nil {
+			return nil, err
+		}
+	}
 
 	return diffs, nil
 }
@@ -128,7 +291,7 @@ func DSLToDiff(stmts []sqlast.Stmt) ([]*SchemaDiff, error) {
 				Type: DropTable,
 				Spec: &DropTableSpec{
 					// TODO: allow multiple table names
-					TableName: st.TableNames[0].ToSQLString(),
+					TableName: ParseIdentifier(st.TableNames[0].ToSQLString()),
 				},
 			})
 		case *sqlast.AlterTableStmt:
@@ -137,7 +300,7 @@ func DSLToDiff(stmts []sqlast.Stmt) ([]*SchemaDiff, error) {
 				diff = append(diff, &SchemaDiff{
 					Type: AddColumn,
 					Spec: &AddColumnSpec{
-						TableName: st.TableName.ToSQLString(),
+						TableName: ParseIdentifier(st.TableName.ToSQLString()),
 						ColumnDef: al.Column,
 					},
 				})
@@ -145,15 +308,15 @@ func DSLToDiff(stmts []sqlast.Stmt) ([]*SchemaDiff, error) {
 				diff = append(diff, &SchemaDiff{
 					Type: DropColumn,
 					Spec: &DropColumnSpec{
-						TableName:  st.TableName.ToSQLString(),
-						ColumnName: al.Name.ToSQLString(),
+						TableName:  ParseIdentifier(st.TableName.ToSQLString()),
+						ColumnName: ParseIdentifier(al.Name.ToSQLString()),
 					},
 				})
 			case *sqlast.AddConstraintTableAction:
 				diff = append(diff, &SchemaDiff{
 					Type: AddTableConstraint,
 					Spec: &AddTableConstraintSpec{
-						TableName:     st.TableName.ToSQLString(),
+						TableName:     ParseIdentifier(st.TableName.ToSQLString()),
 						ConstraintDef: al.Constraint,
 					},
 				})
@@ -161,8 +324,8 @@ func DSLToDiff(stmts []sqlast.Stmt) ([]*SchemaDiff, error) {
 				diff = append(diff, &SchemaDiff{
 					Type: DropTableConstraint,
 					Spec: &DropTableConstraintSpec{
-						TableName:       st.TableName.ToSQLString(),
-						ConstraintsName: al.Name.ToSQLString(),
+						TableName:       ParseIdentifier(st.TableName.ToSQLString()),
+						ConstraintsName: ParseIdentifier(al.Name.ToSQLString()),
 					},
 				})
 			case *sqlast.AlterColumnTableAction:
@@ -172,8 +335,8 @@ func DSLToDiff(stmts []sqlast.Stmt) ([]*SchemaDiff, error) {
 						Type: EditColumn,
 						Spec: &EditColumnSpec{
 							Type:       SetDefault,
-							TableName:  st.TableName.ToSQLString(),
-							ColumnName: al.ColumnName.ToSQLString(),
+							TableName:  ParseIdentifier(st.TableName.ToSQLString()),
+							ColumnName: ParseIdentifier(al.ColumnName.ToSQLString()),
 							SQL:        st,
 						},
 					})
@@ -182,8 +345,8 @@ func DSLToDiff(stmts []sqlast.Stmt) ([]*SchemaDiff, error) {
 						Type: EditColumn,
 						Spec: &EditColumnSpec{
 							Type:       DropDefault,
-							TableName:  st.TableName.ToSQLString(),
-							ColumnName: al.ColumnName.ToSQLString(),
+							TableName:  ParseIdentifier(st.TableName.ToSQLString()),
+							ColumnName: ParseIdentifier(al.ColumnName.ToSQLString()),
 							SQL:        st,
 						},
 					})
@@ -192,8 +355,8 @@ func DSLToDiff(stmts []sqlast.Stmt) ([]*SchemaDiff, error) {
 						Type: EditColumn,
 						Spec: &EditColumnSpec{
 							Type:       EditType,
-							TableName:  st.TableName.ToSQLString(),
-							ColumnName: al.ColumnName.ToSQLString(),
+							TableName:  ParseIdentifier(st.TableName.ToSQLString()),
+							ColumnName: ParseIdentifier(al.ColumnName.ToSQLString()),
 							SQL:        st,
 						},
 					})
@@ -202,8 +365,8 @@ func DSLToDiff(stmts []sqlast.Stmt) ([]*SchemaDiff, error) {
 						Type: EditColumn,
 						Spec: &EditColumnSpec{
 							Type:       DropNotNull,
-							TableName:  st.TableName.ToSQLString(),
-							ColumnName: al.ColumnName.ToSQLString(),
+							TableName:  ParseIdentifier(st.TableName.ToSQLString()),
+							ColumnName: ParseIdentifier(al.ColumnName.ToSQLString()),
 							SQL:        st,
 						},
 					})
@@ -212,8 +375,8 @@ func DSLToDiff(stmts []sqlast.Stmt) ([]*SchemaDiff, error) {
 						Type: EditColumn,
 						Spec: &EditColumnSpec{
 							Type:       SetNotNull,
-							TableName:  st.TableName.ToSQLString(),
-							ColumnName: al.ColumnName.ToSQLString(),
+							TableName:  ParseIdentifier(st.TableName.ToSQLString()),
+							ColumnName: ParseIdentifier(al.ColumnName.ToSQLString()),
 							SQL:        st,
 						},
 					})
@@ -230,11 +393,11 @@ func DSLToDiff(stmts []sqlast.Stmt) ([]*SchemaDiff, error) {
 	return diff, nil
 }
 
-func partitionIndexByName(indexes []*sqlast.CreateIndexStmt) map[string]*sqlast.CreateIndexStmt {
-	p := make(map[string]*sqlast.CreateIndexStmt)
+func partitionIndexByName(indexes []*IndexDef) map[string]*IndexDef {
+	p := make(map[string]*IndexDef)
 
 	for _, i := range indexes {
-		p[i.IndexName.ToSQLString()] = i
+		p[string(i.Name)] = i
 	}
 
 	return p
@@ -245,11 +408,40 @@ type DiffSpec interface {
 }
 
 type AddTableSpec struct {
-	SQL *sqlast.CreateTableStmt
+	SQL          *sqlast.CreateTableStmt
+	PartitionKey *PartitionKey
+	// Collations holds COLLATE clauses keyed by column name folded as Identifier.
+	Collations map[string]string
+	Storage    *TableStorage
+	// Identities holds GENERATED ... AS IDENTITY clauses keyed by column name folded as Identifier.
+	Identities map[string]string
 }
 
 func (a *AddTableSpec) ToSQLString() string {
-	return a.SQL.ToSQLString()
+	sql := a.SQL.ToSQLString()
+	if len(a.Collations) > 0 || len(a.Identities) > 0 {
+		var elems []string
+		for _, e := range a.SQL.Elements {
+			if c, ok := e.(*sqlast.ColumnDef); ok {
+				name := string(ParseIdentifier(c.Name.ToSQLString()))
+				elem := columnDefSQL(c, a.Collations[name])
+				if kind, ok := a.Identities[name]; ok {
+					elem += " GENERATED " + kind + " AS IDENTITY"
+				}
+				elems = append(elems, elem)
+				continue
+			}
+			elems = append(elems, e.ToSQLString())
+		}
+		sql = fmt.Sprintf("CREATE TABLE %s (%s)", a.SQL.Name.ToSQLString(), strings.Join(elems, ", "))
+	}
+	if a.Storage != nil && a.Storage.Unlogged {
+		sql = "CREATE UNLOGGED TABLE" + strings.TrimPrefix(sql, "CREATE TABLE")
+	}
+	if a.PartitionKey != nil {
+		sql += " " + a.PartitionKey.ToSQLString()
+	}
+	return sql + a.Storage.tail()
 }
 
 func createAddTableSpec(targ *sqlast.CreateTableStmt) *AddTableSpec {
@@ -257,12 +449,14 @@ func createAddTableSpec(targ *sqlast.CreateTableStmt) *AddTableSpec {
 }
 
 type DropTableSpec struct {
-	TableName string
+	TableName Identifier
+	// Collations are non default collations of the columns, which are released by the drop.
+	Collations map[string]string
 }
 
 func (d *DropTableSpec) ToSQLString() string {
 	sql := &sqlast.DropTableStmt{
-		TableNames: []*sqlast.ObjectName{sqlast.NewSQLObjectName(d.TableName)},
+		TableNames: []*sqlast.ObjectName{sqlast.NewSQLObjectName(d.TableName.ToSQLString())},
 		IfExists:   true,
 	}
 
@@ -270,17 +464,25 @@ func (d *DropTableSpec) ToSQLString() string {
 }
 
 func createDropTableSpec(currentTable *TableDef) *DropTableSpec {
-	return &DropTableSpec{TableName: currentTable.Name}
+	spec := &DropTableSpec{TableName: currentTable.Name}
+	if len(currentTable.ColumnCollations) > 0 {
+		spec.Collations = currentTable.ColumnCollations
+	}
+	return spec
 }
 
 type AddColumnSpec struct {
-	TableName string
+	TableName Identifier
 	ColumnDef *sqlast.ColumnDef
+	Collation string
 }
 
 func (a *AddColumnSpec) ToSQLString() string {
+	if a.Collation != "" {
+		return fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", a.TableName.ToSQLString(), columnDefSQL(a.ColumnDef, a.Collation))
+	}
 	sql := sqlast.AlterTableStmt{
-		TableName: sqlast.NewSQLObjectName(a.TableName),
+		TableName: sqlast.NewSQLObjectName(a.TableName.ToSQLString()),
 		Action: &sqlast.AddColumnTableAction{
 			Column: a.ColumnDef,
 		},
@@ -290,15 +492,17 @@ func (a *AddColumnSpec) ToSQLString() string {
 }
 
 type DropColumnSpec struct {
-	TableName  string
-	ColumnName string
+	TableName  Identifier
+	ColumnName Identifier
+	// Collation is the non default collation of the column, which is released by the drop.
+	Collation string
 }
 
 func (d *DropColumnSpec) ToSQLString() string {
 	sql := sqlast.AlterTableStmt{
-		TableName: sqlast.NewSQLObjectName(d.TableName),
+		TableName: sqlast.NewSQLObjectName(d.TableName.ToSQLString()),
 		Action: &sqlast.RemoveColumnTableAction{
-			Name: sqlast.NewIdent(d.ColumnName),
+			Name: sqlast.NewIdent(d.ColumnName.ToSQLString()),
 		},
 	}
 
@@ -306,13 +510,13 @@ func (d *DropColumnSpec) ToSQLString() string {
 }
 
 type AddTableConstraintSpec struct {
-	TableName     string
+	TableName     Identifier
 	ConstraintDef *sqlast.TableConstraint
 }
 
 func (a *AddTableConstraintSpec) ToSQLString() string {
 	sql := &sqlast.AlterTableStmt{
-		TableName: sqlast.NewSQLObjectName(a.TableName),
+		TableName: sqlast.NewSQLObjectName(a.TableName.ToSQLString()),
 		Action: &sqlast.AddConstraintTableAction{
 			Constraint: a.ConstraintDef,
 		},
@@ -322,41 +526,33 @@ func (a *AddTableConstraintSpec) ToSQLString() string {
 }
 
 type DropTableConstraintSpec struct {
-	TableName       string
-	ConstraintsName string
+	TableName       Identifier
+	ConstraintsName Identifier
 }
 
 func (d *DropTableConstraintSpec) ToSQLString() string {
 	sql := &sqlast.AlterTableStmt{
-		TableName: sqlast.NewSQLObjectName(d.TableName),
+		TableName: sqlast.NewSQLObjectName(d.TableName.ToSQLString()),
 		Action: &sqlast.DropConstraintTableAction{
-			Name: sqlast.NewIdent(d.ConstraintsName),
+			Name: sqlast.NewIdent(d.ConstraintsName.ToSQLString()),
 		},
 	}
 
 	return sql.ToSQLString()
 }
 
-type AddIndexSpec struct {
-	Def *sqlast.CreateIndexStmt
-}
-
-func (a *AddIndexSpec) ToSQLString() string {
-	return a.Def.ToSQLString()
-}
-
 type DropIndexSpec struct {
-	IndexName string
+	IndexName Identifier
 }
 
 func (d *DropIndexSpec) ToSQLString() string {
 	sql := &sqlast.DropIndexStmt{
-		IndexNames: []*sqlast.Ident{sqlast.NewIdent(d.IndexName)},
+		IndexNames: []*sqlast.Ident{sqlast.NewIdent(d.IndexName.ToSQLString())},
 	}
 	return sql.ToSQLString()
 }
 
-func computeTableDiff(targ *sqlast.CreateTableStmt, currentTable *TableDef) ([]*SchemaDiff, error) {
+func computeTableDiff(targ *sqlast.CreateTableStmt, currentTable *TableDef, collations map[string]string) ([]*SchemaDiff, error) {
 	var diffs []*SchemaDiff
 	var targNames []string
 
@@ -365,22 +561,24 @@ func computeTableDiff(targ *sqlast.CreateTableStmt, currentTable *TableDef) ([]*
 	for _, e := range targ.Elements {
 		switch tp := e.(type) {
 		case *sqlast.ColumnDef:
-			cmap[strings.ToLower(tp.Name.ToSQLString())] = struct{}{}
+			name := string(ParseIdentifier(tp.Name.ToSQLString()))
+			cmap[name] = struct{}{}
 			targNames = append(targNames, tp.Name.ToSQLString())
 
-			curr, ok := currentTable.Columns[strings.ToLower(tp.Name.ToSQLString())]
+			curr, ok := currentTable.Columns[name]
 			if !ok {
 				diffs = append(diffs, &SchemaDiff{
 					Type: AddColumn,
 					Spec: &AddColumnSpec{
 						TableName: currentTable.Name,
 						ColumnDef: tp,
+						Collation: collations[name],
 					},
 				})
 				continue
 			}
 
-			hasDiff, diff, err := computeColumnDiff(targ.Name.ToSQLString(), tp, curr)
+			hasDiff, diff, err := computeColumnDiff(currentTable.Name, tp, curr, collations[name], currentTable.ColumnCollations[name])
 			if err != nil {
 				return nil, errors.Errorf("computeColumnDiff failed: %w", err)
 			}
@@ -392,7 +590,7 @@ func computeTableDiff(targ *sqlast.CreateTableStmt, currentTable *TableDef) ([]*
 		case *sqlast.TableConstraint:
 			var found bool
 			for _, c := range currentTable.Constrains {
-				if strings.EqualFold(tp.Name.ToSQLString(), c.Name.ToSQLString()) {
+				if ParseIdentifier(tp.Name.ToSQLString()) == ParseIdentifier(c.Name.ToSQLString()) {
 					found = true
 					break
 				}
@@ -402,7 +600,7 @@ func computeTableDiff(targ *sqlast.CreateTableStmt, currentTable *TableDef) ([]*
 				diffs = append(diffs, &SchemaDiff{
 					Type: AddTableConstraint,
 					Spec: &AddTableConstraintSpec{
-						TableName:     targ.Name.ToSQLString(),
+						TableName:     currentTable.Name,
 						ConstraintDef: tp,
 					},
 				})
@@ -419,7 +617,8 @@ func computeTableDiff(targ *sqlast.CreateTableStmt, currentTable *TableDef) ([]*
 				Type: DropColumn,
 				Spec: &DropColumnSpec{
 					TableName:  currentTable.Name,
-					ColumnName: c,
+					ColumnName: Identifier(c),
+					Collation:  currentTable.ColumnCollations[c],
 				},
 			})
 		}
@@ -440,12 +639,20 @@ const (
 
 type EditColumnSpec struct {
 	Type       EditColumnType
-	TableName  string
-	ColumnName string
+	TableName  Identifier
+	ColumnName Identifier
 	SQL        *sqlast.AlterTableStmt
+	// Collation is appended to EditType as COLLATE clause.
+	// "default" resets the collation to the default one.
+	Collation string
+	// PrevCollation is the non default collation which the column had before the edit.
+	PrevCollation string
 }
 
 func (e *EditColumnSpec) ToSQLString() string {
+	if e.Type == EditType && e.Collation != "" {
+		return e.SQL.ToSQLString() + collateClause(e.Collation)
+	}
 	return e.SQL.ToSQLString()
 }
 
@@ -459,20 +666,16 @@ type EditColumnAction interface {
 // constraints change
 //   unique
 //   check
-func computeColumnDiff(tableName string, targ *sqlast.ColumnDef, current *sqlast.ColumnDef) (bool, []*SchemaDiff, error) {
+func computeColumnDiff(tableName Identifier, targ *sqlast.ColumnDef, current *sqlast.ColumnDef, targCollation, currentCollation string) (bool, []*SchemaDiff, error) {
 	var diffs []*SchemaDiff
 
-	tgTp, tok := targ.DataType.(*sqlast.Custom)
-	cuTp, cok := targ.DataType.(*sqlast.Custom)
-	// custom type is not comparable via reflection
-	if tok && cok {
-		if strings.EqualFold(tgTp.ToSQLString(), cuTp.ToSQLString()) {
-			return false, nil, nil
-		}
+	collation := targCollation
+	if targCollation == "" && currentCollation != "" {
+		collation = "default"
 	}
-	if !reflect.DeepEqual(targ.DataType, current.DataType) {
+	if !sameType(targ.DataType, current.DataType) || targCollation != currentCollation {
 		sql := &sqlast.AlterTableStmt{
-			TableName: sqlast.NewSQLObjectName(tableName),
+			TableName: sqlast.NewSQLObjectName(tableName.ToSQLString()),
 			Action: &sqlast.AlterColumnTableAction{
 				ColumnName: targ.Name,
 				Action: &sqlast.PGAlterDataTypeColumnAction{
@@ -484,10 +687,12 @@ func computeColumnDiff(tableName string, targ *sqlast.ColumnDef, current *sqlast
 		diffs = append(diffs, &SchemaDiff{
 			Type: EditColumn,
 			Spec: &EditColumnSpec{
-				TableName:  tableName,
-				Type:       EditType,
-				ColumnName: targ.Name.ToSQLString(),
-				SQL:        sql,
+				TableName:     tableName,
+				Type:          EditType,
+				ColumnName:    ParseIdentifier(targ.Name.ToSQLString()),
+				SQL:           sql,
+				Collation:     collation,
+				PrevCollation: currentCollation,
 			},
 		})
 	}
@@ -497,7 +702,7 @@ func computeColumnDiff(tableName string, targ *sqlast.ColumnDef, current *sqlast
 
 	if tnn && !cnn {
 		sql := &sqlast.AlterTableStmt{
-			TableName: sqlast.NewSQLObjectName(tableName),
+			TableName: sqlast.NewSQLObjectName(tableName.ToSQLString()),
 			Action: &sqlast.AlterColumnTableAction{
 				ColumnName: targ.Name,
 				Action:     &sqlast.PGSetNotNullColumnAction{},
@@ -509,13 +714,13 @@ func computeColumnDiff(tableName string, targ *sqlast.ColumnDef, current *sqlast
 			Spec: &EditColumnSpec{
 				TableName:  tableName,
 				Type:       SetNotNull,
-				ColumnName: targ.Name.ToSQLString(),
+				ColumnName: ParseIdentifier(targ.Name.ToSQLString()),
 				SQL:        sql,
 			},
 		})
 	} else if !tnn && cnn {
 		sql := &sqlast.AlterTableStmt{
-			TableName: sqlast.NewSQLObjectName(tableName),
+			TableName: sqlast.NewSQLObjectName(tableName.ToSQLString()),
 			Action: &sqlast.AlterColumnTableAction{
 				ColumnName: targ.Name,
 				Action:     &sqlast.PGDropNotNullColumnAction{},
@@ -527,7 +732,7 @@ func computeColumnDiff(tableName string, targ *sqlast.ColumnDef, current *sqlast
 			Spec: &EditColumnSpec{
 				TableName:  tableName,
 				Type:       DropNotNull,
-				ColumnName: targ.Name.ToSQLString(),
+				ColumnName: ParseIdentifier(targ.Name.ToSQLString()),
 				SQL:        sql,
 			},
 		})
@@ -538,7 +743,7 @@ func computeColumnDiff(tableName string, targ *sqlast.ColumnDef, current *sqlast
 
 	if tdef && !cdef {
 		sql := &sqlast.AlterTableStmt{
-			TableName: sqlast.NewSQLObjectName(tableName),
+			TableName: sqlast.NewSQLObjectName(tableName.ToSQLString()),
 			Action: &sqlast.AlterColumnTableAction{
 				ColumnName: targ.Name,
 				Action: &sqlast.SetDefaultColumnAction{
@@ -551,13 +756,13 @@ func computeColumnDiff(tableName string, targ *sqlast.ColumnDef, current *sqlast
 			Spec: &EditColumnSpec{
 				TableName:  tableName,
 				Type:       SetDefault,
-				ColumnName: targ.Name.ToSQLString(),
+				ColumnName: ParseIdentifier(targ.Name.ToSQLString()),
 				SQL:        sql,
 			},
 		})
 	} else if !tdef && cdef {
 		sql := &sqlast.AlterTableStmt{
-			TableName: sqlast.NewSQLObjectName(tableName),
+			TableName: sqlast.NewSQLObjectName(tableName.ToSQLString()),
 			Action: &sqlast.AlterColumnTableAction{
 				ColumnName: targ.Name,
 				Action:     &sqlast.DropDefaultColumnAction{},
@@ -568,7 +773,7 @@ func computeColumnDiff(tableName string, targ *sqlast.ColumnDef, current *sqlast
 			Spec: &EditColumnSpec{
 				TableName:  tableName,
 				Type:       DropDefault,
-				ColumnName: targ.Name.ToSQLString(),
+				ColumnName: ParseIdentifier(targ.Name.ToSQLString()),
 				SQL:        sql,
 			},
 		})
@@ -596,32 +801,3 @@ func hasNotNullConstraint(def *sqlast.ColumnDef) bool {
 func hasDefaultClause(def *sqlast.ColumnDef) bool {
 	return def.Default != nil
 }
-
-func computeIndexDiff(current, target map[string]*sqlast.CreateIndexStmt) []*SchemaDiff {
-	var diffs []*SchemaDiff
-	for n, i := range current {
-		_, ok := target[n]
-		if !ok {
-			diffs = append(diffs, &SchemaDiff{
-				Type: RemoveIndex,
-				Spec: &DropIndexSpec{
-					IndexName: i.IndexName.ToSQLString(),
-				},
-			})
-		}
-	}
-
-	for n, i := range target {
-		_, ok := current[n]
-		if !ok {
-			diffs = append(diffs, &SchemaDiff{
-				Type: AddIndex,
-				Spec: &AddIndexSpec{
-					Def: i,
-				},
-			})
-		}
-	}
-
-	return diffs
-}
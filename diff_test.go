@@ -456,6 +456,7 @@ func genTableDef(t *testing.T, def string) []*TableDef {
 	var defs []*TableDef
 	for _, c := range creates {
 		columns := make(map[string]*sqlast.ColumnDef)
+		var order []string
 
 		for _, col := range c.Elements {
 			column, ok := col.(*sqlast.ColumnDef)
@@ -463,11 +464,14 @@ func genTableDef(t *testing.T, def string) []*TableDef {
 				t.Fatalf("%s is not columndef", col.ToSQLString())
 			}
 
-			columns[column.Name.ToSQLString()] = column
+			name := string(ParseIdentifier(column.Name.ToSQLString()))
+			columns[name] = column
+			order = append(order, name)
 		}
 		defs = append(defs, &TableDef{
-			Name:    c.Name.ToSQLString(),
-			Columns: columns,
+			Name:        ParseIdentifier(c.Name.ToSQLString()),
+			Columns:     columns,
+			ColumnOrder: order,
 		})
 	}
 
@@ -0,0 +1,121 @@
+package xmigrate
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	errors "golang.org/x/xerrors"
+)
+
+// ExclusionDef is an EXCLUDE constraint.
+type ExclusionDef struct {
+	Name Identifier
+	// Def is the constraint itself, e.g. "EXCLUDE USING gist (room_id WITH =, during WITH &&)".
+	Def string
+}
+
+func (e *ExclusionDef) equal(o *ExclusionDef) bool {
+	eb, ew := splitExclusionWhere(e.Def)
+	ob, ow := splitExclusionWhere(o.Def)
+	return normalizeIndexExpr(eb) == normalizeIndexExpr(ob) && normalizeIndexExpr(ew) == normalizeIndexExpr(ow)
+}
+
+var exclusionWherePattern = regexp.MustCompile(`(?is)^where\s`)
+
+// splitExclusionWhere splits the constraint into the element list part and its predicate.
+func splitExclusionWhere(def string) (string, string) {
+	idx := indexTopLevelKeyword(def, exclusionWherePattern)
+	if idx <= 0 {
+		return def, ""
+	}
+	return strings.TrimSpace(def[:idx]), strings.TrimSpace(def[idx+len("where"):])
+}
+
+var exclusionMethodPattern = regexp.MustCompile(`(?is)^exclude\s+using\s+(\w+)`)
+
+// Method returns the index access method of the constraint.
+func (e *ExclusionDef) Method() string {
+	m := exclusionMethodPattern.FindStringSubmatch(strings.TrimSpace(e.Def))
+	if m == nil {
+		return "btree"
+	}
+	return strings.ToLower(m[1])
+}
+
+var (
+	namedExclusionPattern = regexp.MustCompile(`(?is)^constraint\s+("(?:[^"]|"")+"|\w+)\s+(exclude\b.*)$`)
+	exclusionPattern      = regexp.MustCompile(`(?is)^exclude\b`)
+)
+
+// stripExclusions cuts EXCLUDE constraints off from CREATE TABLE statement.
+func stripExclusions(stmt string) (string, []*ExclusionDef, error) {
+	var exclusions []*ExclusionDef
+	s, err := mapCreateTableElements(stmt, func(elem string) (string, error) {
+		if m := namedExclusionPattern.FindStringSubmatch(elem); m != nil {
+			exclusions = append(exclusions, &ExclusionDef{Name: ParseIdentifier(m[1]), Def: m[2]})
+			return "", nil
+		}
+		if exclusionPattern.MatchString(elem) {
+			return "", errors.Errorf("EXCLUDE constraint must be named: %s", elem)
+		}
+		return elem, nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	return s, exclusions, nil
+}
+
+type AddExclusionSpec struct {
+	TableName Identifier
+	Def       *ExclusionDef
+}
+
+func (a *AddExclusionSpec) ToSQLString() string {
+	return fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s %s", a.TableName.ToSQLString(), a.Def.Name.ToSQLString(), a.Def.Def)
+}
+
+// computeExclusionDiff compares EXCLUDE constraints by name.
+// A constraint whose definition is changed is dropped and added again.
+func computeExclusionDiff(tableName Identifier, targ []*ExclusionDef, current []*ExclusionDef) []*SchemaDiff {
+	var diffs []*SchemaDiff
+
+	find := func(defs []*ExclusionDef, name Identifier) *ExclusionDef {
+		for _, d := range defs {
+			if d.Name == name {
+				return d
+			}
+		}
+		return nil
+	}
+
+	for _, c := range current {
+		t := find(targ, c.Name)
+		if t == nil || !t.equal(c) {
+			diffs = append(diffs, &SchemaDiff{
+				Type: DropTableConstraint,
+				Spec: &DropTableConstraintSpec{
+					TableName:       tableName,
+					ConstraintsName: c.Name,
+				},
+			})
+		}
+	}
+
+	for _, t := range targ {
+		c := find(current, t.Name)
+		if c == nil || !c.equal(t) {
+			diffs = append(diffs, &SchemaDiff{
+				Type: AddExclusion,
+				Spec: &AddExclusionSpec{
+					TableName: tableName,
+					Def:       t,
+				},
+			})
+		}
+	}
+
+	return diffs
+}
@@ -0,0 +1,120 @@
+package xmigrate
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStripExclusions(t *testing.T) {
+	t.Run("named", func(t *testing.T) {
+		s, exclusions, err := stripExclusions(`create table reservation (room_id int, during tsrange, constraint no_overlap exclude using gist (room_id with =, during with &&))`)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+
+		if s != "create table reservation (room_id int, during tsrange)" {
+			t.Errorf("unexpected statement %s", s)
+		}
+
+		expect := []*ExclusionDef{
+			{Name: "no_overlap", Def: "exclude using gist (room_id with =, during with &&)"},
+		}
+		if diff := cmp.Diff(exclusions, expect); diff != "" {
+			t.Errorf("diff: %s", diff)
+		}
+	})
+
+	t.Run("unnamed", func(t *testing.T) {
+		if _, _, err := stripExclusions(`create table reservation (during tsrange, exclude using gist (during with &&))`); err == nil {
+			t.Error("must be error")
+		}
+	})
+}
+
+func TestExclusionDef_Equal(t *testing.T) {
+	cases := []struct {
+		name   string
+		a      *ExclusionDef
+		b      *ExclusionDef
+		expect bool
+	}{
+		{
+			name:   "pg_get_constraintdef",
+			a:      &ExclusionDef{Name: "no_overlap", Def: "exclude using gist (room_id with =, during with &&)"},
+			b:      &ExclusionDef{Name: "no_overlap", Def: "EXCLUDE USING gist (room_id WITH =, during WITH &&)"},
+			expect: true,
+		},
+		{
+			name:   "predicate",
+			a:      &ExclusionDef{Name: "no_overlap", Def: "exclude using gist (during with &&) where (not canceled)"},
+			b:      &ExclusionDef{Name: "no_overlap", Def: "EXCLUDE USING gist (during WITH &&) WHERE ((NOT canceled))"},
+			expect: true,
+		},
+		{
+			name:   "operator",
+			a:      &ExclusionDef{Name: "no_overlap", Def: "exclude using gist (during with &&)"},
+			b:      &ExclusionDef{Name: "no_overlap", Def: "EXCLUDE USING gist (during WITH =)"},
+			expect: false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if c.a.equal(c.b) != c.expect {
+				t.Errorf("expect %v but not", c.expect)
+			}
+		})
+	}
+}
+
+func TestDiff_Exclusion(t *testing.T) {
+	targ, err := ParseSchema(bytes.NewBufferString(`create table reservation (id int primary key, room_id int, during tsrange, constraint no_overlap exclude using gist (room_id with =, during with &&));`))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	cases := []struct {
+		name   string
+		curr   []*ExclusionDef
+		expect []string
+	}{
+		{
+			name:   "add",
+			expect: []string{"ALTER TABLE reservation ADD CONSTRAINT no_overlap exclude using gist (room_id with =, during with &&)"},
+		},
+		{
+			name: "same",
+			curr: []*ExclusionDef{{Name: "no_overlap", Def: "EXCLUDE USING gist (room_id WITH =, during WITH &&)"}},
+		},
+		{
+			name: "change",
+			curr: []*ExclusionDef{{Name: "no_overlap", Def: "EXCLUDE USING gist (during WITH &&)"}},
+			expect: []string{
+				"ALTER TABLE reservation DROP CONSTRAINT no_overlap",
+				"ALTER TABLE reservation ADD CONSTRAINT no_overlap exclude using gist (room_id with =, during with &&)",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			curr := genTableDef(t, "create table reservation (id int primary key, room_id int, during tsrange);")
+			curr[0].Exclusions = c.curr
+
+			diffs, err := Diff(targ, curr)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+
+			var sqls []string
+			for _, d := range diffs {
+				sqls = append(sqls, d.Spec.ToSQLString())
+			}
+			if diff := cmp.Diff(sqls, c.expect); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package xmigrate
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ExtensionDef is an extension installed with CREATE EXTENSION.
+type ExtensionDef struct {
+	Name   string
+	Schema string
+}
+
+var createExtensionPattern = regexp.MustCompile(`(?is)^\s*create\s+extension\s+(?:if\s+not\s+exists\s+)?("(?:[^"]|"")+"|[\w-]+)(?:\s+with)?(?:\s+schema\s+("(?:[^"]|"")+"|\w+))?(?:\s+version\s+\S+)?(?:\s+cascade)?\s*$`)
+
+// parseCreateExtension parses CREATE EXTENSION statement.
+// It returns nil if stmt is not a CREATE EXTENSION.
+func parseCreateExtension(stmt string) *ExtensionDef {
+	m := createExtensionPattern.FindStringSubmatch(stmt)
+	if m == nil {
+		return nil
+	}
+
+	return &ExtensionDef{
+		Name:   strings.Trim(m[1], `"`),
+		Schema: strings.Trim(m[2], `"`),
+	}
+}
+
+type CreateExtensionSpec struct {
+	Def *ExtensionDef
+}
+
+func (c *CreateExtensionSpec) ToSQLString() string {
+	sql := fmt.Sprintf("CREATE EXTENSION IF NOT EXISTS %s", quoteIdent(c.Def.Name))
+	if c.Def.Schema != "" {
+		sql += " SCHEMA " + quoteIdent(c.Def.Schema)
+	}
+	return sql
+}
+
+// DiffExtensions returns extensions which are declared in schema but not installed.
+// Extensions are never dropped since they are often installed by others
+// (e.g. DBAs or the hosting provider).
+func DiffExtensions(targ []*ExtensionDef, current []*ExtensionDef) []*SchemaDiff {
+	var diffs []*SchemaDiff
+
+	installed := make(map[string]struct{})
+	for _, c := range current {
+		installed[c.Name] = struct{}{}
+	}
+
+	for _, t := range targ {
+		if _, ok := installed[t.Name]; ok {
+			continue
+		}
+		diffs = append(diffs, &SchemaDiff{
+			Type: AddExtension,
+			Spec: &CreateExtensionSpec{Def: t},
+		})
+	}
+
+	return diffs
+}
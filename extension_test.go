@@ -0,0 +1,53 @@
+package xmigrate
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseCreateExtension(t *testing.T) {
+	cases := []struct {
+		name   string
+		src    string
+		expect *ExtensionDef
+	}{
+		{
+			name:   "simple",
+			src:    "create extension btree_gist",
+			expect: &ExtensionDef{Name: "btree_gist"},
+		},
+		{
+			name:   "with schema",
+			src:    `CREATE EXTENSION IF NOT EXISTS "uuid-ossp" WITH SCHEMA public VERSION '1.1'`,
+			expect: &ExtensionDef{Name: "uuid-ossp", Schema: "public"},
+		},
+		{
+			name:   "not an extension",
+			src:    "create table a (id int)",
+			expect: nil,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if diff := cmp.Diff(parseCreateExtension(c.src), c.expect); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestDiffExtensions(t *testing.T) {
+	targ := []*ExtensionDef{{Name: "btree_gist"}, {Name: "pgcrypto"}}
+	current := []*ExtensionDef{{Name: "plpgsql", Schema: "pg_catalog"}, {Name: "pgcrypto", Schema: "public"}}
+
+	var sqls []string
+	for _, d := range DiffExtensions(targ, current) {
+		sqls = append(sqls, d.Spec.ToSQLString())
+	}
+
+	if diff := cmp.Diff(sqls, []string{"CREATE EXTENSION IF NOT EXISTS btree_gist"}); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+}
@@ -0,0 +1,326 @@
+package xmigrate
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/akito0107/xsqlparser/sqlast"
+	errors "golang.org/x/xerrors"
+)
+
+// ObjectKind is the kind of objects which Filter rules apply to.
+type ObjectKind int
+
+const (
+	TableObject ObjectKind = iota
+	// ColumnObject rules match "table.column".
+	ColumnObject
+	IndexObject
+	// ConstraintObject rules match "table.constraint".
+	ConstraintObject
+	CollationObject
+	ExtensionObject
+)
+
+var objectKinds = map[string]ObjectKind{
+	"table":      TableObject,
+	"column":     ColumnObject,
+	"index":      IndexObject,
+	"constraint": ConstraintObject,
+	"collation":  CollationObject,
+	"extension":  ExtensionObject,
+}
+
+// ParseObjectKind parses the name of ObjectKind such as "table" or "index".
+func ParseObjectKind(s string) (ObjectKind, error) {
+	k, ok := objectKinds[strings.ToLower(s)]
+	if !ok {
+		return 0, errors.Errorf("unknown object kind: %s", s)
+	}
+	return k, nil
+}
+
+type matcher func(name string) bool
+
+// newMatcher compiles pattern. A pattern enclosed in slashes (/^tmp_/) is a
+// regular expression and others are globs (tmp_*).
+func newMatcher(pattern string) (matcher, error) {
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, errors.Errorf("invalid pattern %s: %w", pattern, err)
+		}
+		return re.MatchString, nil
+	}
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, errors.Errorf("invalid pattern %s: %w", pattern, err)
+	}
+	return func(name string) bool {
+		ok, _ := path.Match(pattern, name)
+		return ok
+	}, nil
+}
+
+// Filter decides which objects are managed. Objects which are not managed
+// are neither dumped nor compared, so they are never created, altered or dropped.
+// An object is managed when it matches one of include rules of its kind (or
+// there is no include rule of the kind) and matches no exclude rule.
+// Names are matched as folded identifiers, i.e. lower case unless quoted.
+// A nil Filter manages everything.
+type Filter struct {
+	include map[ObjectKind][]matcher
+	exclude map[ObjectKind][]matcher
+}
+
+func NewFilter() *Filter {
+	return &Filter{
+		include: make(map[ObjectKind][]matcher),
+		exclude: make(map[ObjectKind][]matcher),
+	}
+}
+
+// DefaultFilter excludes the table xmigrate, which is used by xmigrate itself.
+func DefaultFilter() *Filter {
+	f := NewFilter()
+	f.exclude[TableObject] = []matcher{func(name string) bool { return name == HistoryTable }}
+	return f
+}
+
+func (f *Filter) Include(kind ObjectKind, pattern string) error {
+	m, err := newMatcher(pattern)
+	if err != nil {
+		return err
+	}
+	f.include[kind] = append(f.include[kind], m)
+	return nil
+}
+
+func (f *Filter) Exclude(kind ObjectKind, pattern string) error {
+	m, err := newMatcher(pattern)
+	if err != nil {
+		return err
+	}
+	f.exclude[kind] = append(f.exclude[kind], m)
+	return nil
+}
+
+// Managed reports whether the object is managed.
+func (f *Filter) Managed(kind ObjectKind, name string) bool {
+	if f == nil {
+		return true
+	}
+	for _, m := range f.exclude[kind] {
+		if m(name) {
+			return false
+		}
+	}
+	if len(f.include[kind]) == 0 {
+		return true
+	}
+	for _, m := range f.include[kind] {
+		if m(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Filter) managedColumn(table Identifier, column string) bool {
+	return f.Managed(ColumnObject, string(table)+"."+column)
+}
+
+func (f *Filter) managedConstraint(table Identifier, constraint string) bool {
+	return f.Managed(ConstraintObject, string(table)+"."+constraint)
+}
+
+// FilterTables returns tables without unmanaged objects. tables are not modified.
+func (f *Filter) FilterTables(tables []*TableDef) []*TableDef {
+	if f == nil {
+		return tables
+	}
+
+	var res []*TableDef
+	for _, t := range tables {
+		if !f.Managed(TableObject, string(t.Name)) {
+			continue
+		}
+		def := *t
+
+		def.Columns = make(map[string]*sqlast.ColumnDef)
+		for n, c := range t.Columns {
+			if f.managedColumn(t.Name, n) {
+				def.Columns[n] = c
+			}
+		}
+		def.ColumnOrder = nil
+		for _, n := range t.ColumnOrder {
+			if f.managedColumn(t.Name, n) {
+				def.ColumnOrder = append(def.ColumnOrder, n)
+			}
+		}
+		def.ColumnCollations = make(map[string]string)
+		for n, c := range t.ColumnCollations {
+			if f.managedColumn(t.Name, n) {
+				def.ColumnCollations[n] = c
+			}
+		}
+
+		def.Constrains = nil
+		for _, c := range t.Constrains {
+			if f.managedConstraint(t.Name, string(ParseIdentifier(c.Name.ToSQLString()))) {
+				def.Constrains = append(def.Constrains, c)
+			}
+		}
+		def.Exclusions = f.filterExclusions(t.Name, t.Exclusions)
+		def.UnsupportedConstraints = nil
+		for _, c := range t.UnsupportedConstraints {
+			if f.managedConstraint(t.Name, c) {
+				def.UnsupportedConstraints = append(def.UnsupportedConstraints, c)
+			}
+		}
+
+		def.Indexes = make(map[string]*IndexDef)
+		for n, i := range t.Indexes {
+			if f.Managed(IndexObject, n) {
+				def.Indexes[n] = i
+			}
+		}
+
+		res = append(res, &def)
+	}
+
+	return res
+}
+
+func (f *Filter) filterExclusions(table Identifier, exclusions []*ExclusionDef) []*ExclusionDef {
+	var res []*ExclusionDef
+	for _, e := range exclusions {
+		if f.managedConstraint(table, string(e.Name)) {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+// FilterTarget returns targ without unmanaged objects. targ is not modified.
+func (f *Filter) FilterTarget(targ *TargetTable) *TargetTable {
+	if f == nil {
+		return targ
+	}
+
+	res := &TargetTable{
+		PartitionKeys:    make(map[string]*PartitionKey),
+		ColumnCollations: make(map[string]map[string]string),
+		Exclusions:       make(map[string][]*ExclusionDef),
+		Storage:          make(map[string]*TableStorage),
+		IdentityColumns:  make(map[string]map[string]string),
+	}
+
+	for _, t := range targ.TableDef {
+		name := ParseIdentifier(t.Name.ToSQLString())
+		if !f.Managed(TableObject, string(name)) {
+			continue
+		}
+
+		stmt := *t
+		stmt.Elements = nil
+		for _, e := range t.Elements {
+			switch el := e.(type) {
+			case *sqlast.ColumnDef:
+				if !f.managedColumn(name, string(ParseIdentifier(el.Name.ToSQLString()))) {
+					continue
+				}
+			case *sqlast.TableConstraint:
+				if !f.managedConstraint(name, string(ParseIdentifier(el.Name.ToSQLString()))) {
+					continue
+				}
+			}
+			stmt.Elements = append(stmt.Elements, e)
+		}
+		res.TableDef = append(res.TableDef, &stmt)
+
+		n := string(name)
+		if k, ok := targ.PartitionKeys[n]; ok {
+			res.PartitionKeys[n] = k
+		}
+		if s, ok := targ.Storage[n]; ok {
+			res.Storage[n] = s
+		}
+		if cs, ok := targ.ColumnCollations[n]; ok {
+			collations := make(map[string]string)
+			for c, coll := range cs {
+				if f.managedColumn(name, c) {
+					collations[c] = coll
+				}
+			}
+			res.ColumnCollations[n] = collations
+		}
+		if ids, ok := targ.IdentityColumns[n]; ok {
+			identities := make(map[string]string)
+			for c, kind := range ids {
+				if f.managedColumn(name, c) {
+					identities[c] = kind
+				}
+			}
+			res.IdentityColumns[n] = identities
+		}
+		if es := f.filterExclusions(name, targ.Exclusions[n]); len(es) > 0 {
+			res.Exclusions[n] = es
+		}
+	}
+
+	for _, p := range targ.Partitions {
+		if f.Managed(TableObject, string(p.Name)) {
+			res.Partitions = append(res.Partitions, p)
+		}
+	}
+	for _, i := range targ.IndexDef {
+		if f.Managed(TableObject, string(i.Table)) && f.Managed(IndexObject, string(i.Name)) {
+			res.IndexDef = append(res.IndexDef, i)
+		}
+	}
+	res.Collations = f.FilterCollations(targ.Collations)
+	res.Extensions = f.FilterExtensions(targ.Extensions)
+
+	return res
+}
+
+func (f *Filter) FilterCollations(collations []*CollationDef) []*CollationDef {
+	if f == nil {
+		return collations
+	}
+	var res []*CollationDef
+	for _, c := range collations {
+		if f.Managed(CollationObject, c.Name) {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+func (f *Filter) FilterExtensions(extensions []*ExtensionDef) []*ExtensionDef {
+	if f == nil {
+		return extensions
+	}
+	var res []*ExtensionDef
+	for _, e := range extensions {
+		if f.Managed(ExtensionObject, e.Name) {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+// FilterSchema returns schema without unmanaged objects. schema is not modified.
+func (f *Filter) FilterSchema(schema *Schema) *Schema {
+	return &Schema{
+		Tables:     f.FilterTables(schema.Tables),
+		Collations: f.FilterCollations(schema.Collations),
+		Extensions: f.FilterExtensions(schema.Extensions),
+
+		DefaultTablespace: schema.DefaultTablespace,
+		TimeZone:          schema.TimeZone,
+	}
+}
@@ -0,0 +1,141 @@
+package xmigrate
+
+import (
+	"testing"
+
+	"github.com/akito0107/xsqlparser/sqlast"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFilter_Managed(t *testing.T) {
+	f := DefaultFilter()
+	if err := f.Exclude(TableObject, "spatial_ref_sys"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Exclude(TableObject, "/^queue_[0-9]+$/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Include(IndexObject, "app_*"); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		kind   ObjectKind
+		name   string
+		expect bool
+	}{
+		{kind: TableObject, name: "account", expect: true},
+		{kind: TableObject, name: "xmigrate", expect: false},
+		{kind: TableObject, name: "spatial_ref_sys", expect: false},
+		{kind: TableObject, name: "queue_1", expect: false},
+		{kind: TableObject, name: "queue_a", expect: true},
+		{kind: IndexObject, name: "app_name_idx", expect: true},
+		{kind: IndexObject, name: "name_idx", expect: false},
+		{kind: ColumnObject, name: "account.name", expect: true},
+	}
+
+	for _, c := range cases {
+		if act := f.Managed(c.kind, c.name); act != c.expect {
+			t.Errorf("Managed(%d, %s) must be %v", c.kind, c.name, c.expect)
+		}
+	}
+
+	var nilFilter *Filter
+	if !nilFilter.Managed(TableObject, "xmigrate") {
+		t.Errorf("nil filter must manage everything")
+	}
+}
+
+func TestFilter_InvalidPattern(t *testing.T) {
+	f := NewFilter()
+	if err := f.Exclude(TableObject, "/(/"); err == nil {
+		t.Errorf("must be error")
+	}
+	if err := f.Exclude(TableObject, "[a"); err == nil {
+		t.Errorf("must be error")
+	}
+	if _, err := ParseObjectKind("sequence"); err == nil {
+		t.Errorf("must be error")
+	}
+}
+
+func TestFilter_FilterTables(t *testing.T) {
+	f := NewFilter()
+	if err := f.Exclude(ColumnObject, "account.legacy_*"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Exclude(TableObject, "spatial_ref_sys"); err != nil {
+		t.Fatal(err)
+	}
+
+	tables := []*TableDef{
+		{
+			Name: "account",
+			Columns: map[string]*sqlast.ColumnDef{
+				"id":          {Name: sqlast.NewIdent("id"), DataType: &sqlast.Int{}},
+				"legacy_name": {Name: sqlast.NewIdent("legacy_name"), DataType: &sqlast.Int{}},
+			},
+			ColumnOrder: []string{"id", "legacy_name"},
+		},
+		{Name: "spatial_ref_sys"},
+	}
+
+	filtered := f.FilterTables(tables)
+	if len(filtered) != 1 {
+		t.Fatalf("must be 1 table but %d", len(filtered))
+	}
+	if diff := cmp.Diff([]string{"id"}, filtered[0].ColumnOrder); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+	if _, ok := filtered[0].Columns["legacy_name"]; ok {
+		t.Errorf("legacy_name must be excluded")
+	}
+	if len(tables[0].Columns) != 2 {
+		t.Errorf("original tables must not be modified")
+	}
+}
+
+func TestFilter_FilterSchema(t *testing.T) {
+	f := NewFilter()
+	if err := f.Exclude(ConstraintObject, "account.legacy_*"); err != nil {
+		t.Fatal(err)
+	}
+
+	schema := &Schema{
+		Tables: []*TableDef{{
+			Name:                   "account",
+			Columns:                map[string]*sqlast.ColumnDef{},
+			UnsupportedConstraints: []string{"legacy_check", "positive_id"},
+		}},
+		DefaultTablespace: "fast_ssd",
+		TimeZone:          "Asia/Tokyo",
+	}
+
+	filtered := f.FilterSchema(schema)
+	if filtered.DefaultTablespace != "fast_ssd" || filtered.TimeZone != "Asia/Tokyo" {
+		t.Errorf("unexpected schema %+v", filtered)
+	}
+	if diff := cmp.Diff([]string{"positive_id"}, filtered.Tables[0].UnsupportedConstraints); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+}
+
+func TestDiff_Filter(t *testing.T) {
+	f := DefaultFilter()
+	if err := f.Exclude(TableObject, "spatial_ref_sys"); err != nil {
+		t.Fatal(err)
+	}
+
+	current := []*TableDef{
+		{Name: "spatial_ref_sys", Columns: map[string]*sqlast.ColumnDef{}},
+		{Name: "xmigrate", Columns: map[string]*sqlast.ColumnDef{}},
+	}
+
+	diffs, err := Diff(&TargetTable{}, current, WithFilter(f))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(diffs) != 0 {
+		t.Errorf("unmanaged tables must not be dropped but %+v", diffs)
+	}
+}
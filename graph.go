@@ -1,6 +1,8 @@
 package xmigrate
 
 import (
+	"fmt"
+
 	"github.com/akito0107/xsqlparser/sqlast"
 
 	"github.com/akito0107/xmigrate/toposort"
@@ -31,28 +33,44 @@ func CalcGraph(diffs []*SchemaDiff) *toposort.Graph {
 	for _, d := range diffs {
 		switch spec := d.Spec.(type) {
 		case *AddTableSpec:
-			for _, e := range spec.SQL.Elements {
-				switch el := e.(type) {
-				case *sqlast.ColumnDef:
-					for _, c := range el.Constraints {
-						ref, ok := c.Spec.(*sqlast.ReferencesColumnSpec)
-						if !ok {
-							continue
-						}
-						deps[d] = append(deps[d], ref.TableName.ToSQLString())
-					}
+			deps[d] = append(deps[d], referencedTables(spec.SQL)...)
 
-				case *sqlast.TableConstraint:
-					ref, ok := el.Spec.(*sqlast.ReferentialTableConstraint)
-					if !ok {
-						continue
-					}
-					deps[d] = append(deps[d], ref.KeyExpr.TableName.ToSQLString())
-				}
+			for _, c := range spec.Collations {
+				deps[d] = append(deps[d], collationKey(c))
 			}
 
 			// add table name to memo
-			tables[spec.SQL.Name.ToSQLString()] = []string{spec.ToSQLString()}
+			name := string(ParseIdentifier(spec.SQL.Name.ToSQLString()))
+			tables[name] = []string{spec.ToSQLString()}
+
+		case *RebuildTableSpec:
+			name := spec.tableName()
+			// each step waits for the previous ones
+			for s := RebuildCopy; s < spec.Step; s++ {
+				deps[d] = append(deps[d], rebuildKey(name, s))
+			}
+			tables[rebuildKey(name, spec.Step)] = []string{spec.ToSQLString()}
+
+			switch spec.Step {
+			case RebuildDrop:
+				for _, c := range spec.PrevCollations {
+					tables[releasedCollationKey(c)] = append(tables[releasedCollationKey(c)], spec.ToSQLString())
+				}
+			case RebuildCreate:
+				for _, t := range referencedTables(spec.Table.SQL) {
+					// self references are re-created with the table
+					if t != string(name) {
+						deps[d] = append(deps[d], t)
+					}
+				}
+				for _, c := range spec.Table.Collations {
+					deps[d] = append(deps[d], collationKey(c))
+				}
+			}
+			// the others referencing the table wait for its rows to be back
+			if spec.Step >= RebuildCreate {
+				tables[string(name)] = append(tables[string(name)], spec.ToSQLString())
+			}
 
 		case *AddColumnSpec:
 			for _, c := range spec.ColumnDef.Constraints {
@@ -60,18 +78,63 @@ func CalcGraph(diffs []*SchemaDiff) *toposort.Graph {
 				if !ok {
 					continue
 				}
-				deps[d] = append(deps[d], ref.TableName.ToSQLString())
+				deps[d] = append(deps[d], string(ParseIdentifier(ref.TableName.ToSQLString())))
+			}
+			if spec.Collation != "" {
+				deps[d] = append(deps[d], collationKey(spec.Collation))
 			}
-			tables[spec.TableName] = append(tables[spec.TableName], spec.ToSQLString())
+			tables[string(spec.TableName)] = append(tables[string(spec.TableName)], spec.ToSQLString())
 
 		case *AddTableConstraintSpec:
 			ref, ok := spec.ConstraintDef.Spec.(*sqlast.ReferentialTableConstraint)
 			if ok {
-				deps[d] = append(deps[d], ref.KeyExpr.TableName.ToSQLString())
+				deps[d] = append(deps[d], string(ParseIdentifier(ref.KeyExpr.TableName.ToSQLString())))
 			}
-			tables[spec.TableName] = append(tables[spec.TableName], spec.ToSQLString())
+			tables[string(spec.TableName)] = append(tables[string(spec.TableName)], spec.ToSQLString())
 		case *AddIndexSpec:
-			deps[d] = append(deps[d], spec.Def.TableName.ToSQLString())
+			deps[d] = append(deps[d], string(spec.Def.Table), indexKey(spec.Def.Name))
+		case *DropIndexSpec:
+			// an index which is re-created must be dropped first
+			tables[indexKey(spec.IndexName)] = []string{spec.ToSQLString()}
+		case *EditColumnSpec:
+			if spec.Collation != "" {
+				deps[d] = append(deps[d], collationKey(spec.Collation))
+			}
+			if spec.PrevCollation != "" {
+				tables[releasedCollationKey(spec.PrevCollation)] = append(tables[releasedCollationKey(spec.PrevCollation)], spec.ToSQLString())
+			}
+		case *DropColumnSpec:
+			if spec.Collation != "" {
+				tables[releasedCollationKey(spec.Collation)] = append(tables[releasedCollationKey(spec.Collation)], spec.ToSQLString())
+			}
+		case *DropTableSpec:
+			for _, c := range spec.Collations {
+				tables[releasedCollationKey(c)] = append(tables[releasedCollationKey(c)], spec.ToSQLString())
+			}
+		case *CreateCollationSpec:
+			tables[collationKey(spec.Def.Name)] = []string{spec.ToSQLString()}
+		case *DropCollationSpec:
+			// a collation can't be dropped while any column uses it
+			deps[d] = append(deps[d], releasedCollationKey(spec.Name))
+		case *CreateExtensionSpec:
+			tables[extensionKey(spec.Def.Name)] = []string{spec.ToSQLString()}
+		case *DropTableConstraintSpec:
+			// a constraint which is re-created must be dropped first
+			tables[constraintKey(spec.TableName, spec.ConstraintsName)] = []string{spec.ToSQLString()}
+		case *AddExclusionSpec:
+			deps[d] = append(deps[d], string(spec.TableName), constraintKey(spec.TableName, spec.Def.Name))
+			// gist can't handle scalar types like integer without btree_gist
+			if spec.Def.Method() == "gist" {
+				deps[d] = append(deps[d], extensionKey("btree_gist"))
+			}
+		case *AddPartitionSpec:
+			deps[d] = append(deps[d], string(spec.Def.Parent))
+			tables[string(spec.Def.Name)] = append(tables[string(spec.Def.Name)], spec.ToSQLString())
+		case *DetachPartitionSpec:
+			// re-attaching with another bound must wait for detaching
+			tables[string(spec.TableName)] = append(tables[string(spec.TableName)], spec.ToSQLString())
+		case *AttachPartitionSpec:
+			deps[d] = append(deps[d], string(spec.Def.Parent), string(spec.Def.Name))
 		}
 	}
 
@@ -100,3 +163,60 @@ func CalcGraph(diffs []*SchemaDiff) *toposort.Graph {
 		Nodes: nodes,
 	}
 }
+
+// referencedTables returns the names of tables which stmt references by foreign keys.
+func referencedTables(stmt *sqlast.CreateTableStmt) []string {
+	var tables []string
+	for _, e := range stmt.Elements {
+		switch el := e.(type) {
+		case *sqlast.ColumnDef:
+			for _, c := range el.Constraints {
+				ref, ok := c.Spec.(*sqlast.ReferencesColumnSpec)
+				if !ok {
+					continue
+				}
+				tables = append(tables, string(ParseIdentifier(ref.TableName.ToSQLString())))
+			}
+
+		case *sqlast.TableConstraint:
+			ref, ok := el.Spec.(*sqlast.ReferentialTableConstraint)
+			if !ok {
+				continue
+			}
+			tables = append(tables, string(ParseIdentifier(ref.KeyExpr.TableName.ToSQLString())))
+		}
+	}
+	return tables
+}
+
+// indexKey is used in place of table name for the dependency on dropping index.
+func indexKey(name Identifier) string {
+	return "index:" + string(name)
+}
+
+// collationKey is used in place of table name for the dependency on collation.
+func collationKey(name string) string {
+	return "collation:" + name
+}
+
+// releasedCollationKey is used in place of table name for the dependency on
+// columns which stop using collation. It's apart from collationKey, so that
+// columns swapping their collations don't depend on each other.
+func releasedCollationKey(name string) string {
+	return "released-collation:" + name
+}
+
+// rebuildKey is used in place of table name for the dependency on a step of rebuilding table.
+func rebuildKey(name Identifier, step RebuildStep) string {
+	return fmt.Sprintf("rebuild:%s:%d", name, step)
+}
+
+// constraintKey is used in place of table name for the dependency on dropping constraint.
+func constraintKey(tableName, name Identifier) string {
+	return "constraint:" + string(tableName) + "." + string(name)
+}
+
+// extensionKey is used in place of table name for the dependency on extension.
+func extensionKey(name string) string {
+	return "extension:" + name
+}
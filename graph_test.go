@@ -6,6 +6,7 @@ import (
 
 	"github.com/akito0107/xsqlparser"
 	"github.com/akito0107/xsqlparser/dialect"
+	"github.com/akito0107/xsqlparser/sqlast"
 	"github.com/google/go-cmp/cmp"
 
 	"github.com/akito0107/xmigrate/toposort"
@@ -92,3 +93,100 @@ func TestCalcGraph(t *testing.T) {
 	}
 
 }
+
+func TestCalcGraph_DropCollation(t *testing.T) {
+	dropCollation := &SchemaDiff{Type: DropCollation, Spec: &DropCollationSpec{Name: "german"}}
+
+	cases := []struct {
+		name    string
+		release *SchemaDiff
+	}{
+		{
+			name:    "drop column",
+			release: &SchemaDiff{Type: DropColumn, Spec: &DropColumnSpec{TableName: "account", ColumnName: "name", Collation: "german"}},
+		},
+		{
+			name:    "drop table",
+			release: &SchemaDiff{Type: DropTable, Spec: &DropTableSpec{TableName: "account", Collations: map[string]string{"name": "german"}}},
+		},
+		{
+			name: "reset collation",
+			release: &SchemaDiff{Type: EditColumn, Spec: &EditColumnSpec{
+				Type:       EditType,
+				TableName:  "account",
+				ColumnName: "name",
+				SQL: &sqlast.AlterTableStmt{
+					TableName: sqlast.NewSQLObjectName("account"),
+					Action: &sqlast.AlterColumnTableAction{
+						ColumnName: sqlast.NewIdent("name"),
+						Action:     &sqlast.PGAlterDataTypeColumnAction{DataType: &sqlast.Custom{Ty: sqlast.NewSQLObjectName("text")}},
+					},
+				},
+				Collation:     "default",
+				PrevCollation: "german",
+			}},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			resolved, err := toposort.ResolveGraph(CalcGraph([]*SchemaDiff{dropCollation, c.release}))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var act []string
+			for _, r := range resolved.Nodes {
+				act = append(act, r.(*DiffNode).Diff.Spec.ToSQLString())
+			}
+			expect := []string{c.release.Spec.ToSQLString(), dropCollation.Spec.ToSQLString()}
+
+			if d := cmp.Diff(act, expect); d != "" {
+				t.Errorf("diff: %s", d)
+			}
+		})
+	}
+}
+
+func TestCalcGraph_RebuildTable(t *testing.T) {
+	table := &AddTableSpec{
+		SQL: &sqlast.CreateTableStmt{
+			Name: sqlast.NewSQLObjectName("account"),
+			Elements: []sqlast.TableElement{
+				&sqlast.ColumnDef{Name: sqlast.NewIdent("id"), DataType: &sqlast.Custom{Ty: sqlast.NewSQLObjectName("serial")}},
+			},
+		},
+	}
+	current := &TableDef{
+		Name:        "account",
+		Columns:     map[string]*sqlast.ColumnDef{"id": {Name: sqlast.NewIdent("id"), DataType: &sqlast.Int{}}},
+		ColumnOrder: []string{"id"},
+	}
+	steps, err := createRebuildTableSpecs(table, current)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	var expect []string
+	for _, d := range steps {
+		expect = append(expect, d.Spec.ToSQLString())
+	}
+
+	// the steps are kept in order even if they are given in reverse
+	var reversed []*SchemaDiff
+	for i := len(steps) - 1; i >= 0; i-- {
+		reversed = append(reversed, steps[i])
+	}
+	resolved, err := toposort.ResolveGraph(CalcGraph(reversed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var act []string
+	for _, r := range resolved.Nodes {
+		act = append(act, r.(*DiffNode).Diff.Spec.ToSQLString())
+	}
+
+	if d := cmp.Diff(act, expect); d != "" {
+		t.Errorf("diff: %s", d)
+	}
+}
@@ -0,0 +1,167 @@
+package xmigrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	errors "golang.org/x/xerrors"
+)
+
+// Version is the version of xmigrate recorded in the history, which is set with
+// -ldflags "-X github.com/akito0107/xmigrate.Version=..." on build.
+var Version = "dev"
+
+// HistoryTable is the table in public schema which records applies. It's
+// excluded by DefaultFilter, so it's never dumped or dropped.
+const HistoryTable = "xmigrate"
+
+// the table is qualified, so that search_path doesn't move it.
+const historyTableSQL = `create table if not exists public.xmigrate (
+	id bigserial primary key,
+	started_at timestamptz not null default now(),
+	finished_at timestamptz,
+	db_user text not null default current_user,
+	os_user text not null,
+	tool_version text not null,
+	schema_checksum text not null,
+	statements text[] not null,
+	outcome text not null,
+	error text
+)`
+
+// Outcomes of applies. An apply stays running when the process dies on the way.
+const (
+	OutcomeRunning = "running"
+	OutcomeSuccess = "success"
+	OutcomeFailed  = "failed"
+)
+
+// HistoryEntry is a row of the history table.
+type HistoryEntry struct {
+	ID             int64          `db:"id"`
+	StartedAt      time.Time      `db:"started_at"`
+	FinishedAt     pq.NullTime    `db:"finished_at"`
+	DBUser         string         `db:"db_user"`
+	OSUser         string         `db:"os_user"`
+	ToolVersion    string         `db:"tool_version"`
+	SchemaChecksum string         `db:"schema_checksum"`
+	Statements     pq.StringArray `db:"statements"`
+	Outcome        string         `db:"outcome"`
+	Error          *string        `db:"error"`
+}
+
+// History records applies in the history table.
+// Rows are written in autocommit mode, so a failed apply is recorded even
+// though its transaction is rolled back.
+type History struct {
+	db *sqlx.DB
+}
+
+func NewHistory(db *sqlx.DB) *History {
+	return &History{db: db}
+}
+
+// Start creates the history table if needed and records that the apply of
+// statements has started. The returned id is passed to Finish.
+func (h *History) Start(ctx context.Context, checksum string, statements []string) (int64, error) {
+	if _, err := h.db.ExecContext(ctx, historyTableSQL); err != nil {
+		return 0, errors.Errorf("create history table failed: %w", err)
+	}
+
+	var id int64
+	if err := h.db.GetContext(ctx, &id, `insert into public.xmigrate
+				(os_user, tool_version, schema_checksum, statements, outcome)
+				values ($1, $2, $3, $4, $5) returning id`,
+		osUser(), Version, checksum, pq.Array(statements), OutcomeRunning); err != nil {
+		return 0, errors.Errorf("insert history failed: %w", err)
+	}
+	return id, nil
+}
+
+// Finish records the outcome of the apply, which is failed when applyErr is not nil.
+func (h *History) Finish(ctx context.Context, id int64, applyErr error) error {
+	outcome := OutcomeSuccess
+	var msg *string
+	if applyErr != nil {
+		outcome = OutcomeFailed
+		s := applyErr.Error()
+		msg = &s
+	}
+	if _, err := h.db.ExecContext(ctx, `update public.xmigrate set finished_at = now(), outcome = $1, error = $2 where id = $3`,
+		outcome, msg, id); err != nil {
+		return errors.Errorf("update history failed: %w", err)
+	}
+	return nil
+}
+
+// Apply applies phases in the same way as the package level Apply, and records
+// it with checksum of the schema. Nothing is recorded when phases have no
+// statements. If the outcome of a failed apply can't be recorded, the returned
+// error tells so and still wraps the ApplyError.
+func (h *History) Apply(ctx context.Context, checksum string, phases []*ApplyPhase, opts ...ApplyOption) error {
+	var stmts []string
+	for _, p := range phases {
+		stmts = append(stmts, p.Statements...)
+	}
+	if len(stmts) == 0 {
+		return nil
+	}
+
+	id, err := h.Start(ctx, checksum, stmts)
+	if err != nil {
+		return err
+	}
+	applyErr := Apply(ctx, h.db, phases, opts...)
+	if err := h.Finish(ctx, id, applyErr); err != nil {
+		if applyErr == nil {
+			return err
+		}
+		return errors.Errorf("recording the outcome failed (%v): %w", err, applyErr)
+	}
+	return applyErr
+}
+
+// Recent returns the last limit applies, the latest first.
+// It's empty when nothing has been applied yet.
+func (h *History) Recent(ctx context.Context, limit int) ([]*HistoryEntry, error) {
+	var exists bool
+	if err := h.db.GetContext(ctx, &exists, `select to_regclass('public.xmigrate') is not null`); err != nil {
+		return nil, errors.Errorf("getContext failed: %w", err)
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	var entries []*HistoryEntry
+	if err := h.db.SelectContext(ctx, &entries, `select
+					id, started_at, finished_at, db_user, os_user, tool_version,
+					schema_checksum, statements, outcome, error
+				from public.xmigrate order by id desc limit $1`, limit); err != nil {
+		return nil, errors.Errorf("selectContext failed: %w", err)
+	}
+	return entries, nil
+}
+
+// SchemaChecksum returns sha256 of the schema file, formatted as sha256:<hex>.
+func SchemaChecksum(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", errors.Errorf("read schema failed: %w", err)
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func osUser() string {
+	for _, env := range []string{"USER", "USERNAME"} {
+		if u := os.Getenv(env); u != "" {
+			return u
+		}
+	}
+	return "unknown"
+}
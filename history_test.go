@@ -0,0 +1,136 @@
+package xmigrate
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	errors "golang.org/x/xerrors"
+)
+
+func TestSchemaChecksum(t *testing.T) {
+	checksum, err := SchemaChecksum(bytes.NewBufferString("create table account (id serial primary key);\n"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	other, err := SchemaChecksum(bytes.NewBufferString("create table account (id bigserial primary key);\n"))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if len(checksum) != len("sha256:")+64 || checksum[:7] != "sha256:" {
+		t.Errorf("must be sha256:<hex> but %s", checksum)
+	}
+	if checksum == other {
+		t.Errorf("checksums of different schemas must differ")
+	}
+}
+
+func TestHistory(t *testing.T) {
+	ctx := context.Background()
+	db, err := Connect(ctx, ConnInfo{"dbname": "xmigrate_test", "host": "localhost", "port": "5432", "user": "postgres"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer db.Close()
+	defer db.Exec("DROP TABLE IF EXISTS public.xmigrate")
+
+	history := NewHistory(db)
+	entries, err := history.Recent(ctx, 10)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("must be empty but %d", len(entries))
+	}
+
+	stmts := []string{"CREATE TABLE history_test (id int)"}
+	first, err := history.Start(ctx, "sha256:0001", stmts)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := history.Finish(ctx, first, nil); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	second, err := history.Start(ctx, "sha256:0002", stmts)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := history.Finish(ctx, second, errors.New("relation \"history_test\" already exists")); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	entries, err = history.Recent(ctx, 10)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("must be 2 entries but %d", len(entries))
+	}
+
+	latest := entries[0]
+	if latest.ID != second || latest.Outcome != OutcomeFailed || latest.Error == nil || !latest.FinishedAt.Valid {
+		t.Errorf("unexpected entry: %+v", latest)
+	}
+	if diff := cmp.Diff(stmts, []string(latest.Statements)); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+	if entries[1].Outcome != OutcomeSuccess || entries[1].Error != nil || entries[1].SchemaChecksum != "sha256:0001" {
+		t.Errorf("unexpected entry: %+v", entries[1])
+	}
+	if latest.DBUser != "postgres" || latest.ToolVersion != Version {
+		t.Errorf("unexpected entry: %+v", latest)
+	}
+
+	// the history table is never dumped, so it doesn't make a diff
+	tables, err := NewPGDumpFromDB(db).Dump(ctx)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	for _, tbl := range tables {
+		if tbl.Name == HistoryTable {
+			t.Errorf("history table must not be dumped")
+		}
+	}
+}
+
+func TestHistory_Apply(t *testing.T) {
+	ctx := context.Background()
+	db, err := Connect(ctx, ConnInfo{"dbname": "xmigrate_test", "host": "localhost", "port": "5432", "user": "postgres"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer db.Close()
+	defer db.Exec("DROP TABLE IF EXISTS public.xmigrate")
+	defer db.Exec("DROP TABLE IF EXISTS history_apply_test")
+
+	history := NewHistory(db)
+	// an empty plan isn't recorded
+	if err := history.Apply(ctx, "sha256:0001", nil); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	ok := SplitPhases([]string{"CREATE TABLE history_apply_test (id int)"}, PG12)
+	if err := history.Apply(ctx, "sha256:0002", ok); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	err = history.Apply(ctx, "sha256:0003", ok)
+	var applyErr *ApplyError
+	if !errors.As(err, &applyErr) {
+		t.Fatalf("must be ApplyError but %+v", err)
+	}
+
+	entries, err := history.Recent(ctx, 10)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var outcomes []string
+	for _, e := range entries {
+		outcomes = append(outcomes, e.SchemaChecksum+" "+e.Outcome)
+	}
+	expect := []string{"sha256:0003 " + OutcomeFailed, "sha256:0002 " + OutcomeSuccess}
+	if diff := cmp.Diff(expect, outcomes); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+}
@@ -0,0 +1,73 @@
+package xmigrate
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Identifier is the name of a database object as it is stored in catalog.
+// Following the rule of PostgreSQL, unquoted names are folded to lower case
+// while quoted names are kept as they are, so "UserEvents" and userevents
+// are different tables.
+type Identifier string
+
+// ParseIdentifier folds the identifier written in SQL.
+// The public schema qualifier is dropped since xmigrate manages public schema only.
+func ParseIdentifier(src string) Identifier {
+	src = strings.TrimSpace(src)
+	if i := indexTopLevel(src, '.'); i >= 0 {
+		schema, name := foldIdentifier(src[:i]), foldIdentifier(src[i+1:])
+		if schema == "public" {
+			return Identifier(name)
+		}
+		return Identifier(schema + "." + name)
+	}
+	return Identifier(foldIdentifier(src))
+}
+
+// foldIdentifier unquotes quoted name and folds unquoted name to lower case.
+func foldIdentifier(src string) string {
+	src = strings.TrimSpace(src)
+	if len(src) > 1 && strings.HasPrefix(src, `"`) && strings.HasSuffix(src, `"`) {
+		return strings.Replace(src[1:len(src)-1], `""`, `"`, -1)
+	}
+	return strings.ToLower(src)
+}
+
+// ToSQLString returns the identifier quoted only when it is needed.
+func (i Identifier) ToSQLString() string {
+	return quoteIdent(string(i))
+}
+
+var simpleIdent = regexp.MustCompile(`^[a-z_][a-z0-9_$]*$`)
+
+// quoteIdent quotes identifier unless it's a plain lower-case name which is
+// not a reserved keyword.
+func quoteIdent(name string) string {
+	if simpleIdent.MatchString(name) {
+		if _, ok := reservedKeywords[name]; !ok {
+			return name
+		}
+	}
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// reservedKeywords are the keywords which can't be used as table or column names without quotes.
+// https://www.postgresql.org/docs/current/sql-keywords-appendix.html
+var reservedKeywords = map[string]struct{}{
+	"all": {}, "analyse": {}, "analyze": {}, "and": {}, "any": {}, "array": {}, "as": {}, "asc": {},
+	"asymmetric": {}, "authorization": {}, "binary": {}, "both": {}, "case": {}, "cast": {}, "check": {},
+	"collate": {}, "collation": {}, "column": {}, "concurrently": {}, "constraint": {}, "create": {},
+	"cross": {}, "current_catalog": {}, "current_date": {}, "current_role": {}, "current_schema": {},
+	"current_time": {}, "current_timestamp": {}, "current_user": {}, "default": {}, "deferrable": {},
+	"desc": {}, "distinct": {}, "do": {}, "else": {}, "end": {}, "except": {}, "false": {}, "fetch": {},
+	"for": {}, "foreign": {}, "freeze": {}, "from": {}, "full": {}, "grant": {}, "group": {}, "having": {},
+	"ilike": {}, "in": {}, "initially": {}, "inner": {}, "intersect": {}, "into": {}, "is": {}, "isnull": {},
+	"join": {}, "lateral": {}, "leading": {}, "left": {}, "like": {}, "limit": {}, "localtime": {},
+	"localtimestamp": {}, "natural": {}, "not": {}, "notnull": {}, "null": {}, "offset": {}, "on": {},
+	"only": {}, "or": {}, "order": {}, "outer": {}, "overlaps": {}, "placing": {}, "primary": {},
+	"references": {}, "returning": {}, "right": {}, "select": {}, "session_user": {}, "similar": {},
+	"some": {}, "symmetric": {}, "system_user": {}, "table": {}, "tablesample": {}, "then": {}, "to": {},
+	"trailing": {}, "true": {}, "union": {}, "unique": {}, "user": {}, "using": {}, "variadic": {},
+	"verbose": {}, "when": {}, "where": {}, "window": {}, "with": {},
+}
@@ -0,0 +1,86 @@
+package xmigrate
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/akito0107/xsqlparser/sqlast"
+)
+
+func TestParseIdentifier(t *testing.T) {
+	cases := []struct {
+		src    string
+		expect Identifier
+		sql    string
+	}{
+		{src: "account", expect: "account", sql: "account"},
+		{src: "Account", expect: "account", sql: "account"},
+		{src: `"UserEvents"`, expect: "UserEvents", sql: `"UserEvents"`},
+		{src: `"account"`, expect: "account", sql: "account"},
+		{src: "public.item", expect: "item", sql: "item"},
+		{src: `public."Item"`, expect: "Item", sql: `"Item"`},
+		{src: `"public"."Foo"`, expect: "Foo", sql: `"Foo"`},
+		{src: `PUBLIC."x.y"`, expect: "x.y", sql: `"x.y"`},
+		{src: `"user"`, expect: "user", sql: `"user"`},
+		{src: `"a""b"`, expect: `a"b`, sql: `"a""b"`},
+		{src: `"with space"`, expect: "with space", sql: `"with space"`},
+	}
+
+	for _, c := range cases {
+		t.Run(c.src, func(t *testing.T) {
+			id := ParseIdentifier(c.src)
+			if id != c.expect {
+				t.Errorf("expect %s but %s", c.expect, id)
+			}
+			if id.ToSQLString() != c.sql {
+				t.Errorf("expect %s but %s", c.sql, id.ToSQLString())
+			}
+		})
+	}
+}
+
+func TestDiff_QuotedIdentifier(t *testing.T) {
+	current := []*TableDef{
+		{
+			Name: "UserEvents",
+			Columns: map[string]*sqlast.ColumnDef{
+				"id": {Name: sqlast.NewIdent("id"), DataType: &sqlast.Int{}},
+			},
+		},
+	}
+
+	t.Run("no diff", func(t *testing.T) {
+		targ, err := ParseSchema(bytes.NewBufferString(`create table "UserEvents" (id int);`))
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		diffs, err := Diff(targ, current)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if len(diffs) != 0 {
+			t.Errorf("must be no diff but %d", len(diffs))
+		}
+	})
+
+	t.Run("add column", func(t *testing.T) {
+		targ, err := ParseSchema(bytes.NewBufferString(`create table "UserEvents" (ID int, "Kind" int);`))
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		diffs, err := Diff(targ, current)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if len(diffs) != 1 {
+			t.Fatalf("must be 1 diff but %d", len(diffs))
+		}
+		spec, ok := diffs[0].Spec.(*AddColumnSpec)
+		if !ok {
+			t.Fatalf("unexpected spec %+v", diffs[0].Spec)
+		}
+		if spec.TableName != "UserEvents" || ParseIdentifier(spec.ColumnDef.Name.ToSQLString()) != "Kind" {
+			t.Errorf("unexpected spec %+v", spec)
+		}
+	})
+}
@@ -0,0 +1,417 @@
+package xmigrate
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	errors "golang.org/x/xerrors"
+)
+
+// IndexDef is a CREATE INDEX statement.
+// It is parsed by xmigrate itself since sqlast.CreateIndexStmt can't hold
+// expressions, INCLUDE columns, opclasses and so on.
+type IndexDef struct {
+	Name   Identifier
+	Table  Identifier
+	Unique bool
+	// Method is the access method (btree, gin, gist, brin, hash ...).
+	Method           string
+	Columns          []*IndexColumn
+	Include          []string
+	NullsNotDistinct bool
+	// Params holds storage parameters given with WITH clause.
+	Params     map[string]string
+	Tablespace string
+	// Where is the predicate of partial index.
+	Where string
+	// Concurrently builds the index without locking out writes to the table.
+	// It's how the index is created rather than a property of it, so it's
+	// neither compared nor dumped.
+	Concurrently bool
+}
+
+// IndexColumn is an element of index key.
+type IndexColumn struct {
+	// Expr is the column name or the expression (with its parens).
+	Expr      string
+	Collation string
+	Opclass   string
+	Desc      bool
+	// Nulls is FIRST, LAST or empty (default).
+	Nulls string
+}
+
+func (c *IndexColumn) ToSQLString() string {
+	s := c.Expr
+	if c.Collation != "" {
+		s += collateClause(c.Collation)
+	}
+	if c.Opclass != "" {
+		s += " " + c.Opclass
+	}
+	if c.Desc {
+		s += " DESC"
+	}
+	if c.Nulls != "" {
+		s += " NULLS " + c.Nulls
+	}
+	return s
+}
+
+func (i *IndexDef) ToSQLString() string {
+	var b strings.Builder
+	b.WriteString("CREATE ")
+	if i.Unique {
+		b.WriteString("UNIQUE ")
+	}
+	b.WriteString("INDEX ")
+	if i.Concurrently {
+		b.WriteString("CONCURRENTLY ")
+	}
+	fmt.Fprintf(&b, "%s ON %s", i.Name.ToSQLString(), i.Table.ToSQLString())
+	if i.Method != "" && !strings.EqualFold(i.Method, "btree") {
+		fmt.Fprintf(&b, " USING %s", i.Method)
+	}
+
+	var columns []string
+	for _, c := range i.Columns {
+		columns = append(columns, c.ToSQLString())
+	}
+	fmt.Fprintf(&b, " (%s)", strings.Join(columns, ", "))
+
+	if len(i.Include) > 0 {
+		fmt.Fprintf(&b, " INCLUDE (%s)", strings.Join(i.Include, ", "))
+	}
+	if i.NullsNotDistinct {
+		b.WriteString(" NULLS NOT DISTINCT")
+	}
+	if len(i.Params) > 0 {
+		fmt.Fprintf(&b, " WITH (%s)", storageParamsSQL(i.Params))
+	}
+	if i.Tablespace != "" {
+		fmt.Fprintf(&b, " TABLESPACE %s", quoteIdent(i.Tablespace))
+	}
+	if i.Where != "" {
+		fmt.Fprintf(&b, " WHERE %s", i.Where)
+	}
+
+	return b.String()
+}
+
+func (i *IndexDef) method() string {
+	if i.Method == "" {
+		return "btree"
+	}
+	return strings.ToLower(i.Method)
+}
+
+// equal reports whether both indexes have the same definition.
+// Names and storage properties, which can be altered in place, are not compared.
+func (i *IndexDef) equal(o *IndexDef) bool {
+	if i.Unique != o.Unique || i.NullsNotDistinct != o.NullsNotDistinct {
+		return false
+	}
+	if i.method() != o.method() || i.Table != o.Table {
+		return false
+	}
+	if len(i.Columns) != len(o.Columns) || len(i.Include) != len(o.Include) {
+		return false
+	}
+	for idx, c := range i.Columns {
+		if c.canonical() != o.Columns[idx].canonical() {
+			return false
+		}
+	}
+	for idx, c := range i.Include {
+		if normalizeIndexExpr(c) != normalizeIndexExpr(o.Include[idx]) {
+			return false
+		}
+	}
+
+	return normalizeIndexExpr(i.Where) == normalizeIndexExpr(o.Where)
+}
+
+func (c *IndexColumn) canonical() string {
+	nulls := strings.ToUpper(c.Nulls)
+	// NULLS LAST is the default of ASC, and NULLS FIRST is the one of DESC.
+	if (!c.Desc && nulls == "LAST") || (c.Desc && nulls == "FIRST") {
+		nulls = ""
+	}
+	return strings.Join([]string{
+		normalizeIndexExpr(c.Expr),
+		collationName(c.Collation),
+		strings.ToLower(c.Opclass),
+		fmt.Sprint(c.Desc),
+		nulls,
+	}, "|")
+}
+
+var (
+	castPattern       = regexp.MustCompile(`::(?:"[^"]+"|character varying|double precision|bit varying|(?:timestamp|time) with(?:out)? time zone|[a-z_][a-z0-9_]*)(?:\(\d+(?:,\d+)?\))?(?:\[\])?`)
+	parenIdentPattern = regexp.MustCompile(`\(("[^"]+"|[a-z_][a-z0-9_.]*)\)`)
+)
+
+// normalizeIndexExpr returns comparable form of index expression or predicate.
+// pg_get_indexdef adds casts and parens to the expressions written by users,
+// e.g. lower(email) is reported as lower((email)::text), so they are removed.
+func normalizeIndexExpr(s string) string {
+	s = normalizeSQL(s)
+	s = castPattern.ReplaceAllString(s, "")
+	for {
+		r := stripIdentParens(s)
+		if r == s {
+			break
+		}
+		s = r
+	}
+	for strings.HasPrefix(s, "(") && closingParen(s, 0) == len(s)-1 {
+		s = s[1 : len(s)-1]
+	}
+	return s
+}
+
+// stripIdentParens turns "((email))" into "(email)" but leaves function
+// calls like "lower(email)" as is.
+func stripIdentParens(s string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range parenIdentPattern.FindAllStringSubmatchIndex(s, -1) {
+		if m[0] > 0 && isWordByte(s[m[0]-1]) {
+			continue
+		}
+		b.WriteString(s[last:m[0]])
+		b.WriteString(s[m[2]:m[3]])
+		last = m[1]
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+var createIndexPattern = regexp.MustCompile(`(?is)^\s*create\s+(unique\s+)?index\s+(concurrently\s+)?(?:if\s+not\s+exists\s+)?("(?:[^"]|"")+"|[\w.]+)\s+on\s+(?:only\s+)?("(?:[^"]|"")+"|[\w.]+)\s*(?:using\s+(\w+)\s*)?\(`)
+
+var createIndexHead = regexp.MustCompile(`(?is)^\s*create\s+(unique\s+)?index\s`)
+
+// isCreateIndex reports whether stmt is CREATE INDEX statement.
+func isCreateIndex(stmt string) bool {
+	return createIndexHead.MatchString(stmt)
+}
+
+// parseIndexDef parses CREATE INDEX statement written in schema file or
+// reported by pg_indexes.indexdef.
+func parseIndexDef(src string) (*IndexDef, error) {
+	m := createIndexPattern.FindStringSubmatchIndex(src)
+	if m == nil {
+		return nil, errors.Errorf("unsupported index definition: %s", src)
+	}
+
+	def := &IndexDef{
+		Unique:       m[2] >= 0,
+		Concurrently: m[4] >= 0,
+		Name:         ParseIdentifier(src[m[6]:m[7]]),
+		Table:        ParseIdentifier(src[m[8]:m[9]]),
+	}
+	if m[10] >= 0 {
+		def.Method = strings.ToLower(src[m[10]:m[11]])
+	}
+
+	open := m[1] - 1
+	end := closingParen(src, open)
+	if end < 0 {
+		return nil, errors.Errorf("unbalanced parens: %s", src)
+	}
+
+	for _, e := range splitTopLevel(src[open+1:end], ',') {
+		c, err := parseIndexColumn(e)
+		if err != nil {
+			return nil, err
+		}
+		def.Columns = append(def.Columns, c)
+	}
+
+	if err := parseIndexTail(def, strings.TrimSpace(src[end+1:])); err != nil {
+		return nil, err
+	}
+
+	return def, nil
+}
+
+var (
+	includePattern          = regexp.MustCompile(`(?is)^include\s*\(`)
+	nullsNotDistinctPattern = regexp.MustCompile(`(?is)^nulls\s+(not\s+)?distinct\b`)
+	wherePattern            = regexp.MustCompile(`(?is)^where\s+`)
+)
+
+func parseIndexTail(def *IndexDef, tail string) error {
+	for tail != "" {
+		switch {
+		case includePattern.MatchString(tail):
+			open := indexTopLevel(tail, '(')
+			end := closingParen(tail, open)
+			if end < 0 {
+				return errors.Errorf("unbalanced parens: %s", tail)
+			}
+			def.Include = splitTopLevel(tail[open+1:end], ',')
+			tail = strings.TrimSpace(tail[end+1:])
+		case nullsNotDistinctPattern.MatchString(tail):
+			m := nullsNotDistinctPattern.FindStringSubmatch(tail)
+			def.NullsNotDistinct = m[1] != ""
+			tail = strings.TrimSpace(tail[len(m[0]):])
+		case storageWithPattern.MatchString(tail):
+			open := indexTopLevel(tail, '(')
+			end := closingParen(tail, open)
+			if end < 0 {
+				return errors.Errorf("unbalanced parens: %s", tail)
+			}
+			params, err := parseStorageParams(splitTopLevel(tail[open+1:end], ','))
+			if err != nil {
+				return err
+			}
+			def.Params = params
+			tail = strings.TrimSpace(tail[end+1:])
+		case storageTablespacePattern.MatchString(tail):
+			m := storageTablespacePattern.FindStringSubmatch(tail)
+			def.Tablespace = string(ParseIdentifier(m[1]))
+			tail = strings.TrimSpace(tail[len(m[0]):])
+		case wherePattern.MatchString(tail):
+			def.Where = strings.TrimSpace(tail[len(wherePattern.FindString(tail)):])
+			tail = ""
+		default:
+			return errors.Errorf("unsupported index option: %s", tail)
+		}
+	}
+
+	return nil
+}
+
+func parseIndexColumn(src string) (*IndexColumn, error) {
+	src = strings.TrimSpace(src)
+
+	// column name, (expression) or function call
+	var end int
+	switch {
+	case strings.HasPrefix(src, "("):
+		end = closingParen(src, 0) + 1
+	case strings.HasPrefix(src, `"`):
+		end = skipQuoted(src, 0)
+	default:
+		end = len(src)
+		for i := 0; i < len(src); i++ {
+			if isSpaceByte(src[i]) {
+				end = i
+				break
+			}
+			if src[i] == '(' {
+				end = closingParen(src, i) + 1
+				break
+			}
+		}
+	}
+	if end <= 0 {
+		return nil, errors.Errorf("malformed index column: %s", src)
+	}
+
+	col := &IndexColumn{Expr: src[:end]}
+
+	tokens := splitWords(src[end:])
+	for i := 0; i < len(tokens); i++ {
+		switch strings.ToLower(tokens[i]) {
+		case "collate":
+			if i+1 >= len(tokens) {
+				return nil, errors.Errorf("malformed index column: %s", src)
+			}
+			col.Collation = collationName(tokens[i+1])
+			i++
+		case "asc":
+		case "desc":
+			col.Desc = true
+		case "nulls":
+			if i+1 >= len(tokens) {
+				return nil, errors.Errorf("malformed index column: %s", src)
+			}
+			col.Nulls = strings.ToUpper(tokens[i+1])
+			i++
+		default:
+			if col.Opclass != "" {
+				return nil, errors.Errorf("malformed index column: %s", src)
+			}
+			col.Opclass = tokens[i]
+		}
+	}
+
+	return col, nil
+}
+
+// splitWords splits s on whitespace which is not quoted nor parenthesized.
+func splitWords(s string) []string {
+	var words []string
+	start := -1
+	depth := 0
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case isSpaceByte(c) && depth == 0:
+			if start >= 0 {
+				words = append(words, s[start:i])
+				start = -1
+			}
+			i++
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+		i = skipQuoted(s, i)
+	}
+	if start >= 0 {
+		words = append(words, s[start:])
+	}
+
+	return words
+}
+
+type AddIndexSpec struct {
+	Def *IndexDef
+}
+
+func (a *AddIndexSpec) ToSQLString() string {
+	return a.Def.ToSQLString()
+}
+
+// computeIndexDiff compares indexes by name.
+// An index whose definition is changed is dropped and created again, while
+// storage parameters and tablespace are altered in place.
+func computeIndexDiff(current, target map[string]*IndexDef, defaultTablespace string) []*SchemaDiff {
+	var diffs []*SchemaDiff
+	for n, i := range current {
+		t, ok := target[n]
+		if !ok || !t.equal(i) {
+			diffs = append(diffs, &SchemaDiff{
+				Type: RemoveIndex,
+				Spec: &DropIndexSpec{
+					IndexName: i.Name,
+				},
+			})
+		}
+	}
+
+	for n, i := range target {
+		c, ok := current[n]
+		if !ok || !c.equal(i) {
+			diffs = append(diffs, &SchemaDiff{
+				Type: AddIndex,
+				Spec: &AddIndexSpec{
+					Def: i,
+				},
+			})
+			continue
+		}
+		diffs = append(diffs, computeIndexStorageDiff(i, c, defaultTablespace)...)
+	}
+
+	return diffs
+}
@@ -0,0 +1,191 @@
+package xmigrate
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseIndexDef(t *testing.T) {
+	cases := []struct {
+		name   string
+		src    string
+		expect *IndexDef
+	}{
+		{
+			name: "pg_indexes",
+			src:  "CREATE INDEX cat_name_idx ON public.item USING btree (category_id, name)",
+			expect: &IndexDef{
+				Name:    "cat_name_idx",
+				Table:   "item",
+				Method:  "btree",
+				Columns: []*IndexColumn{{Expr: "category_id"}, {Expr: "name"}},
+			},
+		},
+		{
+			name: "expression and predicate",
+			src:  "create unique index lower_email_idx on account (lower(email)) where deleted_at is null",
+			expect: &IndexDef{
+				Name:    "lower_email_idx",
+				Table:   "account",
+				Unique:  true,
+				Columns: []*IndexColumn{{Expr: "lower(email)"}},
+				Where:   "deleted_at is null",
+			},
+		},
+		{
+			name: "options",
+			src:  `create index name_idx on account using btree (name collate "C" text_pattern_ops desc nulls last, (id + 1)) include (email, age) nulls not distinct`,
+			expect: &IndexDef{
+				Name:   "name_idx",
+				Table:  "account",
+				Method: "btree",
+				Columns: []*IndexColumn{
+					{Expr: "name", Collation: "C", Opclass: "text_pattern_ops", Desc: true, Nulls: "LAST"},
+					{Expr: "(id + 1)"},
+				},
+				Include:          []string{"email", "age"},
+				NullsNotDistinct: true,
+			},
+		},
+		{
+			name: "concurrently",
+			src:  "create index concurrently if not exists name_idx on account using hash (name)",
+			expect: &IndexDef{
+				Name:         "name_idx",
+				Table:        "account",
+				Method:       "hash",
+				Columns:      []*IndexColumn{{Expr: "name"}},
+				Concurrently: true,
+			},
+		},
+		{
+			name: "gin",
+			src:  "CREATE INDEX tags_idx ON public.item USING gin (tags jsonb_path_ops)",
+			expect: &IndexDef{
+				Name:    "tags_idx",
+				Table:   "item",
+				Method:  "gin",
+				Columns: []*IndexColumn{{Expr: "tags", Opclass: "jsonb_path_ops"}},
+			},
+		},
+		{
+			name: "storage",
+			src:  "create index name_idx on account (name) with (fillfactor = '70') tablespace fast_ssd where name is not null",
+			expect: &IndexDef{
+				Name:       "name_idx",
+				Table:      "account",
+				Columns:    []*IndexColumn{{Expr: "name"}},
+				Params:     map[string]string{"fillfactor": "70"},
+				Tablespace: "fast_ssd",
+				Where:      "name is not null",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			def, err := parseIndexDef(c.src)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if diff := cmp.Diff(def, c.expect); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestIndexDef_Equal(t *testing.T) {
+	cases := []struct {
+		name   string
+		schema string
+		dumped string
+		expect bool
+	}{
+		{
+			name:   "same",
+			schema: "create index name_idx on account (name)",
+			dumped: "CREATE INDEX name_idx ON public.account USING btree (name)",
+			expect: true,
+		},
+		{
+			name:   "expression with casts",
+			schema: "create index lower_email_idx on account (lower(email)) where deleted_at is null",
+			dumped: "CREATE INDEX lower_email_idx ON public.account USING btree (lower((email)::text)) WHERE (deleted_at IS NULL)",
+			expect: true,
+		},
+		{
+			name:   "default sort order",
+			schema: "create index name_idx on account (name asc nulls last)",
+			dumped: "CREATE INDEX name_idx ON public.account USING btree (name)",
+			expect: true,
+		},
+		{
+			name:   "method",
+			schema: "create index name_idx on account using hash (name)",
+			dumped: "CREATE INDEX name_idx ON public.account USING btree (name)",
+			expect: false,
+		},
+		{
+			name:   "predicate",
+			schema: "create index name_idx on account (name) where age > 20",
+			dumped: "CREATE INDEX name_idx ON public.account USING btree (name) WHERE (age > 18)",
+			expect: false,
+		},
+		{
+			name:   "include",
+			schema: "create index name_idx on account (name) include (email)",
+			dumped: "CREATE INDEX name_idx ON public.account USING btree (name)",
+			expect: false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			a, err := parseIndexDef(c.schema)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			b, err := parseIndexDef(c.dumped)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if a.equal(b) != c.expect {
+				t.Errorf("equal should be %v", c.expect)
+			}
+		})
+	}
+}
+
+func TestComputeIndexDiff(t *testing.T) {
+	mustParse := func(src string) *IndexDef {
+		def, err := parseIndexDef(src)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		return def
+	}
+
+	current := map[string]*IndexDef{
+		"name_idx": mustParse("CREATE INDEX name_idx ON public.account USING btree (name)"),
+		"old_idx":  mustParse("CREATE INDEX old_idx ON public.account USING btree (age)"),
+	}
+	target := map[string]*IndexDef{
+		"name_idx": mustParse("create index name_idx on account (name) where name is not null"),
+	}
+
+	diffs := computeIndexDiff(current, target, "")
+
+	var act []string
+	for _, d := range diffs {
+		act = append(act, d.Spec.ToSQLString())
+	}
+
+	if len(diffs) != 3 {
+		t.Fatalf("must be 3 diffs but %v", act)
+	}
+	if diffs[2].Type != AddIndex || act[2] != "CREATE INDEX name_idx ON account (name) WHERE name is not null" {
+		t.Errorf("unexpected diff %s", act[2])
+	}
+}
@@ -13,7 +13,8 @@ func Inverse(diff *SchemaDiff, currentTable []*TableDef) (*SchemaDiff, error) {
 		return &SchemaDiff{
 			Type: DropTable,
 			Spec: &DropTableSpec{
-				TableName: spec.SQL.Name.ToSQLString(),
+				TableName:  ParseIdentifier(spec.SQL.Name.ToSQLString()),
+				Collations: spec.Collations,
 			},
 		}, nil
 	case *AddColumnSpec:
@@ -21,26 +22,35 @@ func Inverse(diff *SchemaDiff, currentTable []*TableDef) (*SchemaDiff, error) {
 			Type: DropColumn,
 			Spec: &DropColumnSpec{
 				TableName:  spec.TableName,
-				ColumnName: spec.ColumnDef.Name.ToSQLString(),
+				ColumnName: ParseIdentifier(spec.ColumnDef.Name.ToSQLString()),
+				Collation:  spec.Collation,
 			},
 		}, nil
 	case *DropColumnSpec:
 		t := getTable(spec.TableName, currentTable)
-		col := t.Columns[spec.ColumnName]
+		col := t.Columns[string(spec.ColumnName)]
 
 		return &SchemaDiff{
 			Type: AddColumn,
 			Spec: &AddColumnSpec{
 				TableName: spec.TableName,
 				ColumnDef: refineColumn(col),
+				Collation: t.ColumnCollations[string(spec.ColumnName)],
 			},
 		}, nil
 
 	case *DropTableSpec:
 		t := getTable(spec.TableName, currentTable)
+		if t.PartitionOf != nil {
+			return &SchemaDiff{
+				Type: AddPartition,
+				Spec: &AddPartitionSpec{Def: t.PartitionOf},
+			}, nil
+		}
 		var columndef []sqlast.TableElement
+		storage := t.Storage
 
-		for _, c := range t.Columns {
+		for _, c := range t.OrderedColumns() {
 			columndef = append(columndef, c)
 		}
 
@@ -48,12 +58,134 @@ func Inverse(diff *SchemaDiff, currentTable []*TableDef) (*SchemaDiff, error) {
 			Type: AddTable,
 			Spec: &AddTableSpec{
 				SQL: &sqlast.CreateTableStmt{
-					Name:     sqlast.NewSQLObjectName(spec.TableName),
+					Name:     sqlast.NewSQLObjectName(spec.TableName.ToSQLString()),
 					Elements: columndef,
 				},
+				PartitionKey: t.PartitionKey,
+				Collations:   t.ColumnCollations,
+				Storage:      &storage,
+			},
+		}, nil
+
+	case *AddPartitionSpec:
+		return &SchemaDiff{
+			Type: DropTable,
+			Spec: &DropTableSpec{
+				TableName: spec.Def.Name,
 			},
 		}, nil
 
+	case *AttachPartitionSpec:
+		return &SchemaDiff{
+			Type: DetachPartition,
+			Spec: &DetachPartitionSpec{
+				TableName: spec.Def.Name,
+				Parent:    spec.Def.Parent,
+			},
+		}, nil
+
+	case *CreateCollationSpec:
+		return &SchemaDiff{
+			Type: DropCollation,
+			Spec: &DropCollationSpec{Name: spec.Def.Name},
+		}, nil
+
+	case *AddExclusionSpec:
+		return &SchemaDiff{
+			Type: DropTableConstraint,
+			Spec: &DropTableConstraintSpec{
+				TableName:       spec.TableName,
+				ConstraintsName: spec.Def.Name,
+			},
+		}, nil
+
+	case *DropTableConstraintSpec:
+		t := getTable(spec.TableName, currentTable)
+		if t != nil {
+			for _, e := range t.Exclusions {
+				if e.Name == spec.ConstraintsName {
+					return &SchemaDiff{
+						Type: AddExclusion,
+						Spec: &AddExclusionSpec{TableName: spec.TableName, Def: e},
+					}, nil
+				}
+			}
+		}
+		return nil, errors.Errorf("unknown spec %s", spec.ToSQLString())
+
+	case *SetLoggedSpec:
+		return &SchemaDiff{
+			Type: AlterStorage,
+			Spec: &SetLoggedSpec{TableName: spec.TableName, Unlogged: !spec.Unlogged},
+		}, nil
+
+	case *SetTablespaceSpec:
+		// the tablespace is resolved by Diff, unless the default tablespace is unknown
+		tablespace := spec.PrevTablespace
+		if tablespace == "" {
+			if spec.ObjectType == "INDEX" {
+				i := getIndex(spec.Name, currentTable)
+				if i == nil {
+					return nil, errors.Errorf("index %s is not found", spec.Name)
+				}
+				tablespace = i.Tablespace
+			} else {
+				t := getTable(spec.Name, currentTable)
+				if t == nil {
+					return nil, errors.Errorf("table %s is not found", spec.Name)
+				}
+				tablespace = t.Storage.Tablespace
+			}
+		}
+		if tablespace == "" {
+			return nil, errors.Errorf("%s is in the default tablespace, which is unknown", spec.Name)
+		}
+		return &SchemaDiff{
+			Type: AlterStorage,
+			Spec: &SetTablespaceSpec{ObjectType: spec.ObjectType, Name: spec.Name, Tablespace: tablespace, PrevTablespace: spec.Tablespace},
+		}, nil
+
+	case *StorageParamsSpec:
+		var params map[string]string
+		if spec.ObjectType == "INDEX" {
+			i := getIndex(spec.Name, currentTable)
+			if i == nil {
+				return nil, errors.Errorf("index %s is not found", spec.Name)
+			}
+			params = i.Params
+		} else {
+			t := getTable(spec.Name, currentTable)
+			if t == nil {
+				return nil, errors.Errorf("table %s is not found", spec.Name)
+			}
+			params = t.Storage.Params
+		}
+		inv := &StorageParamsSpec{ObjectType: spec.ObjectType, Name: spec.Name}
+		for _, k := range append(sortedKeys(spec.Set), spec.Reset...) {
+			if v, ok := params[k]; ok {
+				if inv.Set == nil {
+					inv.Set = make(map[string]string)
+				}
+				inv.Set[k] = v
+			} else {
+				inv.Reset = append(inv.Reset, k)
+			}
+		}
+		if spec.ObjectType == "INDEX" && len(inv.Set) > 0 && len(inv.Reset) > 0 {
+			return nil, errors.Errorf("%s can't be inverted in a statement", spec.ToSQLString())
+		}
+		return &SchemaDiff{Type: AlterStorage, Spec: inv}, nil
+
+	case *DetachPartitionSpec:
+		t := getTable(spec.TableName, currentTable)
+		if t == nil || t.PartitionOf == nil {
+			return nil, errors.Errorf("%s is not a partition", spec.TableName)
+		}
+		return &SchemaDiff{
+			Type: AttachPartition,
+			Spec: &AttachPartitionSpec{Def: t.PartitionOf},
+		}, nil
+
 	case *EditColumnSpec:
 		switch spec.Type {
 		case SetNotNull:
@@ -64,9 +196,9 @@ func Inverse(diff *SchemaDiff, currentTable []*TableDef) (*SchemaDiff, error) {
 					TableName:  spec.TableName,
 					ColumnName: spec.ColumnName,
 					SQL: &sqlast.AlterTableStmt{
-						TableName: sqlast.NewSQLObjectName(spec.SQL.TableName.ToSQLString()),
+						TableName: sqlast.NewSQLObjectName(spec.TableName.ToSQLString()),
 						Action: &sqlast.AlterColumnTableAction{
-							ColumnName: sqlast.NewIdent(spec.ColumnName),
+							ColumnName: sqlast.NewIdent(spec.ColumnName.ToSQLString()),
 							Action:     &sqlast.PGDropNotNullColumnAction{},
 						},
 					},
@@ -80,9 +212,9 @@ func Inverse(diff *SchemaDiff, currentTable []*TableDef) (*SchemaDiff, error) {
 					TableName:  spec.TableName,
 					ColumnName: spec.ColumnName,
 					SQL: &sqlast.AlterTableStmt{
-						TableName: sqlast.NewSQLObjectName(spec.SQL.TableName.ToSQLString()),
+						TableName: sqlast.NewSQLObjectName(spec.TableName.ToSQLString()),
 						Action: &sqlast.AlterColumnTableAction{
-							ColumnName: sqlast.NewIdent(spec.ColumnName),
+							ColumnName: sqlast.NewIdent(spec.ColumnName.ToSQLString()),
 							Action:     &sqlast.PGSetNotNullColumnAction{},
 						},
 					},
@@ -91,7 +223,7 @@ func Inverse(diff *SchemaDiff, currentTable []*TableDef) (*SchemaDiff, error) {
 
 		case DropDefault:
 			t := getTable(spec.TableName, currentTable)
-			col := t.Columns[spec.ColumnName]
+			col := t.Columns[string(spec.ColumnName)]
 			return &SchemaDiff{
 				Type: EditColumn,
 				Spec: &EditColumnSpec{
@@ -101,7 +233,7 @@ func Inverse(diff *SchemaDiff, currentTable []*TableDef) (*SchemaDiff, error) {
 					SQL: &sqlast.AlterTableStmt{
 						TableName: spec.SQL.TableName,
 						Action: &sqlast.AlterColumnTableAction{
-							ColumnName: sqlast.NewIdent(spec.ColumnName),
+							ColumnName: sqlast.NewIdent(spec.ColumnName.ToSQLString()),
 							Action: &sqlast.SetDefaultColumnAction{
 								Default: col.Default,
 							},
@@ -119,7 +251,7 @@ func Inverse(diff *SchemaDiff, currentTable []*TableDef) (*SchemaDiff, error) {
 					SQL: &sqlast.AlterTableStmt{
 						TableName: spec.SQL.TableName,
 						Action: &sqlast.AlterColumnTableAction{
-							ColumnName: sqlast.NewIdent(spec.ColumnName),
+							ColumnName: sqlast.NewIdent(spec.ColumnName.ToSQLString()),
 							Action:     &sqlast.DropDefaultColumnAction{},
 						},
 					},
@@ -127,7 +259,17 @@ func Inverse(diff *SchemaDiff, currentTable []*TableDef) (*SchemaDiff, error) {
 			}, nil
 		case EditType:
 			t := getTable(spec.TableName, currentTable)
-			col := t.Columns[spec.ColumnName]
+			col := t.Columns[string(spec.ColumnName)]
+			var collation, prev string
+			if spec.Collation != "" {
+				collation = t.ColumnCollations[string(spec.ColumnName)]
+				if collation == "" {
+					collation = "default"
+				}
+			}
+			if spec.Collation != "default" {
+				prev = spec.Collation
+			}
 			return &SchemaDiff{
 				Type: EditColumn,
 				Spec: &EditColumnSpec{
@@ -135,14 +277,16 @@ func Inverse(diff *SchemaDiff, currentTable []*TableDef) (*SchemaDiff, error) {
 					TableName:  spec.TableName,
 					ColumnName: spec.ColumnName,
 					SQL: &sqlast.AlterTableStmt{
-						TableName: sqlast.NewSQLObjectName(spec.SQL.TableName.ToSQLString()),
+						TableName: sqlast.NewSQLObjectName(spec.TableName.ToSQLString()),
 						Action: &sqlast.AlterColumnTableAction{
-							ColumnName: sqlast.NewIdent(spec.ColumnName),
+							ColumnName: sqlast.NewIdent(spec.ColumnName.ToSQLString()),
 							Action: &sqlast.PGAlterDataTypeColumnAction{
 								DataType: col.DataType,
 							},
 						},
 					},
+					Collation:     collation,
+					PrevCollation: prev,
 				},
 			}, nil
 		default:
@@ -154,7 +298,7 @@ func Inverse(diff *SchemaDiff, currentTable []*TableDef) (*SchemaDiff, error) {
 	}
 }
 
-func getTable(tableName string, ts []*TableDef) *TableDef {
+func getTable(tableName Identifier, ts []*TableDef) *TableDef {
 	for _, t := range ts {
 		if t.Name == tableName {
 			return t
@@ -163,9 +307,18 @@ func getTable(tableName string, ts []*TableDef) *TableDef {
 	return nil
 }
 
+func getIndex(indexName Identifier, ts []*TableDef) *IndexDef {
+	for _, t := range ts {
+		if i, ok := t.Indexes[string(indexName)]; ok {
+			return i
+		}
+	}
+	return nil
+}
+
 func refineColumn(org *sqlast.ColumnDef) *sqlast.ColumnDef {
 	// convert into serial
-	_, ok := org.DataType.(*sqlast.Int)
+	serial, ok := serialTypes[CanonicalType(org.DataType)]
 	if !ok {
 		return org
 	}
@@ -178,7 +331,7 @@ func refineColumn(org *sqlast.ColumnDef) *sqlast.ColumnDef {
 	if strings.HasPrefix(fn.Name.ToSQLString(), "nextval") {
 		return &sqlast.ColumnDef{
 			Name:        org.Name,
-			DataType:    &sqlast.Custom{Ty: sqlast.NewSQLObjectName("SERIAL")},
+			DataType:    &sqlast.Custom{Ty: sqlast.NewSQLObjectName(strings.ToUpper(serial))},
 			Constraints: org.Constraints,
 		}
 	}
@@ -0,0 +1,128 @@
+package xmigrate
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+	errors "golang.org/x/xerrors"
+)
+
+// WithParallelism makes Dump split tables into n chunks, each of which is
+// read by a worker with the batched queries on its own connection of the
+// pool, which helps on high-latency links.
+// The workers share the snapshot exported by pg_export_snapshot, so the
+// result is consistent as if it was read by a single transaction.
+// The number of workers is bounded by SetMaxOpenConns of the pool minus one.
+func WithParallelism(n int) DumpOption {
+	return func(p *PGDump) {
+		p.parallelism = n
+	}
+}
+
+// dumpParallel exports the snapshot of tx, which must be kept open until every
+// worker imports it.
+func (p *PGDump) dumpParallel(ctx context.Context, tx *sqlx.Tx, version ServerVersion) ([]*TableDef, error) {
+	var snapshot string
+	if err := tx.GetContext(ctx, &snapshot, "select pg_export_snapshot()"); err != nil {
+		return nil, errors.Errorf("pg_export_snapshot failed: %w", err)
+	}
+
+	all, err := getTables(ctx, tx, version)
+	if err != nil {
+		return nil, errors.Errorf("getTables failed: %w", err)
+	}
+	var tables []*pgTable
+	for _, t := range all {
+		if p.filter.Managed(TableObject, t.Name) {
+			tables = append(tables, t)
+		}
+	}
+
+	workers := p.parallelism
+	if max := p.db.Stats().MaxOpenConnections; max > 0 && workers > max-1 {
+		workers = max - 1
+	}
+	if workers > len(tables) {
+		workers = len(tables)
+	}
+	if workers < 1 {
+		// every connection of the pool is taken by the exporting transaction
+		// (or there is nothing to dump), so the tables are read by it.
+		return p.dumpTables(ctx, tx, version)
+	}
+
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// each worker reads a chunk of the tables with the batched queries
+	defs := make([]*TableDef, len(tables))
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		from, to := i*len(tables)/workers, (i+1)*len(tables)/workers
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := dumpWorker(wctx, p.db, snapshot, version, tables[from:to], defs[from:to]); err != nil {
+				errs <- err
+				// the rest of the workers stop with their queries canceled.
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Errorf("dump canceled: %w", err)
+	}
+	if err := <-errs; err != nil {
+		return nil, err
+	}
+
+	return p.filter.FilterTables(defs), nil
+}
+
+// dumpWorker introspects tables in a transaction which imports snapshot,
+// and stores the result in defs, which has the same length as tables.
+func dumpWorker(ctx context.Context, db *sqlx.DB, snapshot string, version ServerVersion, tables []*pgTable, defs []*TableDef) error {
+	tx, err := db.BeginTxx(ctx, readOnlyTxOptions)
+	if err != nil {
+		return errors.Errorf("begin failed: %w", err)
+	}
+	defer tx.Rollback()
+
+	// SET TRANSACTION doesn't take parameters. snapshot is the one exported by the server.
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("set transaction snapshot '%s'", snapshot)); err != nil {
+		return errors.Errorf("set transaction snapshot failed: %w", err)
+	}
+
+	names := make([]string, len(tables))
+	for i, t := range tables {
+		names[i] = t.Name
+	}
+	columns, err := getColumns(ctx, tx, version, names)
+	if err != nil {
+		return errors.Errorf("getColumns failed: %w", err)
+	}
+	constraints, err := getConstraints(ctx, tx, names)
+	if err != nil {
+		return errors.Errorf("getConstraints failed: %w", err)
+	}
+	indexes, err := getIndexes(ctx, tx, names)
+	if err != nil {
+		return errors.Errorf("getIndexes failed: %w", err)
+	}
+
+	for i, t := range tables {
+		def, err := buildTableDef(t, columns[t.Name], constraints[t.Name], indexes[t.Name])
+		if err != nil {
+			return errors.Errorf("buildTableDef failed with tableName %s: %w", t.Name, err)
+		}
+		defs[i] = def
+	}
+
+	return nil
+}
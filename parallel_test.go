@@ -0,0 +1,128 @@
+package xmigrate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPGDump_DumpParallel(t *testing.T) {
+	ctx := context.Background()
+	conf := &PGConf{
+		DBName:   "xmigrate_test",
+		DBHost:   "localhost",
+		DBPort:   "5432",
+		UserName: "postgres",
+	}
+
+	sequential, err := NewPGDump(ctx, conf)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer sequential.Close()
+	expected, err := sequential.Dump(ctx)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	for _, maxConns := range []int{0, 1, 3} {
+		parallel, err := NewPGDump(ctx, conf, WithParallelism(4))
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		defer parallel.Close()
+		parallel.db.SetMaxOpenConns(maxConns)
+
+		tables, err := parallel.Dump(ctx)
+		if err != nil {
+			t.Fatalf("max conns %d: %+v", maxConns, err)
+		}
+		if diff := cmp.Diff(tableSQLs(expected), tableSQLs(tables)); diff != "" {
+			t.Errorf("max conns %d: diff: %s", maxConns, diff)
+		}
+	}
+
+	t.Run("canceled", func(t *testing.T) {
+		parallel, err := NewPGDump(ctx, conf, WithParallelism(4))
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		defer parallel.Close()
+
+		cctx, cancel := context.WithCancel(ctx)
+		cancel()
+		if _, err := parallel.Dump(cctx); err == nil {
+			t.Errorf("must be error")
+		}
+	})
+}
+
+// BenchmarkPGDump_DumpParallel compares the workers, each of which reads a
+// chunk of the tables, with the serial dump.
+func BenchmarkPGDump_DumpParallel(b *testing.B) {
+	ctx := context.Background()
+	conf := &PGConf{
+		DBName:     "xmigrate_test",
+		DBHost:     "127.0.0.1",
+		DBPort:     "5432",
+		DBPassword: "passw0rd",
+		UserName:   "postgres",
+	}
+
+	serial, err := NewPGDump(ctx, conf)
+	if err != nil {
+		b.Fatalf("%+v", err)
+	}
+	defer serial.Close()
+	parallel, err := NewPGDump(ctx, conf, WithParallelism(4))
+	if err != nil {
+		b.Fatalf("%+v", err)
+	}
+	defer parallel.Close()
+
+	for _, n := range []int{10, 100, 500} {
+		b.Run(fmt.Sprintf("%d tables", n), func(b *testing.B) {
+			drop := createBenchTables(ctx, b, serial.db, n)
+			defer drop()
+
+			for _, c := range []struct {
+				name   string
+				dumper *PGDump
+			}{
+				{name: "serial", dumper: serial},
+				{name: "4 workers", dumper: parallel},
+			} {
+				b.Run(c.name, func(b *testing.B) {
+					for i := 0; i < b.N; i++ {
+						if _, err := c.dumper.Dump(ctx); err != nil {
+							b.Fatalf("%+v", err)
+						}
+					}
+				})
+			}
+		})
+	}
+}
+
+func tableSQLs(tables []*TableDef) []string {
+	var sqls []string
+	for _, t := range tables {
+		sqls = append(sqls, string(t.Name))
+		for _, c := range t.OrderedColumns() {
+			sqls = append(sqls, c.ToSQLString())
+		}
+		for _, c := range t.Constrains {
+			sqls = append(sqls, c.ToSQLString())
+		}
+		var indexes []string
+		for n := range t.Indexes {
+			indexes = append(indexes, n)
+		}
+		sort.Strings(indexes)
+		sqls = append(sqls, indexes...)
+	}
+	return sqls
+}
@@ -0,0 +1,349 @@
+package xmigrate
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	errors "golang.org/x/xerrors"
+)
+
+// PartitionKey is the PARTITION BY clause of a partitioned table.
+type PartitionKey struct {
+	// Strategy is one of RANGE, LIST or HASH.
+	Strategy string
+	// Expr is the column list (or expressions) inside the parens.
+	Expr string
+}
+
+func (k *PartitionKey) ToSQLString() string {
+	return fmt.Sprintf("PARTITION BY %s (%s)", k.Strategy, k.Expr)
+}
+
+func (k *PartitionKey) equal(o *PartitionKey) bool {
+	if k == nil || o == nil {
+		return k == o
+	}
+	return strings.EqualFold(k.Strategy, o.Strategy) && normalizeSQL(k.Expr) == normalizeSQL(o.Expr)
+}
+
+// PartitionDef describes a table created with PARTITION OF.
+type PartitionDef struct {
+	Name   Identifier
+	Parent Identifier
+	// Bound is either "FOR VALUES ..." or "DEFAULT".
+	Bound string
+	// Key is set when the partition itself is partitioned.
+	Key *PartitionKey
+}
+
+// sameBound reports whether p and o are bound to the same values of the parent.
+// types are the canonical types of the key columns of the parent, which are
+// used to compare literals written differently, e.g. '2019-01-01' and
+// '2019-01-01 00:00:00+00' of a timestamptz key which pg_get_expr returns.
+func (p *PartitionDef) sameBound(o *PartitionDef, types []string, loc *time.Location) bool {
+	if p.Parent != o.Parent {
+		return false
+	}
+	pb, ok := canonicalBound(p.Bound, types, loc)
+	if !ok {
+		return normalizeSQL(p.Bound) == normalizeSQL(o.Bound)
+	}
+	ob, ok := canonicalBound(o.Bound, types, loc)
+	if !ok {
+		return normalizeSQL(p.Bound) == normalizeSQL(o.Bound)
+	}
+	return pb == ob
+}
+
+// partitionKeyTypes returns the canonical types of the key columns of parent.
+// The type of an expression key is empty.
+func partitionKeyTypes(parent *TableDef) []string {
+	if parent == nil || parent.PartitionKey == nil {
+		return nil
+	}
+
+	var types []string
+	for _, expr := range splitTopLevel(parent.PartitionKey.Expr, ',') {
+		tp := ""
+		if col, ok := parent.Columns[string(ParseIdentifier(expr))]; ok {
+			tp = CanonicalType(col.DataType)
+		}
+		types = append(types, tp)
+	}
+	return types
+}
+
+// canonicalBound returns comparable form of "FOR VALUES ..." or "DEFAULT".
+// It returns false if bound can't be parsed.
+func canonicalBound(bound string, types []string, loc *time.Location) (string, bool) {
+	bound = strings.TrimSpace(bound)
+	if strings.EqualFold(bound, "DEFAULT") {
+		return "DEFAULT", true
+	}
+	m := forValuesPattern.FindString(bound)
+	if m == "" {
+		return "", false
+	}
+
+	var parts []string
+	rest := strings.TrimSpace(bound[len(m):])
+	for rest != "" {
+		open := strings.IndexByte(rest, '(')
+		if open < 0 {
+			return "", false
+		}
+		end := closingParen(rest, open)
+		if end < 0 {
+			return "", false
+		}
+		keyword := strings.ToUpper(strings.TrimSpace(rest[:open]))
+
+		values := splitTopLevel(rest[open+1:end], ',')
+		for i, v := range values {
+			tp := ""
+			switch keyword {
+			case "FROM", "TO":
+				if i < len(types) {
+					tp = types[i]
+				}
+			case "IN":
+				if len(types) == 1 {
+					tp = types[0]
+				}
+			}
+			values[i] = canonicalBoundValue(v, tp, loc)
+		}
+		parts = append(parts, keyword+" ("+strings.Join(values, ", ")+")")
+		rest = strings.TrimSpace(rest[end+1:])
+	}
+
+	return strings.Join(parts, " "), true
+}
+
+var timestampLayouts = []string{
+	"2006-01-02 15:04:05-07",
+	"2006-01-02 15:04:05-07:00",
+	"2006-01-02T15:04:05-07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// canonicalBoundValue returns comparable form of a literal in partition bound.
+// Casts are dropped and the literal is read as tp, which is the type of the key column.
+func canonicalBoundValue(v, tp string, loc *time.Location) string {
+	v = strings.TrimSpace(v)
+	if !strings.HasPrefix(v, "'") {
+		if i := strings.Index(v, "::"); i >= 0 {
+			v = v[:i]
+		}
+		return strings.ToUpper(normalizeSQL(v))
+	}
+
+	end := skipQuoted(v, 0)
+	s := unquoteLiteral(v[:end])
+
+	switch {
+	case tp == "date":
+		if t, err := time.Parse("2006-01-02", s); err == nil {
+			return quoteLiteral(t.Format("2006-01-02"))
+		}
+	case strings.HasPrefix(tp, "timestamp") && strings.Contains(tp, "with time zone"):
+		for _, layout := range timestampLayouts {
+			if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+				return quoteLiteral(t.UTC().Format(time.RFC3339Nano))
+			}
+		}
+	case strings.HasPrefix(tp, "timestamp"):
+		for _, layout := range timestampLayouts[3:] {
+			if t, err := time.Parse(layout, s); err == nil {
+				return quoteLiteral(t.Format("2006-01-02 15:04:05.999999999"))
+			}
+		}
+	case tp == "smallint", tp == "integer", tp == "bigint":
+		return strings.TrimSpace(s)
+	}
+
+	return quoteLiteral(s)
+}
+
+// WithTimeZone tells Diff the TimeZone setting of the database session,
+// which timestamptz literals without offset in partition bounds are read in.
+// Without it, they are read in UTC.
+func WithTimeZone(name string) DiffOption {
+	return func(c *diffConfig) {
+		c.timeZone = name
+	}
+}
+
+// location returns the location of name, or UTC if it's unknown to Go.
+func location(name string) *time.Location {
+	if name == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+var partitionKeyPattern = regexp.MustCompile(`(?is)^\s*(range|list|hash)\s*\((.*)\)\s*$`)
+
+// parsePartitionKey parses "RANGE (created_at)" which is the format of
+// both schema files and pg_get_partkeydef.
+func parsePartitionKey(src string) (*PartitionKey, error) {
+	m := partitionKeyPattern.FindStringSubmatch(src)
+	if m == nil {
+		return nil, errors.Errorf("malformed partition key: %s", src)
+	}
+
+	return &PartitionKey{
+		Strategy: strings.ToUpper(m[1]),
+		Expr:     strings.TrimSpace(m[2]),
+	}, nil
+}
+
+var partitionByPattern = regexp.MustCompile(`(?is)^partition\s+by\s+(.*)$`)
+
+// stripPartitionKey cuts PARTITION BY clause off from CREATE TABLE statement.
+func stripPartitionKey(stmt string) (string, *PartitionKey, error) {
+	head, tail, ok := splitCreateTableTail(stmt)
+	if !ok || tail == "" {
+		return stmt, nil, nil
+	}
+
+	m := partitionByPattern.FindStringSubmatch(tail)
+	if m == nil {
+		return stmt, nil, nil
+	}
+
+	key, err := parsePartitionKey(m[1])
+	if err != nil {
+		return "", nil, err
+	}
+
+	return head, key, nil
+}
+
+var partitionOfPattern = regexp.MustCompile(`(?is)^\s*create\s+table\s+(?:if\s+not\s+exists\s+)?(\S+)\s+partition\s+of\s+(\S+)\s+(.*)$`)
+
+// parsePartitionOf parses CREATE TABLE ... PARTITION OF statement.
+// It returns nil if stmt is not a partition.
+func parsePartitionOf(stmt string) (*PartitionDef, error) {
+	m := partitionOfPattern.FindStringSubmatch(stmt)
+	if m == nil {
+		return nil, nil
+	}
+
+	bound := strings.TrimSpace(m[3])
+	var key *PartitionKey
+	if idx := partitionByIndex(bound); idx >= 0 {
+		k, err := parsePartitionKey(bound[idx+len("partition by"):])
+		if err != nil {
+			return nil, err
+		}
+		key = k
+		bound = strings.TrimSpace(bound[:idx])
+	}
+
+	if !strings.EqualFold(bound, "DEFAULT") && !forValuesPattern.MatchString(bound) {
+		return nil, errors.Errorf("unsupported partition bound: %s", bound)
+	}
+
+	return &PartitionDef{
+		Name:   ParseIdentifier(m[1]),
+		Parent: ParseIdentifier(m[2]),
+		Bound:  bound,
+		Key:    key,
+	}, nil
+}
+
+var forValuesPattern = regexp.MustCompile(`(?is)^for\s+values\s`)
+
+func partitionByIndex(s string) int {
+	lower := strings.ToLower(s)
+	for i := 0; i < len(s); {
+		if strings.HasPrefix(lower[i:], "partition") {
+			if partitionByPattern.MatchString(s[i:]) {
+				return i
+			}
+		}
+		i = skipQuoted(s, i)
+	}
+	return -1
+}
+
+type AddPartitionSpec struct {
+	Def *PartitionDef
+}
+
+func (a *AddPartitionSpec) ToSQLString() string {
+	sql := fmt.Sprintf("CREATE TABLE %s PARTITION OF %s %s", a.Def.Name.ToSQLString(), a.Def.Parent.ToSQLString(), a.Def.Bound)
+	if a.Def.Key != nil {
+		sql += " " + a.Def.Key.ToSQLString()
+	}
+	return sql
+}
+
+type AttachPartitionSpec struct {
+	Def *PartitionDef
+}
+
+func (a *AttachPartitionSpec) ToSQLString() string {
+	return fmt.Sprintf("ALTER TABLE %s ATTACH PARTITION %s %s", a.Def.Parent.ToSQLString(), a.Def.Name.ToSQLString(), a.Def.Bound)
+}
+
+type DetachPartitionSpec struct {
+	TableName Identifier
+	Parent    Identifier
+}
+
+func (d *DetachPartitionSpec) ToSQLString() string {
+	return fmt.Sprintf("ALTER TABLE %s DETACH PARTITION %s", d.Parent.ToSQLString(), d.TableName.ToSQLString())
+}
+
+// computePartitionDiff compares partitions declared in schema with current tables.
+func computePartitionDiff(targ []*PartitionDef, currentState map[string]*TableDef, timeZone string) ([]*SchemaDiff, error) {
+	loc := location(timeZone)
+	var diffs []*SchemaDiff
+
+	for _, p := range targ {
+		c, ok := currentState[string(p.Name)]
+		if !ok {
+			diffs = append(diffs, &SchemaDiff{
+				Type: AddPartition,
+				Spec: &AddPartitionSpec{Def: p},
+			})
+			continue
+		}
+
+		if !c.PartitionKey.equal(p.Key) {
+			return nil, errors.Errorf("changing partition key of %s is not supported", p.Name)
+		}
+
+		if c.PartitionOf != nil {
+			types := partitionKeyTypes(currentState[string(p.Parent)])
+			if c.PartitionOf.sameBound(p, types, loc) {
+				continue
+			}
+			diffs = append(diffs, &SchemaDiff{
+				Type: DetachPartition,
+				Spec: &DetachPartitionSpec{
+					TableName: c.Name,
+					Parent:    c.PartitionOf.Parent,
+				},
+			})
+		}
+
+		diffs = append(diffs, &SchemaDiff{
+			Type: AttachPartition,
+			Spec: &AttachPartitionSpec{Def: p},
+		})
+	}
+
+	return diffs, nil
+}
@@ -0,0 +1,292 @@
+package xmigrate
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseSchema_Partition(t *testing.T) {
+	src := `create table events (id int, created_at timestamp) partition by range (created_at);
+create table events_2019 partition of events for values from ('2019-01-01') to ('2020-01-01');
+create table events_default partition of events default;
+create table events_2020 partition of events for values from ('2020-01-01') to ('2021-01-01') partition by list (id);`
+
+	targ, err := ParseSchema(bytes.NewBufferString(src))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if len(targ.TableDef) != 1 {
+		t.Fatalf("must be one create table statement but %d", len(targ.TableDef))
+	}
+
+	if diff := cmp.Diff(targ.PartitionKeys, map[string]*PartitionKey{
+		"events": {Strategy: "RANGE", Expr: "created_at"},
+	}); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+
+	expect := []*PartitionDef{
+		{Name: "events_2019", Parent: "events", Bound: "for values from ('2019-01-01') to ('2020-01-01')"},
+		{Name: "events_default", Parent: "events", Bound: "default"},
+		{
+			Name:   "events_2020",
+			Parent: "events",
+			Bound:  "for values from ('2020-01-01') to ('2021-01-01')",
+			Key:    &PartitionKey{Strategy: "LIST", Expr: "id"},
+		},
+	}
+	if diff := cmp.Diff(targ.Partitions, expect); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+}
+
+func TestDiff_Partition(t *testing.T) {
+	target := `create table events (id int, created_at timestamp) partition by range (created_at);
+create table events_2019 partition of events for values from ('2019-01-01') to ('2020-01-01');`
+
+	current := func(parts ...*PartitionDef) []*TableDef {
+		defs := genTableDef(t, "create table events (id int, created_at timestamp);")
+		defs[0].PartitionKey = &PartitionKey{Strategy: "RANGE", Expr: "created_at"}
+		for _, p := range parts {
+			part := genTableDef(t, "create table "+p.Name.ToSQLString()+" (id int, created_at timestamp);")[0]
+			part.PartitionOf = p
+			defs = append(defs, part)
+		}
+		return defs
+	}
+
+	cases := []struct {
+		name    string
+		current []*TableDef
+		opts    []DiffOption
+		expect  []*SchemaDiff
+	}{
+		{
+			name:    "add partition",
+			current: current(),
+			expect: []*SchemaDiff{
+				{
+					Type: AddPartition,
+					Spec: &AddPartitionSpec{
+						Def: &PartitionDef{Name: "events_2019", Parent: "events", Bound: "for values from ('2019-01-01') to ('2020-01-01')"},
+					},
+				},
+			},
+		},
+		{
+			name: "same partition",
+			current: current(&PartitionDef{
+				Name:   "events_2019",
+				Parent: "events",
+				Bound:  "FOR VALUES FROM ('2019-01-01') TO ('2020-01-01')",
+			}),
+		},
+		{
+			name: "change bound",
+			current: current(&PartitionDef{
+				Name:   "events_2019",
+				Parent: "events",
+				Bound:  "FOR VALUES FROM ('2019-01-01') TO ('2019-07-01')",
+			}),
+			expect: []*SchemaDiff{
+				{
+					Type: DetachPartition,
+					Spec: &DetachPartitionSpec{TableName: "events_2019", Parent: "events"},
+				},
+				{
+					Type: AttachPartition,
+					Spec: &AttachPartitionSpec{
+						Def: &PartitionDef{Name: "events_2019", Parent: "events", Bound: "for values from ('2019-01-01') to ('2020-01-01')"},
+					},
+				},
+			},
+		},
+		{
+			name: "unmanaged partitions",
+			current: current(&PartitionDef{
+				Name:   "events_2018",
+				Parent: "events",
+				Bound:  "FOR VALUES FROM ('2018-01-01') TO ('2019-01-01')",
+			}),
+			opts: []DiffOption{WithUnmanagedPartitions()},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			targ, err := ParseSchema(bytes.NewBufferString(target))
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+
+			diff, err := Diff(targ, c.current, c.opts...)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+
+			if d := cmp.Diff(diff, c.expect, IgnoreMarker); d != "" {
+				t.Errorf("Diff: %s", d)
+			}
+		})
+	}
+}
+
+func TestDiff_PartitionBound(t *testing.T) {
+	cases := []struct {
+		name    string
+		column  string
+		target  string
+		current string
+	}{
+		{
+			name:    "date",
+			column:  "created_on date",
+			target:  "for values from ('2019-01-01') to ('2020-01-01')",
+			current: "FOR VALUES FROM ('2019-01-01') TO ('2020-01-01')",
+		},
+		{
+			name:    "timestamptz",
+			column:  "created_on timestamptz",
+			target:  "for values from ('2019-01-01') to ('2020-01-01')",
+			current: "FOR VALUES FROM ('2019-01-01 00:00:00+00') TO ('2020-01-01 00:00:00+00')",
+		},
+		{
+			name:    "text",
+			column:  "created_on text",
+			target:  "for values in ('a', 'b')",
+			current: "FOR VALUES IN ('a', 'b')",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			strategy := "range"
+			if c.name == "text" {
+				strategy = "list"
+			}
+			target := "create table events (id int, " + c.column + ") partition by " + strategy + " (created_on);\n" +
+				"create table events_2019 partition of events " + c.target + ";"
+			targ, err := ParseSchema(bytes.NewBufferString(target))
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+
+			current := genTableDef(t, "create table events (id int, "+c.column+");")
+			current[0].PartitionKey = &PartitionKey{Strategy: strings.ToUpper(strategy), Expr: "created_on"}
+			part := genTableDef(t, "create table events_2019 (id int, "+c.column+");")[0]
+			part.PartitionOf = &PartitionDef{Name: "events_2019", Parent: "events", Bound: c.current}
+			current = append(current, part)
+
+			diff, err := Diff(targ, current)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if len(diff) != 0 {
+				t.Errorf("must be no diff but %d", len(diff))
+			}
+		})
+	}
+}
+
+func TestPartitionDef_sameBound(t *testing.T) {
+	tokyo := time.FixedZone("JST", 9*60*60)
+
+	cases := []struct {
+		name   string
+		types  []string
+		loc    *time.Location
+		targ   string
+		curr   string
+		expect bool
+	}{
+		{
+			name:   "date",
+			types:  []string{"date"},
+			targ:   "for values from ('2019-01-01'::date) to ('2020-01-01')",
+			curr:   "FOR VALUES FROM ('2019-01-01') TO ('2020-01-01')",
+			expect: true,
+		},
+		{
+			name:   "timestamptz",
+			types:  []string{"timestamp with time zone"},
+			targ:   "for values from ('2019-01-01') to ('2020-01-01')",
+			curr:   "FOR VALUES FROM ('2019-01-01 00:00:00+00') TO ('2020-01-01 00:00:00+00')",
+			expect: true,
+		},
+		{
+			name:   "timestamptz in time zone",
+			types:  []string{"timestamp with time zone"},
+			loc:    tokyo,
+			targ:   "for values from ('2019-01-01') to ('2020-01-01')",
+			curr:   "FOR VALUES FROM ('2019-01-01 00:00:00+09') TO ('2020-01-01 00:00:00+09')",
+			expect: true,
+		},
+		{
+			name:   "timestamptz in other time zone",
+			types:  []string{"timestamp with time zone"},
+			targ:   "for values from ('2019-01-01') to ('2020-01-01')",
+			curr:   "FOR VALUES FROM ('2019-01-01 00:00:00+09') TO ('2020-01-01 00:00:00+09')",
+			expect: false,
+		},
+		{
+			name:   "timestamp",
+			types:  []string{"timestamp without time zone"},
+			targ:   "for values from ('2019-01-01') to (MAXVALUE)",
+			curr:   "FOR VALUES FROM ('2019-01-01 00:00:00') TO (MAXVALUE)",
+			expect: true,
+		},
+		{
+			name:   "text",
+			types:  []string{"text"},
+			targ:   "for values in ('a', 'b')",
+			curr:   "FOR VALUES IN ('a', 'b')",
+			expect: true,
+		},
+		{
+			name:   "text differs",
+			types:  []string{"text"},
+			targ:   "for values in ('a', 'b')",
+			curr:   "FOR VALUES IN ('A', 'b')",
+			expect: false,
+		},
+		{
+			name:   "integer",
+			types:  []string{"integer"},
+			targ:   "for values from ('1') to (10)",
+			curr:   "FOR VALUES FROM (1) TO (10)",
+			expect: true,
+		},
+		{
+			name:   "hash",
+			targ:   "for values with (modulus 4, remainder 1)",
+			curr:   "FOR VALUES WITH (modulus 4, remainder 1)",
+			expect: true,
+		},
+		{
+			name:   "date differs",
+			types:  []string{"date"},
+			targ:   "for values from ('2019-01-01') to ('2020-01-01')",
+			curr:   "FOR VALUES FROM ('2019-01-01') TO ('2019-07-01')",
+			expect: false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			loc := c.loc
+			if loc == nil {
+				loc = time.UTC
+			}
+			targ := &PartitionDef{Name: "events_2019", Parent: "events", Bound: c.targ}
+			curr := &PartitionDef{Name: "events_2019", Parent: "events", Bound: c.curr}
+			if actual := curr.sameBound(targ, c.types, loc); actual != c.expect {
+				t.Errorf("expect %v but %v", c.expect, actual)
+			}
+		})
+	}
+}
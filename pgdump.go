@@ -1,555 +1,433 @@
 package xmigrate
 
 import (
-	"bytes"
 	"context"
 	"database/sql"
 	"fmt"
-	"log"
 	"sort"
 	"strings"
+	"sync"
 
-	"github.com/akito0107/xsqlparser"
-	"github.com/akito0107/xsqlparser/dialect"
 	"github.com/akito0107/xsqlparser/sqlast"
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
 	"github.com/xo/dburl"
 	errors "golang.org/x/xerrors"
 )
 
+// PGConf is the connection setting. Empty fields are taken from PG* environment
+// variables and the password file by NewPGDump, in the same way as libpq.
 type PGConf struct {
 	DBName     string `env:"DB_NAME"`
 	DBHost     string `env:"DB_HOST"`
 	DBPort     string `env:"DB_PORT"`
 	DBPassword string `env:"DB_PASSWORD,allow-empty"`
 	UserName   string `env:"DB_USER_NAME"`
-	SSLMode    bool   `env:"DB_SSL_MODE,allow-empty"`
+	// SSLMode is one of disable, allow, prefer (default), require, verify-ca and verify-full.
+	SSLMode     string `env:"DB_SSL_MODE"`
+	SSLRootCert string `env:"DB_SSL_ROOT_CERT"`
+	SSLCert     string `env:"DB_SSL_CERT"`
+	SSLKey      string `env:"DB_SSL_KEY"`
 }
 
+// ConnInfo returns the non empty fields as libpq keywords.
+func (p *PGConf) ConnInfo() ConnInfo {
+	c := make(ConnInfo)
+	for k, v := range map[string]string{
+		"dbname":      p.DBName,
+		"host":        p.DBHost,
+		"port":        p.DBPort,
+		"password":    p.DBPassword,
+		"user":        p.UserName,
+		"sslmode":     p.SSLMode,
+		"sslrootcert": p.SSLRootCert,
+		"sslcert":     p.SSLCert,
+		"sslkey":      p.SSLKey,
+	} {
+		if v != "" {
+			c[k] = v
+		}
+	}
+	return c
+}
+
+// Src returns the escaped connection string of the non empty fields.
+// Note that lib/pq doesn't understand sslmode allow and prefer, which only Connect handles.
 func (p *PGConf) Src() string {
-	return fmt.Sprintf("user=%s dbname=%s sslmode=disable host=%s password=%s port=%s", p.UserName, p.DBName, p.DBHost, p.DBPassword, p.DBPort)
+	return p.ConnInfo().String()
 }
 
 type TableDef struct {
-	Name       string
-	Columns    map[string]*sqlast.ColumnDef
-	Constrains []*sqlast.TableConstraint
-	Indexes    map[string]*sqlast.CreateIndexStmt
+	Name Identifier
+	// Columns is keyed by column name as it is stored in catalog.
+	Columns map[string]*sqlast.ColumnDef
+	// ColumnOrder holds column names in the order of ordinal_position.
+	ColumnOrder []string
+	Constrains  []*sqlast.TableConstraint
+	Indexes     map[string]*IndexDef
+	// PartitionKey is set when the table is partitioned.
+	PartitionKey *PartitionKey
+	// PartitionOf is set when the table is a partition of another table.
+	PartitionOf *PartitionDef
+	// ColumnCollations holds non default collations keyed by column name.
+	ColumnCollations map[string]string
+	Exclusions       []*ExclusionDef
+	Storage          TableStorage
+	// IdentityColumns holds GENERATED ... AS IDENTITY columns keyed by column name.
+	IdentityColumns map[string]string
+	// GeneratedColumns holds names of stored generated columns.
+	GeneratedColumns map[string]struct{}
+	// UnsupportedConstraints holds names of constraints which xmigrate doesn't
+	// manage (CHECK). They are left as they are.
+	UnsupportedConstraints []string
 }
 
-type PGDump struct {
-	db *sqlx.DB
-}
+const (
+	// IdentityAlways is the kind of GENERATED ALWAYS AS IDENTITY columns.
+	IdentityAlways = "ALWAYS"
+	// IdentityByDefault is the kind of GENERATED BY DEFAULT AS IDENTITY columns.
+	IdentityByDefault = "BY DEFAULT"
+)
 
-func NewPGDump(conf *PGConf) *PGDump {
-	src := conf.Src()
-	db, err := sqlx.Connect("postgres", src)
-	if err != nil {
-		log.Fatalf("connect failed with src: %s, err: %+v", src, err)
+func (t *TableDef) setIdentity(column, kind string) {
+	if t.IdentityColumns == nil {
+		t.IdentityColumns = make(map[string]string)
 	}
-
-	return &PGDump{db: db}
+	t.IdentityColumns[column] = kind
 }
 
-func NewPGDumpFromURL(dburl *dburl.URL) *PGDump {
-	db, err := sqlx.Connect(dburl.Driver, dburl.DSN)
-	if err != nil {
-		log.Fatalf("connect failed with src: %s, err: %+v", dburl.URL.String(), err)
+func (t *TableDef) setGenerated(column string) {
+	if t.GeneratedColumns == nil {
+		t.GeneratedColumns = make(map[string]struct{})
 	}
-
-	return &PGDump{db: db}
+	t.GeneratedColumns[column] = struct{}{}
 }
 
-func (p *PGDump) Dump(ctx context.Context) ([]*TableDef, error) {
-	tableNames, err := p.getTableNames(ctx)
-	if err != nil {
-		return nil, errors.Errorf("getTableNames failed: %w", err)
-	}
-
-	var tables []*TableDef
-	keymap := make(map[string][]*columnInfo)
-
-	for _, n := range tableNames {
-		constrains, tableConstraints, err := p.getTableConstrains(ctx, n, keymap)
-		if err != nil {
-			return nil, errors.Errorf("getTableConstrains failed: %w", err)
+// OrderedColumns returns columns in the order of ColumnOrder.
+// Columns which are not in ColumnOrder follow them in the order of name.
+func (t *TableDef) OrderedColumns() []*sqlast.ColumnDef {
+	var columns []*sqlast.ColumnDef
+	seen := make(map[string]struct{})
+	for _, n := range t.ColumnOrder {
+		c, ok := t.Columns[n]
+		if !ok {
+			continue
 		}
+		columns = append(columns, c)
+		seen[n] = struct{}{}
+	}
 
-		columns, err := p.getColumnDefinition(ctx, n)
-		if err != nil {
-			return nil, errors.Errorf("getColumnDefinition failed: %w", err)
+	var rest []string
+	for n := range t.Columns {
+		if _, ok := seen[n]; !ok {
+			rest = append(rest, n)
 		}
+	}
+	sort.Strings(rest)
+	for _, n := range rest {
+		columns = append(columns, t.Columns[n])
+	}
 
-		for _, c := range columns {
-			cnts, ok := constrains[c.Name.ToSQLString()]
-			if ok {
-				c.Constraints = append(c.Constraints, cnts...)
-			}
+	return columns
+}
 
-			// convert to int with nextval to serial
-			if serial, ok := fixSerial(c); ok {
-				c.DataType = serial
-				c.Default = nil
-			}
+type PGDump struct {
+	db     *sqlx.DB
+	filter *Filter
+	// version is cached by ServerVersion, guarded by versionMu since PGDump
+	// may be shared between goroutines.
+	versionMu sync.Mutex
+	version   ServerVersion
+	// ownDB is true when PGDump opened db, so Close closes it.
+	ownDB bool
+	// parallelism is the number of workers which introspect tables.
+	parallelism int
+}
 
-		}
+type DumpOption func(*PGDump)
 
-		tables = append(tables, &TableDef{
-			Name:       n,
-			Columns:    columns,
-			Constrains: tableConstraints,
-		})
+// WithDumpFilter makes PGDump skip objects which filter does not manage.
+// DefaultFilter is used unless it's given.
+func WithDumpFilter(filter *Filter) DumpOption {
+	return func(p *PGDump) {
+		p.filter = filter
 	}
+}
 
-	for _, t := range tables {
-		for _, c := range t.Columns {
-			for _, ref := range c.Constraints {
-				spec, ok := ref.Spec.(*sqlast.ReferencesColumnSpec)
-				if !ok {
-					continue
-				}
-				targinfo, ok := keymap[ref.Name.ToSQLString()]
-				if !ok {
-					return nil, errors.Errorf("key %s is not found", ref.Name.ToSQLString())
-				}
-
-				if len(targinfo) != 1 {
-					return nil, errors.Errorf("unknown column target %v", targinfo)
-				}
-
-				spec.TableName = sqlast.NewSQLObjectName(targinfo[0].TableName)
-				spec.Columns = []*sqlast.Ident{sqlast.NewIdent(targinfo[0].ColumnName)}
-			}
-		}
-
-		for _, c := range t.Constrains {
-			spec, ok := c.Spec.(*sqlast.ReferentialTableConstraint)
-			if !ok {
-				continue
-			}
-			targinfo, ok := keymap[c.Name.ToSQLString()]
-			if !ok {
-				return nil, errors.Errorf("key %s is not found", c.Name.ToSQLString())
-			}
-			spec.KeyExpr.TableName = sqlast.NewIdent(targinfo[0].TableName)
-			var columns []*sqlast.Ident
-
-			for _, column := range targinfo {
-				columns = append(columns, sqlast.NewIdent(column.ColumnName))
-			}
-
-			spec.KeyExpr.Columns = columns
-		}
-
-		idx, err := p.getIndexes(ctx, t)
-		if err != nil {
-			return nil, errors.Errorf("getIndex failed: %w", err)
-		}
-		t.Indexes = idx
+func newPGDump(db *sqlx.DB, opts []DumpOption) *PGDump {
+	p := &PGDump{db: db, filter: DefaultFilter()}
+	for _, o := range opts {
+		o(p)
 	}
+	return p
+}
 
-	return tables, nil
+// NewPGDump connects to the database which conf points to.
+// The connection is closed by Close.
+func NewPGDump(ctx context.Context, conf *PGConf, opts ...DumpOption) (*PGDump, error) {
+	return NewPGDumpFromConnInfo(ctx, conf.ConnInfo(), opts...)
 }
 
-func fixSerial(def *sqlast.ColumnDef) (*sqlast.Custom, bool) {
-	switch def.DataType.(type) {
-	case *sqlast.Int:
-		if def.Default != nil && strings.HasPrefix(def.Default.ToSQLString(), "nextval") {
-			return &sqlast.Custom{
-				Ty: sqlast.NewSQLObjectName("serial"),
-			}, true
-		}
-	case *sqlast.BigInt:
-		if def.Default != nil && strings.HasPrefix(def.Default.ToSQLString(), "nextval") {
-			return &sqlast.Custom{
-				Ty: sqlast.NewSQLObjectName("bigserial"),
-			}, true
+// NewPGDumpFromURL connects to the database which dburl points to.
+// The connection is closed by Close.
+func NewPGDumpFromURL(ctx context.Context, dburl *dburl.URL, opts ...DumpOption) (*PGDump, error) {
+	if dburl.Driver == "postgres" {
+		info, err := ConnInfoFromURL(dburl.URL.String())
+		if err != nil {
+			return nil, err
 		}
-	default:
-		return nil, false
+		return NewPGDumpFromConnInfo(ctx, info, opts...)
 	}
 
-	return nil, false
+	db, err := sqlx.ConnectContext(ctx, dburl.Driver, dburl.DSN)
+	if err != nil {
+		return nil, errors.Errorf("connect failed: %w", err)
+	}
+	return connectPGDump(ctx, db, opts)
 }
 
-type pgInformationSchemaTables struct {
-	TableCatalog              string         `db:"table_catalog"`
-	TableSchema               string         `db:"table_schema"`
-	TableName                 string         `db:"table_name"`
-	TableType                 string         `db:"table_type"`
-	SelfReferencingColumnName sql.NullString `db:"self_referencing_column_name"`
-	ReferenceGeneration       sql.NullString `db:"reference_generation"`
-	UserDefinedTypeCatalog    sql.NullString `db:"user_defined_type_catalog"`
-	UserDefinedTypeSchema     sql.NullString `db:"user_defined_type_schema"`
-	UserDefinedTypeName       sql.NullString `db:"user_defined_type_name"`
-	IsInsertableInto          string         `db:"is_insertable_into"`
-	IsTyped                   string         `db:"is_typed"`
-	CommitAction              sql.NullString `db:"commit_action"`
+// NewPGDumpFromConnInfo connects to the database with Connect.
+// The connection is closed by Close.
+func NewPGDumpFromConnInfo(ctx context.Context, info ConnInfo, opts ...DumpOption) (*PGDump, error) {
+	db, err := Connect(ctx, info)
+	if err != nil {
+		return nil, err
+	}
+	return connectPGDump(ctx, db, opts)
 }
 
-func (p *PGDump) getTableNames(ctx context.Context) ([]string, error) {
-	var tables []pgInformationSchemaTables
-	if err := p.db.SelectContext(ctx, &tables, "select * from information_schema.tables t where t.table_schema = 'public' and t.table_name != 'xmigrate' order by table_name"); err != nil {
-		return nil, errors.Errorf("selectContext failed: %w", err)
+func connectPGDump(ctx context.Context, db *sqlx.DB, opts []DumpOption) (*PGDump, error) {
+	p := newPGDump(db, opts)
+	p.ownDB = true
+	// the server version decides which catalog queries are used
+	if _, err := p.ServerVersion(ctx); err != nil {
+		db.Close()
+		return nil, errors.Errorf("ServerVersion failed: %w", err)
 	}
 
-	var tableNames []string
+	return p, nil
+}
 
-	for _, t := range tables {
-		tableNames = append(tableNames, t.TableName)
-	}
-	return tableNames, nil
+// NewPGDumpFromDB returns PGDump which uses db. db is not closed by Close.
+func NewPGDumpFromDB(db *sqlx.DB, opts ...DumpOption) *PGDump {
+	return newPGDump(db, opts)
 }
 
-type pgInformationSchemaColumns struct {
-	TableCatalog           string         `db:"table_catalog"`
-	TableSchema            string         `db:"table_schema"`
-	TableName              string         `db:"table_name"`
-	ColumnName             string         `db:"column_name"`
-	OrdinalPosition        int            `db:"ordinal_position"`
-	ColumnDefault          sql.NullString `db:"column_default"`
-	IsNullable             string         `db:"is_nullable"`
-	DataType               string         `db:"data_type"`
-	CharacterMaximumLength sql.NullInt64  `db:"character_maximum_length"`
-	CharacterOctetLength   sql.NullInt64  `db:"character_octet_length"`
-	NumericPrecision       sql.NullInt64  `db:"numeric_precision"`
-	NumericPrecisionRadix  sql.NullInt64  `db:"numeric_precision_radix"`
-	NumericScale           sql.NullInt64  `db:"numeric_scale"`
-	DatetimePrecision      sql.NullInt64  `db:"datetime_precision"`
-	IntervalType           sql.NullString `db:"interval_type"`
-	IntervalPrecision      sql.NullInt64  `db:"interval_precision"`
-	CharacterSetCatalog    sql.NullString `db:"character_set_catalog"`
-	CharacterSetSchema     sql.NullString `db:"character_set_schema"`
-	CharacterSetName       sql.NullString `db:"character_set_name"`
-	CollationCatalog       sql.NullString `db:"collation_catalog"`
-	CollationSchema        sql.NullString `db:"collation_schema"`
-	CollationName          sql.NullString `db:"collation_name"`
-	DomainCatalog          sql.NullString `db:"domain_catalog"`
-	DomainSchema           sql.NullString `db:"domain_schema"`
-	DomainName             sql.NullString `db:"domain_name"`
-	UDTCatalog             sql.NullString `db:"udt_catalog"`
-	UDTSchema              sql.NullString `db:"udt_schema"`
-	UDTName                sql.NullString `db:"udt_name"`
-	ScopeCatalog           sql.NullString `db:"scope_catalog"`
-	ScopeSchema            sql.NullString `db:"scope_schema"`
-	ScopeName              sql.NullString `db:"scope_name"`
-	MaximumCardinality     sql.NullInt64  `db:"maximum_cardinality"`
-	DTDIdentifier          sql.NullString `db:"dtd_identifier"`
-	IsSelfReferencing      sql.NullString `db:"is_self_referencing"`
-	IsIdentity             sql.NullString `db:"is_identity"`
-	IdentityGeneration     sql.NullString `db:"identity_generation"`
-	IdentityStart          sql.NullString `db:"identity_start"`
-	IdentityIncrement      sql.NullString `db:"identity_increment"`
-	IdentityMaximum        sql.NullString `db:"identity_maximum"`
-	IdentityMinimum        sql.NullString `db:"identity_minimum"`
-	IdentityCycle          sql.NullString `db:"identity_cycle"`
-	IsGenerated            sql.NullString `db:"is_generated"`
-	GenerationExpression   sql.NullString `db:"generation_expression"`
-	IsUpdatable            string         `db:"is_updatable"`
+// NewPGDumpFromSQLDB returns PGDump which uses db opened with lib/pq (or a compatible driver).
+// db is not closed by Close.
+func NewPGDumpFromSQLDB(db *sql.DB, opts ...DumpOption) *PGDump {
+	return newPGDump(sqlx.NewDb(db, "postgres"), opts)
 }
 
-func (p *PGDump) getColumnDefinition(ctx context.Context, schemaName string) (map[string]*sqlast.ColumnDef, error) {
-	var columns []*pgInformationSchemaColumns
-	if err := p.db.SelectContext(ctx, &columns, "select * from information_schema.columns where table_schema = 'public' and table_name = $1", schemaName); err != nil {
-		return nil, errors.Errorf("select columns with tableName %s failed: %w", schemaName, err)
+// Close closes the connection which NewPGDump or NewPGDumpFromURL opened.
+func (p *PGDump) Close() error {
+	if !p.ownDB {
+		return nil
 	}
+	return p.db.Close()
+}
 
-	columndefs := make(map[string]*sqlast.ColumnDef)
-	for _, c := range columns {
-		p := getParser(c.DataType)
-		tp, err := p.ParseDataType()
-		if err != nil {
-			return nil, errors.Errorf("parseDataTypeFailed: %w", err)
-		}
-		tp = parseTypeOption(tp, c)
-
-		var def sqlast.Node
-		if c.ColumnDefault.Valid {
-			p = getParser(c.ColumnDefault.String)
-			d, err := p.ParseExpr()
-			if err != nil {
-				return nil, errors.Errorf("parseDefault Value failed: %w", err)
-			}
-			def = d
-		}
-
-		var constrains []*sqlast.ColumnConstraint
-
-		if strings.EqualFold(c.IsNullable, "NO") {
-			constrains = append(constrains, &sqlast.ColumnConstraint{
-				Spec: &sqlast.NotNullColumnSpec{},
-			})
-		}
-
-		columndefs[c.ColumnName] = &sqlast.ColumnDef{
-			DataType:    tp,
-			Default:     def,
-			Name:        sqlast.NewIdent(c.ColumnName),
-			Constraints: constrains,
-		}
+// Dump returns tables in public schema, which are read in a single read only transaction.
+func (p *PGDump) Dump(ctx context.Context) ([]*TableDef, error) {
+	version, err := p.ServerVersion(ctx)
+	if err != nil {
+		return nil, errors.Errorf("ServerVersion failed: %w", err)
 	}
 
-	return columndefs, nil
-}
-
-func parseTypeOption(tp sqlast.Type, info *pgInformationSchemaColumns) sqlast.Type {
-	switch tp.(type) {
-	case *sqlast.VarcharType:
-		if info.CharacterMaximumLength.Valid {
-			return &sqlast.VarcharType{
-				Size: sqlast.NewSize(uint(info.CharacterMaximumLength.Int64)),
-			}
-		}
-		return tp
-	case *sqlast.Decimal:
-		var precision *uint
-		if info.NumericPrecision.Valid {
-			v := uint(info.NumericPrecision.Int64)
-			precision = &v
-		}
-		var scale *uint
-		if info.NumericScale.Valid {
-			v := uint(info.NumericScale.Int64)
-			scale = &v
-		}
-		return &sqlast.Decimal{
-			Precision: precision,
-			Scale:     scale,
-		}
-	default:
-		return tp
+	tx, err := p.beginRead(ctx)
+	if err != nil {
+		return nil, err
 	}
-}
+	defer tx.Rollback()
 
-type pgInformationSchemaConstraints struct {
-	ColumnName           string         `db:"column_name"`
-	ConstraintName       string         `db:"constraint_name"`
-	ConstraintType       string         `db:"constraint_type"`
-	TableName            string         `db:"table_name"`
-	UniqueConstraintName sql.NullString `db:"unique_constraint_name"`
+	return p.dump(ctx, tx, version)
 }
 
-type pgTableConstraints struct {
-	ColumnCount    int    `db:"column_count"`
-	ConstraintName string `db:"constraint_name"`
-}
+var readOnlyTxOptions = &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}
 
-type columnInfo struct {
-	TableName  string
-	ColumnName string
+// beginRead begins REPEATABLE READ READ ONLY transaction, in which every query
+// sees the same snapshot. Otherwise a migration running concurrently could make
+// the dump a state which never existed, e.g. a foreign key to a table which is
+// not dumped.
+func (p *PGDump) beginRead(ctx context.Context) (*sqlx.Tx, error) {
+	tx, err := p.db.BeginTxx(ctx, readOnlyTxOptions)
+	if err != nil {
+		return nil, errors.Errorf("begin failed: %w", err)
+	}
+	return tx, nil
 }
 
-func (p *PGDump) getTableConstrains(ctx context.Context, tableName string, keymap map[string][]*columnInfo) (map[string][]*sqlast.ColumnConstraint, []*sqlast.TableConstraint, error) {
-
-	// looking for table constraints by counting columns which have same constraint_name
-	var pgTableConstraints []*pgTableConstraints
-
-	if err := p.db.SelectContext(ctx, &pgTableConstraints, `select
-                      count(table_constraints.constraint_name) as column_count, 
-					  table_constraints.constraint_name as constraint_name
-                  from
-                      information_schema.table_constraints
-                  left join information_schema.referential_constraints on referential_constraints.constraint_name = table_constraints.constraint_name
-                  join information_schema.constraint_column_usage on constraint_column_usage.constraint_name = table_constraints.constraint_name
-                  where table_constraints.table_schema = 'public' and table_constraints.table_name = $1 group by table_constraints.constraint_name`, tableName); err != nil {
-		return nil, nil, errors.Errorf("selectContext failed: %w", err)
+func (p *PGDump) dump(ctx context.Context, tx *sqlx.Tx, version ServerVersion) ([]*TableDef, error) {
+	if p.parallelism > 1 {
+		return p.dumpParallel(ctx, tx, version)
 	}
+	return p.dumpTables(ctx, tx, version)
+}
 
-	tableConstraintsmap := make(map[string][]*pgInformationSchemaConstraints)
-
-	for _, t := range pgTableConstraints {
-		if t.ColumnCount > 1 {
-			tableConstraintsmap[t.ConstraintName] = []*pgInformationSchemaConstraints{}
-		}
+func (p *PGDump) dumpTables(ctx context.Context, q sqlx.QueryerContext, version ServerVersion) ([]*TableDef, error) {
+	// every object is fetched at once and assembled in memory, since a query
+	// per table makes thousands of round trips on large schemas.
+	tables, err := getTables(ctx, q, version)
+	if err != nil {
+		return nil, errors.Errorf("getTables failed: %w", err)
+	}
+	columns, err := getColumns(ctx, q, version, nil)
+	if err != nil {
+		return nil, errors.Errorf("getColumns failed: %w", err)
+	}
+	constraints, err := getConstraints(ctx, q, nil)
+	if err != nil {
+		return nil, errors.Errorf("getConstraints failed: %w", err)
+	}
+	indexes, err := getIndexes(ctx, q, nil)
+	if err != nil {
+		return nil, errors.Errorf("getIndexes failed: %w", err)
 	}
 
-	var constrains []*pgInformationSchemaConstraints
-	if err := p.db.SelectContext(ctx, &constrains,
-		`select 
-					constraint_column_usage.column_name, 
-					table_constraints.constraint_name,
-					table_constraints.constraint_type,
-					table_constraints.table_name,
-					referential_constraints.unique_constraint_name
- 				from
-					information_schema.table_constraints
-				left join information_schema.referential_constraints on referential_constraints.constraint_name = table_constraints.constraint_name
- 				join information_schema.constraint_column_usage on constraint_column_usage.constraint_name = table_constraints.constraint_name
- 				where table_constraints.table_schema = 'public' and table_constraints.table_name = $1 order by table_constraints.constraint_name`, tableName); err != nil {
-		return nil, nil, errors.Errorf("selectContext failed: %w", err)
-	}
-
-	// column constraints (key is column name)
-	columnConstraintmap := make(map[string][]*sqlast.ColumnConstraint)
-
-	for _, c := range constrains {
-		if _, ok := keymap[c.ConstraintName]; ok {
-			keymap[c.ConstraintName] = []*columnInfo{}
-		}
-		keymap[c.ConstraintName] = append(keymap[c.ConstraintName], &columnInfo{
-			TableName:  c.TableName,
-			ColumnName: c.ColumnName,
-		})
-
-		tc, ok := tableConstraintsmap[c.ConstraintName]
-		if ok {
-			tableConstraintsmap[c.ConstraintName] = append(tc, c)
+	var defs []*TableDef
+	for _, t := range tables {
+		if !p.filter.Managed(TableObject, t.Name) {
 			continue
 		}
-
-		switch c.ConstraintType {
-		case "FOREIGN KEY":
-			columnConstraintmap[c.ColumnName] = append(columnConstraintmap[c.ColumnName], &sqlast.ColumnConstraint{
-				Name: sqlast.NewIdent(c.ConstraintName),
-				Spec: &sqlast.ReferencesColumnSpec{},
-			})
-		case "UNIQUE":
-			columnConstraintmap[c.ColumnName] = append(columnConstraintmap[c.ColumnName], &sqlast.ColumnConstraint{
-				Name: sqlast.NewIdent(c.ConstraintName),
-				Spec: &sqlast.UniqueColumnSpec{},
-			})
-		case "PRIMARY KEY":
-			columnConstraintmap[c.ColumnName] = append(columnConstraintmap[c.ColumnName], &sqlast.ColumnConstraint{
-				Name: sqlast.NewIdent(c.ConstraintName),
-				Spec: &sqlast.UniqueColumnSpec{
-					IsPrimaryKey: true,
-				},
-			})
-		default:
-			return nil, nil, errors.Errorf("currently unsupported constraint %s", c.ConstraintType)
+		def, err := buildTableDef(t, columns[t.Name], constraints[t.Name], indexes[t.Name])
+		if err != nil {
+			return nil, errors.Errorf("buildTableDef failed with tableName %s: %w", t.Name, err)
 		}
+		defs = append(defs, def)
 	}
 
-	var tableConstraints []*sqlast.TableConstraint
+	return p.filter.FilterTables(defs), nil
+}
 
-	for name, c := range tableConstraintsmap {
+// serialTypes maps integer types to serial types which own a sequence.
+var serialTypes = map[string]string{
+	"smallint": "smallserial",
+	"integer":  "serial",
+	"bigint":   "bigserial",
+}
 
-		var spec sqlast.TableConstraintSpec
-		var columns []*sqlast.Ident
-		for _, column := range c {
-			columns = append(columns, sqlast.NewIdent(column.ColumnName))
-		}
-		switch c[0].ConstraintType {
-		case "FOREIGN KEY":
-			spec = &sqlast.ReferentialTableConstraint{
-				Columns: columns,
-				KeyExpr: &sqlast.ReferenceKeyExpr{},
-			}
-		case "UNIQUE":
-			spec = &sqlast.UniqueTableConstraint{
-				Columns: columns,
-			}
-		case "PRIMARY KEY":
-			spec = &sqlast.UniqueTableConstraint{
-				IsPrimary: true,
-				Columns:   columns,
-			}
-		default:
-			return nil, nil, errors.Errorf("currently unsupported table constraint %s", name)
-		}
-		tableConstraints = append(tableConstraints, &sqlast.TableConstraint{
-			Name: sqlast.NewIdent(name),
-			Spec: spec,
-		})
+func fixSerial(def *sqlast.ColumnDef) (*sqlast.Custom, bool) {
+	serial, ok := serialTypes[CanonicalType(def.DataType)]
+	if !ok {
+		return nil, false
+	}
+	if def.Default == nil || !strings.HasPrefix(def.Default.ToSQLString(), "nextval") {
+		return nil, false
 	}
 
-	return columnConstraintmap, tableConstraints, nil
+	return &sqlast.Custom{
+		Ty: sqlast.NewSQLObjectName(serial),
+	}, true
 }
 
-type pgIndexInfo struct {
-	SchemaName string         `db:"schemaname"`
-	TableName  string         `db:"tablename"`
-	IndexName  string         `db:"indexname"`
-	IndexDef   string         `db:"indexdef"`
-	TableSpace sql.NullString `db:"tablespace"`
+type pgExtension struct {
+	Name   string `db:"extname"`
+	Schema string `db:"nspname"`
 }
 
-func (p *PGDump) getIndexes(ctx context.Context, tableDef *TableDef) (map[string]*sqlast.CreateIndexStmt, error) {
-	var indexes []pgIndexInfo
+// DumpExtensions returns installed extensions.
+func (p *PGDump) DumpExtensions(ctx context.Context) ([]*ExtensionDef, error) {
+	return p.dumpExtensions(ctx, p.db)
+}
 
-	if err := p.db.SelectContext(ctx, &indexes, "SELECT * from pg_indexes where tablename = $1", tableDef.Name); err != nil {
-		return nil, errors.Errorf("selectContext failed with tableName %s: %w", tableDef.Name, err)
+func (p *PGDump) dumpExtensions(ctx context.Context, q sqlx.QueryerContext) ([]*ExtensionDef, error) {
+	var extensions []*pgExtension
+	if err := sqlx.SelectContext(ctx, q, &extensions, `select
+					e.extname as extname,
+					n.nspname as nspname
+				from
+					pg_extension e
+				join pg_namespace n on n.oid = e.extnamespace
+				order by e.extname`); err != nil {
+		return nil, errors.Errorf("selectContext failed: %w", err)
 	}
-	indexMap := make(map[string]*sqlast.CreateIndexStmt)
-
-	for _, i := range indexes {
-		parser := getParser(i.IndexDef)
-		indexdef, err := parser.ParseStatement()
-		if err != nil {
-			return nil, errors.Errorf("def: %s parse failed: %w", i.IndexDef, err)
-		}
-
-		indexsql, ok := indexdef.(*sqlast.CreateIndexStmt)
-		if !ok {
-			return nil, errors.Errorf("def: %s is not a create index statement", i.IndexDef)
-		}
-
-		if len(indexsql.ColumnNames) == 1 {
-			col := tableDef.Columns[indexsql.ColumnNames[0].ToSQLString()]
-
-			var isunique bool
-			for _, c := range col.Constraints {
-				if _, ok := c.Spec.(*sqlast.UniqueColumnSpec); !ok {
-					continue
-				}
-				isunique = true
-			}
-			if indexsql.IsUnique && isunique {
-				continue
-			}
-			indexMap[i.IndexName] = indexsql
 
-			continue
-		} else {
-			var skip bool
-			if indexsql.IsUnique {
-				for _, cons := range tableDef.Constrains {
-					uniqueCons, ok := cons.Spec.(*sqlast.UniqueTableConstraint)
-					if !ok {
-						continue
-					}
-					if sameColumnNames(indexsql.ColumnNames, uniqueCons.Columns) {
-						skip = true
-					}
-				}
-			}
-
-			if !skip {
-				indexMap[i.IndexName] = indexsql
-			}
-		}
+	var defs []*ExtensionDef
+	for _, e := range extensions {
+		defs = append(defs, &ExtensionDef{Name: e.Name, Schema: e.Schema})
 	}
 
-	return indexMap, nil
+	return p.filter.FilterExtensions(defs), nil
+}
 
+type pgCollation struct {
+	Name          string         `db:"collname"`
+	Provider      sql.NullString `db:"provider"`
+	Locale        sql.NullString `db:"locale"`
+	LcCollate     sql.NullString `db:"lc_collate"`
+	LcCtype       sql.NullString `db:"lc_ctype"`
+	Deterministic bool           `db:"deterministic"`
 }
 
-func getParser(src string) *xsqlparser.Parser {
-	parser, err := xsqlparser.NewParser(bytes.NewBufferString(src), &dialect.PostgresqlDialect{})
+// DumpCollations returns collations created in public schema.
+func (p *PGDump) DumpCollations(ctx context.Context) ([]*CollationDef, error) {
+	version, err := p.ServerVersion(ctx)
 	if err != nil {
-		log.Fatalf("initialize parser failed with input %s err: %+v", src, err)
+		return nil, errors.Errorf("ServerVersion failed: %w", err)
 	}
-
-	return parser
+	return p.dumpCollations(ctx, p.db, version)
 }
 
-func sameColumnNames(a []*sqlast.Ident, b []*sqlast.Ident) bool {
-	if len(a) != len(b) {
-		return false
+func (p *PGDump) dumpCollations(ctx context.Context, q sqlx.QueryerContext, version ServerVersion) ([]*CollationDef, error) {
+	var collations []*pgCollation
+	if err := sqlx.SelectContext(ctx, q, &collations, fmt.Sprintf(`select
+					c.collname as collname,
+					%s
+				from
+					pg_collation c
+				join pg_namespace n on n.oid = c.collnamespace
+				where n.nspname = 'public' order by c.collname`, collationColumns(version))); err != nil {
+		return nil, errors.Errorf("selectContext failed: %w", err)
 	}
-	sort.Slice(a, func(i, j int) bool {
-		return a[i].ToSQLString() > a[j].ToSQLString()
-	})
-	sort.Slice(b, func(i, j int) bool {
-		return b[i].ToSQLString() > b[j].ToSQLString()
-	})
 
-	for idx, c := range a {
-		if c.ToSQLString() != b[idx].ToSQLString() {
-			return false
+	var defs []*CollationDef
+	for _, c := range collations {
+		def := &CollationDef{
+			Name:          c.Name,
+			Provider:      c.Provider.String,
+			Locale:        c.Locale.String,
+			LcCollate:     c.LcCollate.String,
+			LcCtype:       c.LcCtype.String,
+			Deterministic: c.Deterministic,
+		}
+		if def.Provider == "" {
+			def.Provider = "libc"
+		}
+		// icu collations before 15 keep the locale in collcollate
+		if def.Provider == "icu" && def.Locale == "" {
+			def.Locale = def.LcCollate
 		}
+		if def.Provider == "icu" {
+			def.LcCollate = ""
+			def.LcCtype = ""
+		}
+		defs = append(defs, def)
+	}
+
+	return p.filter.FilterCollations(defs), nil
+}
+
+// collationColumns returns the columns of pg_collation, which differ among server versions
+// (collprovider: 10+, collisdeterministic: 12+, colliculocale: 15-16, colllocale: 17+).
+func collationColumns(version ServerVersion) string {
+	provider := "null"
+	if version >= PG10 {
+		provider = "case c.collprovider when 'i' then 'icu' when 'c' then 'libc' end"
+	}
+	deterministic := "true"
+	if version >= PG12 {
+		deterministic = "c.collisdeterministic"
+	}
+	locale := "null"
+	switch {
+	case version >= PG17:
+		locale = "c.colllocale"
+	case version >= PG15:
+		locale = "c.colliculocale"
 	}
 
-	return true
+	return fmt.Sprintf(`%s as provider,
+					%s as locale,
+					c.collcollate as lc_collate,
+					c.collctype as lc_ctype,
+					%s as deterministic`, provider, locale, deterministic)
 }
@@ -2,10 +2,14 @@ package xmigrate
 
 import (
 	"context"
+	"database/sql"
+	"fmt"
+	"strings"
 	"testing"
 
-	"github.com/akito0107/xsqlparser/sqlast"
 	"github.com/google/go-cmp/cmp"
+	"github.com/jmoiron/sqlx"
+	errors "golang.org/x/xerrors"
 )
 
 func TestPGDump_DumpHelpers(t *testing.T) {
@@ -17,27 +21,44 @@ func TestPGDump_DumpHelpers(t *testing.T) {
 		UserName: "postgres",
 	}
 
-	dumper := NewPGDump(conf)
+	dumper, err := NewPGDump(context.Background(), conf)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer dumper.Close()
+	version, err := dumper.ServerVersion(context.Background())
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
 
-	t.Run("getTableNames", func(t *testing.T) {
+	t.Run("getTables", func(t *testing.T) {
 		ctx := context.Background()
 
-		tableNames, err := dumper.getTableNames(ctx)
+		tables, err := getTables(ctx, dumper.db, version)
 		if err != nil {
 			t.Fatalf("%+v", err)
 		}
 
-		expected := []string{"account", "category", "item", "num_tests", "subcategory", "subitem"}
+		var tableNames []string
+		for _, t := range tables {
+			tableNames = append(tableNames, t.Name)
+		}
+
+		expected := []string{"account", "category", "item", "num_tests", "subcategory", "subitem", "type_tests"}
 
 		if diff := cmp.Diff(tableNames, expected); diff != "" {
 			t.Errorf("should be same but %s", diff)
 		}
 	})
 
-	t.Run("getColumnDefinition", func(t *testing.T) {
+	t.Run("getColumns", func(t *testing.T) {
+		columns, err := getColumns(context.Background(), dumper.db, version, nil)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+
 		t.Run("accont table (no refs)", func(t *testing.T) {
-			ctx := context.Background()
-			defs, err := dumper.getColumnDefinition(ctx, "account")
+			defs, order, _, err := buildColumns(columns["account"])
 			if err != nil {
 				t.Fatalf("%+v", err)
 			}
@@ -45,6 +66,34 @@ func TestPGDump_DumpHelpers(t *testing.T) {
 			if len(defs) != 5 {
 				t.Errorf("should be 5 columns but %d", len(defs))
 			}
+
+			if diff := cmp.Diff(order, []string{"account_id", "name", "email", "age", "registered_at"}); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
+		})
+
+		t.Run("type_tests table (type modifiers)", func(t *testing.T) {
+			defs, _, _, err := buildColumns(columns["type_tests"])
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+
+			expect := map[string]string{
+				"id":         "integer",
+				"code":       "character(3)",
+				"created_at": "timestamp(3) with time zone",
+				"duration":   "interval day to second(2)",
+				"flags":      "bit(8)",
+				"scores":     "integer[]",
+				"tags":       "character varying(20)[]",
+			}
+			act := make(map[string]string)
+			for n, d := range defs {
+				act[n] = CanonicalType(d.DataType)
+			}
+			if diff := cmp.Diff(act, expect); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
 		})
 
 	})
@@ -60,50 +109,291 @@ func TestPGDump_Dump(t *testing.T) {
 		UserName:   "postgres",
 	}
 
-	dumper := NewPGDump(conf)
 	ctx := context.Background()
+	dumper, err := NewPGDump(ctx, conf)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer dumper.Close()
 	dumped, err := dumper.Dump(ctx)
 	if err != nil {
 		t.Fatalf("%+v", err)
 	}
 
-	if len(dumped) != 6 {
+	if len(dumped) != 7 {
 		t.Fatalf("%+v", dumped)
 	}
 
 	for _, d := range dumped {
 		if d.Name == "account" {
 
-			exceptIdx, err := getParser("CREATE INDEX name_idx ON public.account using btree (name);").ParseStatement()
-			if err != nil {
-				t.Fatal(err)
-			}
-
 			if len(d.Indexes) != 1 {
 				t.Fatal("must be index only name_idx")
 			}
 
-			idx := exceptIdx.(*sqlast.SQLCreateIndex)
-			if diff := cmp.Diff(idx, d.Indexes["name_idx"], IgnoreMarker); diff != "" {
+			expect := &IndexDef{
+				Name:    "name_idx",
+				Table:   "account",
+				Method:  "btree",
+				Columns: []*IndexColumn{{Expr: "name"}},
+			}
+			if diff := cmp.Diff(expect, d.Indexes["name_idx"]); diff != "" {
 				t.Errorf("diff: %s", diff)
 			}
 		}
 
-		if d.Name == "item" {
-			exceptIdx, err := getParser("create index cat_name_idx on public.item using btree (category_id, name);").ParseStatement()
+		if d.Name == "category" {
+			expect, err := parseIndexDef("create unique index lower_name_idx on category (lower(name)) where category_id > 0")
 			if err != nil {
 				t.Fatal(err)
 			}
 
+			idx, ok := d.Indexes["lower_name_idx"]
+			if !ok {
+				t.Fatalf("must be index lower_name_idx but %+v", d.Indexes)
+			}
+			if !expect.equal(idx) {
+				t.Errorf("should be same but %s", idx.ToSQLString())
+			}
+		}
+
+		if d.Name == "item" {
 			if len(d.Indexes) != 1 {
 				t.Fatalf("must be index only cate_name_idx but %+v", d.Indexes)
 			}
 
-			idx := exceptIdx.(*sqlast.SQLCreateIndex)
-			if diff := cmp.Diff(idx, d.Indexes["cat_name_idx"], IgnoreMarker); diff != "" {
+			expect := &IndexDef{
+				Name:    "cat_name_idx",
+				Table:   "item",
+				Method:  "btree",
+				Columns: []*IndexColumn{{Expr: "category_id"}, {Expr: "name"}},
+			}
+			if diff := cmp.Diff(expect, d.Indexes["cat_name_idx"]); diff != "" {
 				t.Errorf("diff: %s", diff)
 			}
 		}
 	}
 
 }
+
+func TestPGDump_DumpWithFilter(t *testing.T) {
+	conf := &PGConf{
+		DBName:     "xmigrate_test",
+		DBHost:     "127.0.0.1",
+		DBPort:     "5432",
+		DBPassword: "passw0rd",
+		UserName:   "postgres",
+	}
+
+	filter := DefaultFilter()
+	for kind, pattern := range map[ObjectKind]string{
+		TableObject:  "/^(category|subcategory)$/",
+		ColumnObject: "account.email",
+		IndexObject:  "name_*",
+	} {
+		if err := filter.Exclude(kind, pattern); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dumper, err := NewPGDump(context.Background(), conf, WithDumpFilter(filter))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer dumper.Close()
+	dumped, err := dumper.Dump(context.Background())
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if len(dumped) != 5 {
+		t.Fatalf("must be 5 tables but %d", len(dumped))
+	}
+	for _, d := range dumped {
+		if d.Name == "category" || d.Name == "subcategory" {
+			t.Errorf("%s must be excluded", d.Name)
+		}
+		if d.Name != "account" {
+			continue
+		}
+		if _, ok := d.Columns["email"]; ok {
+			t.Errorf("account.email must be excluded")
+		}
+		if len(d.Indexes) != 0 {
+			t.Errorf("name_idx must be excluded but %+v", d.Indexes)
+		}
+	}
+}
+
+// BenchmarkPGDump_Dump compares the batched queries of Dump with a query per
+// table on schemas with many tables. The batched ones are a fixed number of
+// queries, so the time grows with the amount of rows rather than with round
+// trips per table.
+func BenchmarkPGDump_Dump(b *testing.B) {
+	conf := &PGConf{
+		DBName:     "xmigrate_test",
+		DBHost:     "127.0.0.1",
+		DBPort:     "5432",
+		DBPassword: "passw0rd",
+		UserName:   "postgres",
+	}
+
+	ctx := context.Background()
+	dumper, err := NewPGDump(ctx, conf)
+	if err != nil {
+		b.Fatalf("%+v", err)
+	}
+	defer dumper.Close()
+	version, err := dumper.ServerVersion(ctx)
+	if err != nil {
+		b.Fatalf("%+v", err)
+	}
+
+	for _, n := range []int{10, 100, 500} {
+		b.Run(fmt.Sprintf("%d tables", n), func(b *testing.B) {
+			drop := createBenchTables(ctx, b, dumper.db, n)
+			defer drop()
+
+			b.Run("batched", func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if _, err := dumper.dumpTables(ctx, dumper.db, version); err != nil {
+						b.Fatalf("%+v", err)
+					}
+				}
+			})
+			b.Run("per table", func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if _, err := dumpPerTable(ctx, dumper, version); err != nil {
+						b.Fatalf("%+v", err)
+					}
+				}
+			})
+		})
+	}
+}
+
+// createBenchTables creates n tables named bench_*. The returned func drops them,
+// which reports failures without stopping, since it's deferred.
+func createBenchTables(ctx context.Context, b *testing.B, db *sqlx.DB, n int) func() {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("bench_%d", i)
+	}
+	drop := func() {
+		if _, err := db.ExecContext(ctx, "drop table if exists "+strings.Join(names, ", ")); err != nil {
+			b.Errorf("drop bench tables failed: %+v", err)
+		}
+	}
+	// tables which are left by an aborted run
+	drop()
+
+	for _, name := range names {
+		if _, err := db.ExecContext(ctx, fmt.Sprintf(`create table %s (
+				id serial primary key,
+				account_id int references account(account_id),
+				name varchar(255) not null,
+				created_at timestamp with time zone default current_timestamp
+			); create index %s_name_idx on %s (name)`, name, name, name)); err != nil {
+			drop()
+			b.Fatalf("%+v", err)
+		}
+	}
+	return drop
+}
+
+// dumpPerTable is the way Dump used to read catalogs, with a query per table
+// for each of columns, constraints and indexes. It's kept for the benchmark.
+func dumpPerTable(ctx context.Context, p *PGDump, version ServerVersion) ([]*TableDef, error) {
+	tables, err := getTables(ctx, p.db, version)
+	if err != nil {
+		return nil, errors.Errorf("getTables failed: %w", err)
+	}
+
+	var defs []*TableDef
+	for _, t := range tables {
+		if !p.filter.Managed(TableObject, t.Name) {
+			continue
+		}
+		names := []string{t.Name}
+		columns, err := getColumns(ctx, p.db, version, names)
+		if err != nil {
+			return nil, errors.Errorf("getColumns failed with tableName %s: %w", t.Name, err)
+		}
+		constraints, err := getConstraints(ctx, p.db, names)
+		if err != nil {
+			return nil, errors.Errorf("getConstraints failed with tableName %s: %w", t.Name, err)
+		}
+		indexes, err := getIndexes(ctx, p.db, names)
+		if err != nil {
+			return nil, errors.Errorf("getIndexes failed with tableName %s: %w", t.Name, err)
+		}
+		def, err := buildTableDef(t, columns[t.Name], constraints[t.Name], indexes[t.Name])
+		if err != nil {
+			return nil, errors.Errorf("buildTableDef failed with tableName %s: %w", t.Name, err)
+		}
+		defs = append(defs, def)
+	}
+
+	return p.filter.FilterTables(defs), nil
+}
+
+func TestNewPGDump_Error(t *testing.T) {
+	conf := &PGConf{
+		DBName:   "xmigrate_test",
+		DBHost:   "127.0.0.1",
+		DBPort:   "1",
+		UserName: "postgres",
+	}
+
+	if _, err := NewPGDump(context.Background(), conf); err == nil {
+		t.Errorf("must be error")
+	}
+}
+
+func TestPGDump_CloseExternalDB(t *testing.T) {
+	db, err := sql.Open("postgres", "host=127.0.0.1 port=1 sslmode=disable")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer db.Close()
+
+	dumper := NewPGDumpFromSQLDB(db)
+	if err := dumper.Close(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	// the db which is given by the caller must be left open
+	if err := db.Ping(); err != nil && err.Error() == "sql: database is closed" {
+		t.Errorf("db must not be closed")
+	}
+}
+
+func TestPGDump_BeginRead(t *testing.T) {
+	ctx := context.Background()
+	dumper, err := NewPGDump(ctx, &PGConf{
+		DBName:   "xmigrate_test",
+		DBHost:   "localhost",
+		DBPort:   "5432",
+		UserName: "postgres",
+	})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer dumper.Close()
+
+	tx, err := dumper.beginRead(ctx)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer tx.Rollback()
+
+	var isolation, readOnly string
+	if err := tx.GetContext(ctx, &isolation, "show transaction_isolation"); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := tx.GetContext(ctx, &readOnly, "show transaction_read_only"); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if isolation != "repeatable read" || readOnly != "on" {
+		t.Errorf("must be repeatable read and read only but %s, %s", isolation, readOnly)
+	}
+}
@@ -0,0 +1,211 @@
+package xmigrate
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/akito0107/xsqlparser/sqlast"
+	errors "golang.org/x/xerrors"
+)
+
+// pgDumpHeader is written at the top of the output of pg_dump.
+const pgDumpHeader = "-- PostgreSQL database dump"
+
+// isPGDumpOutput reports whether b is written by pg_dump.
+func isPGDumpOutput(b []byte) bool {
+	if len(b) > 1024 {
+		b = b[:1024]
+	}
+	return bytes.Contains(b, []byte(pgDumpHeader))
+}
+
+var (
+	// pgDumpIgnoredPatterns are statements of pg_dump which don't change the target state.
+	// Sequences are owned by serial columns, which are found by their nextval defaults.
+	pgDumpIgnoredPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`(?is)^set\s`),
+		regexp.MustCompile(`(?is)^select\s+(set_config|setval)\s*\(`),
+		regexp.MustCompile(`(?is)^comment\s+on\s`),
+		regexp.MustCompile(`(?is)^(grant|revoke)\s`),
+		regexp.MustCompile(`(?is)^alter\s.*\sowner\s+to\s+\S+$`),
+		regexp.MustCompile(`(?is)^create\s+sequence\s`),
+		regexp.MustCompile(`(?is)^alter\s+sequence\s+\S+\s+owned\s+by\s`),
+	}
+
+	attachPartitionPattern = regexp.MustCompile(`(?is)^alter\s+table\s+(?:only\s+)?("(?:[^"]|"")+"|\w+)\s+attach\s+partition\s+("(?:[^"]|"")+"|\w+)\s+(.*)$`)
+	attachIndexPattern     = regexp.MustCompile(`(?is)^alter\s+index\s+("(?:[^"]|"")+"|\w+)\s+attach\s+partition\s+("(?:[^"]|"")+"|\w+)$`)
+	addIdentityPattern     = regexp.MustCompile(`(?is)^alter\s+table\s+(?:only\s+)?("(?:[^"]|"")+"|\w+)\s+alter\s+column\s+("(?:[^"]|"")+"|\w+)\s+add\s+generated\s+(always|by\s+default)\s+as\s+identity\b`)
+)
+
+// ParsePGDumpSchema reads the output of pg_dump --schema-only and builds the target state.
+// Partitions attached with ATTACH PARTITION and identity columns added with
+// ALTER COLUMN ... ADD GENERATED are folded into the tables in addition to
+// ALTER TABLE statements which ParseSchema folds, and SET, COMMENT, GRANT,
+// OWNER TO and sequences of serial columns are skipped. Sequence options of
+// identity columns are not managed, as well as sequences of serial columns.
+func ParsePGDumpSchema(src io.Reader) (*TargetTable, error) {
+	b, err := ioutil.ReadAll(src)
+	if err != nil {
+		return nil, errors.Errorf("read schema failed: %w", err)
+	}
+
+	var stmts []string
+	var partitions []*PartitionDef
+	attachedIndexes := make(map[Identifier]struct{})
+	identities := make(map[string]map[string]string)
+
+statements:
+	for _, s := range splitStatements(stripMetaCommands(string(b))) {
+		s = stripSchemaQualifiers(s)
+		for _, p := range pgDumpIgnoredPatterns {
+			if p.MatchString(s) {
+				continue statements
+			}
+		}
+
+		if m := attachPartitionPattern.FindStringSubmatch(s); m != nil {
+			partitions = append(partitions, &PartitionDef{
+				Name:   ParseIdentifier(m[2]),
+				Parent: ParseIdentifier(m[1]),
+				Bound:  strings.TrimSpace(m[3]),
+			})
+			continue
+		}
+		if m := attachIndexPattern.FindStringSubmatch(s); m != nil {
+			// the index is managed by the index of the parent table
+			attachedIndexes[ParseIdentifier(m[2])] = struct{}{}
+			continue
+		}
+		if m := addIdentityPattern.FindStringSubmatch(s); m != nil {
+			table := string(ParseIdentifier(m[1]))
+			if identities[table] == nil {
+				identities[table] = make(map[string]string)
+			}
+			identities[table][string(ParseIdentifier(m[2]))] = strings.ToUpper(strings.Join(strings.Fields(m[3]), " "))
+			continue
+		}
+
+		stmts = append(stmts, s)
+	}
+
+	targ, err := parseStatements(stmts)
+	if err != nil {
+		return nil, err
+	}
+	for table, columns := range identities {
+		targ.IdentityColumns[table] = columns
+	}
+
+	// pg_dump creates partitions as plain tables and attaches them afterwards.
+	// Constraints folded into them are dropped together, since partitions
+	// are compared by their bounds only.
+	for _, p := range partitions {
+		if err := attachPartition(targ, p); err != nil {
+			return nil, err
+		}
+	}
+
+	var indexes []*IndexDef
+	for _, i := range targ.IndexDef {
+		if _, ok := attachedIndexes[i.Name]; !ok {
+			indexes = append(indexes, i)
+		}
+	}
+	targ.IndexDef = indexes
+
+	for _, t := range targ.TableDef {
+		for _, e := range t.Elements {
+			c, ok := e.(*sqlast.ColumnDef)
+			if !ok {
+				continue
+			}
+			if serial, ok := fixSerial(c); ok {
+				c.DataType = serial
+				c.Default = nil
+			}
+		}
+	}
+
+	return targ, nil
+}
+
+// attachPartition turns the table created by pg_dump into the partition p.
+func attachPartition(targ *TargetTable, p *PartitionDef) error {
+	var tables []*sqlast.CreateTableStmt
+	var found bool
+	for _, t := range targ.TableDef {
+		if ParseIdentifier(t.Name.ToSQLString()) == p.Name {
+			found = true
+			continue
+		}
+		tables = append(tables, t)
+	}
+	if !found {
+		return errors.Errorf("ATTACH PARTITION of unknown table %s", p.Name)
+	}
+	targ.TableDef = tables
+
+	name := string(p.Name)
+	p.Key = targ.PartitionKeys[name]
+	delete(targ.PartitionKeys, name)
+	delete(targ.ColumnCollations, name)
+	delete(targ.Exclusions, name)
+	delete(targ.Storage, name)
+	delete(targ.IdentityColumns, name)
+	targ.Partitions = append(targ.Partitions, p)
+
+	return nil
+}
+
+// stripMetaCommands drops psql meta-commands (e.g. \connect, \restrict),
+// which are not terminated by semicolons.
+func stripMetaCommands(src string) string {
+	lines := strings.Split(src, "\n")
+	for i, l := range lines {
+		if strings.HasPrefix(strings.TrimSpace(l), `\`) {
+			lines[i] = ""
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// stripSchemaQualifiers drops public and pg_catalog qualifiers which pg_dump
+// writes since it dumps with an empty search_path. Literals are kept as they are.
+func stripSchemaQualifiers(stmt string) string {
+	var b strings.Builder
+	for i := 0; i < len(stmt); {
+		c := stmt[i]
+		if c == '\'' || c == '"' || c == '$' {
+			j := skipQuoted(stmt, i)
+			b.WriteString(stmt[i:j])
+			i = j
+			continue
+		}
+		if i == 0 || !isIdentChar(stmt[i-1]) {
+			if n := schemaQualifierLen(stmt[i:]); n > 0 {
+				i += n
+				continue
+			}
+		}
+		b.WriteByte(c)
+		i++
+	}
+	return b.String()
+}
+
+func schemaQualifierLen(s string) int {
+	for _, q := range []string{"public.", "pg_catalog."} {
+		if len(s) >= len(q) && strings.EqualFold(s[:len(q)], q) {
+			return len(q)
+		}
+	}
+	return 0
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '$' || c == '"' || c == '.' ||
+		('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
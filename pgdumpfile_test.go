@@ -0,0 +1,316 @@
+package xmigrate
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const pgDumpSchema = `--
+-- PostgreSQL database dump
+--
+
+\restrict abcdef
+
+SET statement_timeout = 0;
+SET client_encoding = 'UTF8';
+SELECT pg_catalog.set_config('search_path', '', false);
+
+CREATE EXTENSION IF NOT EXISTS btree_gist WITH SCHEMA public;
+
+COMMENT ON EXTENSION btree_gist IS 'support for indexing common datatypes in GiST';
+
+SET default_tablespace = '';
+
+CREATE TABLE public.account (
+    id integer NOT NULL,
+    email character varying(255) COLLATE pg_catalog."C" NOT NULL,
+    name text DEFAULT 'public.'::text
+);
+
+ALTER TABLE public.account OWNER TO postgres;
+
+CREATE SEQUENCE public.account_id_seq
+    AS integer
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+ALTER TABLE public.account_id_seq OWNER TO postgres;
+
+ALTER SEQUENCE public.account_id_seq OWNED BY public.account.id;
+
+CREATE TABLE public.item (
+    id integer NOT NULL,
+    account_id integer,
+    price integer DEFAULT 0 NOT NULL,
+    name character varying NOT NULL
+);
+
+CREATE TABLE public.reservation (
+    id integer NOT NULL,
+    room_id integer NOT NULL,
+    during tsrange NOT NULL
+);
+
+CREATE TABLE public.event (
+    id bigint NOT NULL,
+    created_at timestamp without time zone NOT NULL
+)
+PARTITION BY RANGE (created_at);
+
+CREATE TABLE public.event_2019 (
+    id bigint NOT NULL,
+    created_at timestamp without time zone NOT NULL
+);
+
+ALTER TABLE ONLY public.event ATTACH PARTITION public.event_2019 FOR VALUES FROM ('2019-01-01 00:00:00') TO ('2020-01-01 00:00:00');
+
+ALTER TABLE ONLY public.account ALTER COLUMN id SET DEFAULT nextval('public.account_id_seq'::regclass);
+
+ALTER TABLE ONLY public.account
+    ADD CONSTRAINT account_pkey PRIMARY KEY (id);
+
+ALTER TABLE ONLY public.item
+    ADD CONSTRAINT item_pkey PRIMARY KEY (id);
+
+ALTER TABLE ONLY public.item
+    ADD CONSTRAINT unique_price_and_name UNIQUE (price, name);
+
+ALTER TABLE ONLY public.reservation
+    ADD CONSTRAINT no_overlap EXCLUDE USING gist (room_id WITH =, during WITH &&);
+
+CREATE INDEX event_created_at_idx ON ONLY public.event USING btree (created_at);
+
+CREATE INDEX event_2019_created_at_idx ON public.event_2019 USING btree (created_at);
+
+CREATE INDEX email_idx ON public.account USING btree (email);
+
+ALTER INDEX public.event_created_at_idx ATTACH PARTITION public.event_2019_created_at_idx;
+
+ALTER TABLE ONLY public.item
+    ADD CONSTRAINT item_account_id_fkey FOREIGN KEY (account_id) REFERENCES public.account(id);
+
+GRANT ALL ON TABLE public.account TO app;
+
+--
+-- PostgreSQL database dump complete
+--
+
+\unrestrict abcdef
+`
+
+func TestParsePGDumpSchema(t *testing.T) {
+	ctx := context.Background()
+
+	dumped, err := (&SQLSource{Reader: bytes.NewBufferString(pgDumpSchema)}).Load(ctx)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	schema, err := (&SQLSource{Reader: bytes.NewBufferString(`
+create extension if not exists btree_gist;
+create table account (
+    id serial primary key,
+    email varchar(255) collate "C" not null,
+    name text default 'public.'::text
+);
+create table item (
+    id integer primary key,
+    account_id integer references account (id),
+    price integer not null default 0,
+    name varchar not null,
+    constraint unique_price_and_name unique (price, name)
+);
+create table reservation (
+    id integer not null,
+    room_id integer not null,
+    during tsrange not null,
+    constraint no_overlap exclude using gist (room_id with =, during with &&)
+);
+create table event (
+    id bigint not null,
+    created_at timestamp not null
+) partition by range (created_at);
+create table event_2019 partition of event for values from ('2019-01-01 00:00:00') to ('2020-01-01 00:00:00');
+create index event_created_at_idx on event (created_at);
+create index email_idx on account (email);
+`)}).Load(ctx)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	diffs, err := DiffSchemas(schema, dumped)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	for _, d := range diffs {
+		t.Errorf("unexpected diff: %s", d.Spec.ToSQLString())
+	}
+}
+
+func TestStripSchemaQualifiers(t *testing.T) {
+	cases := []struct {
+		in  string
+		out string
+	}{
+		{
+			in:  `CREATE TABLE public.account (email character varying(255) COLLATE pg_catalog."C")`,
+			out: `CREATE TABLE account (email character varying(255) COLLATE "C")`,
+		},
+		{
+			in:  `ALTER TABLE ONLY public.account ALTER COLUMN id SET DEFAULT nextval('public.account_id_seq'::regclass)`,
+			out: `ALTER TABLE ONLY account ALTER COLUMN id SET DEFAULT nextval('public.account_id_seq'::regclass)`,
+		},
+		{
+			in:  `CREATE TABLE my_public.account (id int)`,
+			out: `CREATE TABLE my_public.account (id int)`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.in, func(t *testing.T) {
+			if diff := cmp.Diff(c.out, stripSchemaQualifiers(c.in)); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestIsPGDumpOutput(t *testing.T) {
+	if !isPGDumpOutput([]byte(pgDumpSchema)) {
+		t.Errorf("must be pg_dump output")
+	}
+	if isPGDumpOutput([]byte("create table account (id serial primary key);")) {
+		t.Errorf("must not be pg_dump output")
+	}
+}
+
+// pgDumpIdentitySchema is the output of pg_dump --schema-only (14.10) for
+// create table ticket (id int generated always as identity primary key, code text);
+// create table job (id bigint generated by default as identity, name text);
+const pgDumpIdentitySchema = `--
+-- PostgreSQL database dump
+--
+
+-- Dumped from database version 14.10
+-- Dumped by pg_dump version 14.10
+
+SET statement_timeout = 0;
+SET lock_timeout = 0;
+SET idle_in_transaction_session_timeout = 0;
+SET client_encoding = 'UTF8';
+SET standard_conforming_strings = on;
+SELECT pg_catalog.set_config('search_path', '', false);
+SET check_function_bodies = false;
+SET xmloption = content;
+SET client_min_messages = warning;
+SET row_security = off;
+
+SET default_tablespace = '';
+
+SET default_table_access_method = heap;
+
+--
+-- Name: job; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.job (
+    id bigint NOT NULL,
+    name text
+);
+
+
+ALTER TABLE public.job OWNER TO postgres;
+
+--
+-- Name: job_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
+--
+
+ALTER TABLE public.job ALTER COLUMN id ADD GENERATED BY DEFAULT AS IDENTITY (
+    SEQUENCE NAME public.job_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1
+);
+
+
+--
+-- Name: ticket; Type: TABLE; Schema: public; Owner: postgres
+--
+
+CREATE TABLE public.ticket (
+    id integer NOT NULL,
+    code text
+);
+
+
+ALTER TABLE public.ticket OWNER TO postgres;
+
+--
+-- Name: ticket_id_seq; Type: SEQUENCE; Schema: public; Owner: postgres
+--
+
+ALTER TABLE public.ticket ALTER COLUMN id ADD GENERATED ALWAYS AS IDENTITY (
+    SEQUENCE NAME public.ticket_id_seq
+    START WITH 1
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1
+);
+
+
+--
+-- Name: ticket ticket_pkey; Type: CONSTRAINT; Schema: public; Owner: postgres
+--
+
+ALTER TABLE ONLY public.ticket
+    ADD CONSTRAINT ticket_pkey PRIMARY KEY (id);
+
+
+--
+-- PostgreSQL database dump complete
+--
+
+`
+
+func TestParsePGDumpSchema_Identity(t *testing.T) {
+	targ, err := ParsePGDumpSchema(bytes.NewBufferString(pgDumpIdentitySchema))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	expect := map[string]map[string]string{
+		"job":    {"id": IdentityByDefault},
+		"ticket": {"id": IdentityAlways},
+	}
+	if diff := cmp.Diff(expect, targ.IdentityColumns); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+
+	// identity columns are created with the table
+	diffs, err := Diff(targ, nil)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	for _, d := range diffs {
+		spec, ok := d.Spec.(*AddTableSpec)
+		if !ok {
+			continue
+		}
+		if spec.SQL.Name.ToSQLString() != "ticket" {
+			continue
+		}
+		if act := spec.ToSQLString(); !strings.Contains(act, "id int NOT NULL GENERATED ALWAYS AS IDENTITY") {
+			t.Errorf("id must be an identity column but %s", act)
+		}
+	}
+}
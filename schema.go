@@ -0,0 +1,440 @@
+package xmigrate
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/akito0107/xsqlparser"
+	"github.com/akito0107/xsqlparser/dialect"
+	"github.com/akito0107/xsqlparser/sqlast"
+	errors "golang.org/x/xerrors"
+)
+
+// ParseSchema reads schema sql and builds the target state.
+// Clauses which xsqlparser does not understand (PARTITION BY, PARTITION OF,
+// COLLATE, EXCLUDE, UNLOGGED, WITH, TABLESPACE) are cut off from the statements here and kept beside the parsed AST.
+// ALTER TABLE statements (ADD CONSTRAINT, ADD COLUMN, ALTER COLUMN) are folded into the CREATE TABLE statements.
+func ParseSchema(src io.Reader) (*TargetTable, error) {
+	b, err := ioutil.ReadAll(src)
+	if err != nil {
+		return nil, errors.Errorf("read schema failed: %w", err)
+	}
+
+	return parseStatements(splitStatements(string(b)))
+}
+
+func parseStatements(stmts []string) (*TargetTable, error) {
+	targ := &TargetTable{
+		PartitionKeys:    make(map[string]*PartitionKey),
+		ColumnCollations: make(map[string]map[string]string),
+		Exclusions:       make(map[string][]*ExclusionDef),
+		Storage:          make(map[string]*TableStorage),
+		IdentityColumns:  make(map[string]map[string]string),
+	}
+
+	var alters []*alterTable
+	for _, s := range stmts {
+		if isAlterTable(s) {
+			a, err := parseAlterTable(s)
+			if err != nil {
+				return nil, errors.Errorf("parseAlterTable failed: %w", err)
+			}
+			alters = append(alters, a)
+			continue
+		}
+
+		if ext := parseCreateExtension(s); ext != nil {
+			targ.Extensions = append(targ.Extensions, ext)
+			continue
+		}
+
+		coll, err := parseCreateCollation(s)
+		if err != nil {
+			return nil, errors.Errorf("parseCreateCollation failed: %w", err)
+		}
+		if coll != nil {
+			targ.Collations = append(targ.Collations, coll)
+			continue
+		}
+
+		part, err := parsePartitionOf(s)
+		if err != nil {
+			return nil, errors.Errorf("parsePartitionOf failed: %w", err)
+		}
+		if part != nil {
+			targ.Partitions = append(targ.Partitions, part)
+			continue
+		}
+
+		if isCreateIndex(s) {
+			idx, err := parseIndexDef(s)
+			if err != nil {
+				return nil, errors.Errorf("parseIndexDef failed: %w", err)
+			}
+			targ.IndexDef = append(targ.IndexDef, idx)
+			continue
+		}
+
+		s, storage, err := stripTableStorage(s)
+		if err != nil {
+			return nil, errors.Errorf("stripTableStorage failed: %w", err)
+		}
+
+		s, key, err := stripPartitionKey(s)
+		if err != nil {
+			return nil, errors.Errorf("stripPartitionKey failed: %w", err)
+		}
+
+		s, collations, err := stripCollations(s)
+		if err != nil {
+			return nil, errors.Errorf("stripCollations failed: %w", err)
+		}
+
+		s, exclusions, err := stripExclusions(s)
+		if err != nil {
+			return nil, errors.Errorf("stripExclusions failed: %w", err)
+		}
+
+		stmt, err := parseStatement(s)
+		if err != nil {
+			return nil, err
+		}
+
+		switch sql := stmt.(type) {
+		case *sqlast.CreateTableStmt:
+			targ.TableDef = append(targ.TableDef, sql)
+			name := string(ParseIdentifier(sql.Name.ToSQLString()))
+			if key != nil {
+				targ.PartitionKeys[name] = key
+			}
+			if len(collations) > 0 {
+				targ.ColumnCollations[name] = collations
+			}
+			if len(exclusions) > 0 {
+				targ.Exclusions[name] = exclusions
+			}
+			if storage != nil {
+				targ.Storage[name] = storage
+			}
+		default:
+			return nil, errors.Errorf("unsupported sql: %s", stmt.ToSQLString())
+		}
+	}
+
+	// ALTER TABLE statements are applied after all tables are created,
+	// so that they can refer to tables which are declared later.
+	for _, a := range alters {
+		if err := foldAlterTable(targ, a); err != nil {
+			return nil, errors.Errorf("foldAlterTable failed: %w", err)
+		}
+	}
+
+	return targ, nil
+}
+
+func parseStatement(src string) (sqlast.Stmt, error) {
+	parser, err := xsqlparser.NewParser(bytes.NewBufferString(src), &dialect.PostgresqlDialect{})
+	if err != nil {
+		return nil, errors.Errorf("initialize parser failed with input %s: %w", src, err)
+	}
+	stmt, err := parser.ParseStatement()
+	if err != nil {
+		return nil, errors.Errorf("parse failed with input %s: %w", src, err)
+	}
+
+	return stmt, nil
+}
+
+func parseExpr(src string) (sqlast.Node, error) {
+	parser, err := xsqlparser.NewParser(bytes.NewBufferString(src), &dialect.PostgresqlDialect{})
+	if err != nil {
+		return nil, errors.Errorf("initialize parser failed with input %s: %w", src, err)
+	}
+	expr, err := parser.ParseExpr()
+	if err != nil {
+		return nil, errors.Errorf("parse expression %s failed: %w", src, err)
+	}
+
+	return expr, nil
+}
+
+var createTableHead = regexp.MustCompile(`(?is)^\s*create\s+table\s`)
+
+// splitCreateTableTail splits CREATE TABLE statement into the part which
+// ends with the closing paren of the element list and the rest of it.
+func splitCreateTableTail(stmt string) (string, string, bool) {
+	if !createTableHead.MatchString(stmt) {
+		return stmt, "", false
+	}
+	open := indexTopLevel(stmt, '(')
+	if open < 0 {
+		return stmt, "", false
+	}
+	end := closingParen(stmt, open)
+	if end < 0 {
+		return stmt, "", false
+	}
+
+	return stmt[:end+1], strings.TrimSpace(stmt[end+1:]), true
+}
+
+// splitStatements splits src into statements on top-level semicolons.
+// Comments are dropped, while string literals, quoted identifiers and
+// dollar-quoted bodies are kept as is.
+func splitStatements(src string) []string {
+	var stmts []string
+	var buf strings.Builder
+
+	flush := func() {
+		s := strings.TrimSpace(buf.String())
+		if s != "" {
+			stmts = append(stmts, s)
+		}
+		buf.Reset()
+	}
+
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '-' && strings.HasPrefix(src[i:], "--"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+			buf.WriteByte(' ')
+		case c == '/' && strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				i = len(src)
+			} else {
+				i += end + 4
+			}
+			buf.WriteByte(' ')
+		case c == '\'' || c == '"' || c == '$':
+			j := skipQuoted(src, i)
+			buf.WriteString(src[i:j])
+			i = j
+		case c == ';':
+			flush()
+			i++
+		default:
+			buf.WriteByte(c)
+			i++
+		}
+	}
+	flush()
+
+	return stmts
+}
+
+var dollarTag = regexp.MustCompile(`^\$[A-Za-z_]*\$`)
+
+// skipQuoted returns the index just after the quoted token which starts at i.
+// If src[i] does not start a quoted token, i+1 is returned.
+func skipQuoted(src string, i int) int {
+	switch src[i] {
+	case '\'', '"':
+		q := src[i]
+		for j := i + 1; j < len(src); j++ {
+			if src[j] != q {
+				continue
+			}
+			if j+1 < len(src) && src[j+1] == q {
+				j++
+				continue
+			}
+			return j + 1
+		}
+		return len(src)
+	case '$':
+		tag := dollarTag.FindString(src[i:])
+		if tag == "" {
+			return i + 1
+		}
+		end := strings.Index(src[i+len(tag):], tag)
+		if end < 0 {
+			return len(src)
+		}
+		return i + len(tag) + end + len(tag)
+	default:
+		return i + 1
+	}
+}
+
+// indexTopLevel returns the first index of c in s which is not quoted.
+func indexTopLevel(s string, c byte) int {
+	for i := 0; i < len(s); {
+		if s[i] == c {
+			return i
+		}
+		i = skipQuoted(s, i)
+	}
+	return -1
+}
+
+// indexTopLevelKeyword returns the first index in s where pattern (anchored
+// with ^) matches at a word boundary which is neither quoted nor parenthesized.
+func indexTopLevelKeyword(s string, pattern *regexp.Regexp) int {
+	depth := 0
+	for i := 0; i < len(s); {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		default:
+			if depth == 0 && (i == 0 || !isWordByte(s[i-1])) && pattern.MatchString(s[i:]) {
+				return i
+			}
+		}
+		i = skipQuoted(s, i)
+	}
+	return -1
+}
+
+// closingParen returns the index of the paren which closes s[open].
+func closingParen(s string, open int) int {
+	depth := 0
+	for i := open; i < len(s); {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+		i = skipQuoted(s, i)
+	}
+	return -1
+}
+
+// splitTopLevel splits s on sep which is neither quoted nor parenthesized.
+func splitTopLevel(s string, sep byte) []string {
+	var parts []string
+	depth := 0
+	start := 0
+	for i := 0; i < len(s); {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case sep:
+			if depth == 0 {
+				parts = append(parts, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+		i = skipQuoted(s, i)
+	}
+	if rest := strings.TrimSpace(s[start:]); rest != "" {
+		parts = append(parts, rest)
+	}
+
+	return parts
+}
+
+// normalizeSQL returns comparable form of sql fragment.
+// Everything but quoted tokens is lowercased and whitespace is kept only
+// where it separates two words.
+func normalizeSQL(s string) string {
+	var buf []byte
+	space := false
+	for i := 0; i < len(s); {
+		c := s[i]
+		if isSpaceByte(c) {
+			space = true
+			i++
+			continue
+		}
+		if space && len(buf) > 0 && isWordByte(buf[len(buf)-1]) && isWordByte(c) {
+			buf = append(buf, ' ')
+		}
+		space = false
+
+		if c == '\'' || c == '"' || c == '$' {
+			j := skipQuoted(s, i)
+			buf = append(buf, s[i:j]...)
+			i = j
+			continue
+		}
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		buf = append(buf, c)
+		i++
+	}
+
+	return string(buf)
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c == '\'' || c == '"' || c == '$' || c == '.' ||
+		('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+var tableConstraintHead = regexp.MustCompile(`(?is)^(constraint|primary\s+key|unique|foreign\s+key|check|exclude|like)\b`)
+
+func isTableConstraintElement(elem string) bool {
+	return tableConstraintHead.MatchString(strings.TrimSpace(elem))
+}
+
+// elementName returns the first token of element folded as Identifier.
+func elementName(elem string) string {
+	elem = strings.TrimSpace(elem)
+	if strings.HasPrefix(elem, `"`) {
+		return string(ParseIdentifier(elem[:skipQuoted(elem, 0)]))
+	}
+	for i := 0; i < len(elem); i++ {
+		if isSpaceByte(elem[i]) {
+			return string(ParseIdentifier(elem[:i]))
+		}
+	}
+	return string(ParseIdentifier(elem))
+}
+
+// mapCreateTableElements rewrites each element of CREATE TABLE statement with fn.
+// Element is removed when fn returns empty string.
+func mapCreateTableElements(stmt string, fn func(elem string) (string, error)) (string, error) {
+	head, tail, ok := splitCreateTableTail(stmt)
+	if !ok {
+		return stmt, nil
+	}
+	open := indexTopLevel(head, '(')
+
+	var elems []string
+	for _, e := range splitTopLevel(head[open+1:len(head)-1], ',') {
+		r, err := fn(e)
+		if err != nil {
+			return "", err
+		}
+		if r != "" {
+			elems = append(elems, r)
+		}
+	}
+
+	s := head[:open+1] + strings.Join(elems, ", ") + ")"
+	if tail != "" {
+		s += " " + tail
+	}
+	return s, nil
+}
+
+func isSpaceByte(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func quoteLiteral(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+func unquoteLiteral(s string) string {
+	if len(s) > 1 && strings.HasPrefix(s, "'") && strings.HasSuffix(s, "'") {
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1)
+	}
+	return s
+}
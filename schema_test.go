@@ -0,0 +1,61 @@
+package xmigrate
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSplitStatements(t *testing.T) {
+	cases := []struct {
+		name   string
+		src    string
+		expect []string
+	}{
+		{
+			name:   "simple",
+			src:    "create table a (id int); create table b (id int);",
+			expect: []string{"create table a (id int)", "create table b (id int)"},
+		},
+		{
+			name: "comments",
+			src: `-- accounts;
+create table a (id int); /* ; */
+create table b (id int)`,
+			expect: []string{"create table a (id int)", "create table b (id int)"},
+		},
+		{
+			name:   "quoted",
+			src:    `create table "a;b" (id int default ';'); select $$;$$;`,
+			expect: []string{`create table "a;b" (id int default ';')`, "select $$;$$"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if diff := cmp.Diff(splitStatements(c.src), c.expect); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestNormalizeSQL(t *testing.T) {
+	cases := []struct {
+		a, b string
+	}{
+		{a: "FOR VALUES FROM ('2019-01-01') TO ('2020-01-01')", b: "for values from('2019-01-01')  to('2020-01-01')"},
+		{a: "lower( email )", b: "LOWER(email)"},
+		{a: "a, b", b: "a,b"},
+	}
+
+	for _, c := range cases {
+		if normalizeSQL(c.a) != normalizeSQL(c.b) {
+			t.Errorf("%s and %s should be same but %s, %s", c.a, c.b, normalizeSQL(c.a), normalizeSQL(c.b))
+		}
+	}
+
+	if normalizeSQL("'A'") == normalizeSQL("'a'") {
+		t.Errorf("quoted literal must not be lowercased")
+	}
+}
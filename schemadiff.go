@@ -0,0 +1,154 @@
+package xmigrate
+
+import (
+	"sort"
+
+	"github.com/akito0107/xsqlparser/sqlast"
+	errors "golang.org/x/xerrors"
+)
+
+// TableDefsFromSchema builds the tables which the schema declares, in the
+// same form as PGDump.Dump reports. It lets Diff compare two schema files
+// without a database.
+func TableDefsFromSchema(targ *TargetTable) ([]*TableDef, error) {
+	var defs []*TableDef
+	tables := make(map[string]*TableDef)
+
+	for _, t := range targ.TableDef {
+		name := ParseIdentifier(t.Name.ToSQLString())
+		def := &TableDef{
+			Name:             name,
+			Columns:          make(map[string]*sqlast.ColumnDef),
+			Indexes:          make(map[string]*IndexDef),
+			PartitionKey:     targ.PartitionKeys[string(name)],
+			ColumnCollations: targ.ColumnCollations[string(name)],
+			Exclusions:       targ.Exclusions[string(name)],
+			IdentityColumns:  targ.IdentityColumns[string(name)],
+		}
+		if s := targ.Storage[string(name)]; s != nil {
+			def.Storage = *s
+		}
+
+		for _, e := range t.Elements {
+			switch el := e.(type) {
+			case *sqlast.ColumnDef:
+				n := string(ParseIdentifier(el.Name.ToSQLString()))
+				def.Columns[n] = el
+				def.ColumnOrder = append(def.ColumnOrder, n)
+			case *sqlast.TableConstraint:
+				def.Constrains = append(def.Constrains, el)
+			default:
+				return nil, errors.Errorf("unknown elements %s", e.ToSQLString())
+			}
+		}
+
+		defs = append(defs, def)
+		tables[string(name)] = def
+	}
+
+	for _, p := range targ.Partitions {
+		def := &TableDef{
+			Name:         p.Name,
+			Columns:      make(map[string]*sqlast.ColumnDef),
+			Indexes:      make(map[string]*IndexDef),
+			PartitionKey: p.Key,
+			PartitionOf:  p,
+		}
+		defs = append(defs, def)
+		tables[string(p.Name)] = def
+	}
+
+	for _, i := range targ.IndexDef {
+		t, ok := tables[string(i.Table)]
+		if !ok {
+			return nil, errors.Errorf("index %s is defined on unknown table %s", i.Name, i.Table)
+		}
+		t.Indexes[string(i.Name)] = i
+	}
+
+	return defs, nil
+}
+
+// DiffSchema computes the migration from current schema to targ schema.
+// Tables of current schema are returned as well, since Inverse needs them.
+func DiffSchema(targ, current *TargetTable, opts ...DiffOption) ([]*SchemaDiff, []*TableDef, error) {
+	c, err := SchemaFromTarget(current)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	diffs, err := diffTarget(targ, c, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return diffs, c.Tables, nil
+}
+
+// TargetFromTableDefs builds the target state from tables, typically dumped
+// from another database, so that a database can be migrated to match it.
+func TargetFromTableDefs(tables []*TableDef) *TargetTable {
+	targ := &TargetTable{
+		PartitionKeys:    make(map[string]*PartitionKey),
+		ColumnCollations: make(map[string]map[string]string),
+		Exclusions:       make(map[string][]*ExclusionDef),
+		Storage:          make(map[string]*TableStorage),
+		IdentityColumns:  make(map[string]map[string]string),
+	}
+
+	for _, t := range tables {
+		name := string(t.Name)
+		for _, n := range sortedIndexNames(t.Indexes) {
+			targ.IndexDef = append(targ.IndexDef, t.Indexes[n])
+		}
+
+		if t.PartitionOf != nil {
+			targ.Partitions = append(targ.Partitions, t.PartitionOf)
+			continue
+		}
+
+		var elements []sqlast.TableElement
+		for _, c := range t.OrderedColumns() {
+			elements = append(elements, c)
+		}
+		for _, c := range t.Constrains {
+			elements = append(elements, c)
+		}
+		targ.TableDef = append(targ.TableDef, &sqlast.CreateTableStmt{
+			Name:     sqlast.NewSQLObjectName(t.Name.ToSQLString()),
+			Elements: elements,
+		})
+
+		if t.PartitionKey != nil {
+			targ.PartitionKeys[name] = t.PartitionKey
+		}
+		if len(t.ColumnCollations) > 0 {
+			targ.ColumnCollations[name] = t.ColumnCollations
+		}
+		if len(t.Exclusions) > 0 {
+			targ.Exclusions[name] = t.Exclusions
+		}
+		if len(t.IdentityColumns) > 0 {
+			targ.IdentityColumns[name] = t.IdentityColumns
+		}
+		if !t.Storage.isZero() {
+			storage := t.Storage
+			targ.Storage[name] = &storage
+		}
+	}
+
+	return targ
+}
+
+func sortedIndexNames(indexes map[string]*IndexDef) []string {
+	var names []string
+	for n := range indexes {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DiffTableDefs computes the migration which makes current tables match targ tables.
+func DiffTableDefs(targ, current []*TableDef, opts ...DiffOption) ([]*SchemaDiff, error) {
+	return Diff(TargetFromTableDefs(targ), current, opts...)
+}
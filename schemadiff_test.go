@@ -0,0 +1,156 @@
+package xmigrate
+
+import (
+	"bytes"
+	"sort"
+	"testing"
+
+	"github.com/akito0107/xsqlparser/sqlast"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTableDefsFromSchema(t *testing.T) {
+	targ, err := ParseSchema(bytes.NewBufferString(`
+create table account (
+    id serial primary key,
+    name varchar(100) not null,
+    email varchar(255) COLLATE "C",
+    constraint email_uniq unique (email)
+) with (fillfactor = 70);
+create index name_idx on account (name);
+create table event (id bigint, created_at timestamp) partition by range (created_at);
+create table event_default partition of event default;
+`))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	defs, err := TableDefsFromSchema(targ)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(defs) != 3 {
+		t.Fatalf("must be 3 tables but %d", len(defs))
+	}
+
+	account := defs[0]
+	if diff := cmp.Diff([]string{"id", "name", "email"}, account.ColumnOrder); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+	if len(account.Constrains) != 1 {
+		t.Errorf("must be 1 constraint but %d", len(account.Constrains))
+	}
+	if _, ok := account.Indexes["name_idx"]; !ok {
+		t.Errorf("name_idx must be found")
+	}
+	if account.ColumnCollations["email"] != "C" {
+		t.Errorf("unexpected collations %v", account.ColumnCollations)
+	}
+	if account.Storage.Params["fillfactor"] != "70" {
+		t.Errorf("unexpected storage %+v", account.Storage)
+	}
+
+	if defs[1].PartitionKey == nil {
+		t.Errorf("event must be partitioned")
+	}
+	if defs[2].PartitionOf == nil || defs[2].PartitionOf.Parent != "event" {
+		t.Errorf("unexpected partition %+v", defs[2].PartitionOf)
+	}
+}
+
+func TestDiffSchema(t *testing.T) {
+	current, err := ParseSchema(bytes.NewBufferString(`
+create table account (id serial primary key, name varchar(100), age int);
+create index name_idx on account (name);
+`))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	t.Run("no diff", func(t *testing.T) {
+		diffs, _, err := DiffSchema(current, current)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if len(diffs) != 0 {
+			t.Errorf("must be no diff but %d", len(diffs))
+		}
+	})
+
+	t.Run("diff", func(t *testing.T) {
+		targ, err := ParseSchema(bytes.NewBufferString(`
+create table account (id serial primary key, name varchar(100) not null, email varchar(255));
+create table item (id serial primary key);
+`))
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+
+		diffs, tables, err := DiffSchema(targ, current)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if len(tables) != 1 {
+			t.Errorf("must be 1 current table but %d", len(tables))
+		}
+
+		var types []int
+		for _, d := range diffs {
+			types = append(types, int(d.Type))
+		}
+		sort.Ints(types)
+		expect := []int{int(AddColumn), int(DropColumn), int(AddTable), int(EditColumn), int(RemoveIndex)}
+		if diff := cmp.Diff(expect, types); diff != "" {
+			t.Errorf("diff: %s", diff)
+		}
+	})
+}
+
+func TestDiffTableDefs(t *testing.T) {
+	prod := []*TableDef{
+		{
+			Name: "account",
+			Columns: map[string]*sqlast.ColumnDef{
+				"id":   {Name: sqlast.NewIdent("id"), DataType: &sqlast.Int{}},
+				"name": {Name: sqlast.NewIdent("name"), DataType: &sqlast.Int{}},
+			},
+			ColumnOrder: []string{"id", "name"},
+			Indexes:     map[string]*IndexDef{},
+			Storage:     TableStorage{Params: map[string]string{"fillfactor": "70"}},
+		},
+	}
+
+	t.Run("no diff", func(t *testing.T) {
+		diffs, err := DiffTableDefs(prod, prod)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if len(diffs) != 0 {
+			t.Errorf("must be no diff but %d", len(diffs))
+		}
+	})
+
+	t.Run("hotfix", func(t *testing.T) {
+		staging := []*TableDef{
+			{
+				Name: "account",
+				Columns: map[string]*sqlast.ColumnDef{
+					"id": {Name: sqlast.NewIdent("id"), DataType: &sqlast.Int{}},
+				},
+				ColumnOrder: []string{"id"},
+				Indexes:     map[string]*IndexDef{},
+			},
+		}
+
+		diffs, err := DiffTableDefs(staging, prod)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if len(diffs) != 2 {
+			t.Fatalf("must be 2 diffs but %d", len(diffs))
+		}
+		if diffs[0].Type != DropColumn || diffs[1].Type != AlterStorage {
+			t.Errorf("unexpected diffs %+v", diffs)
+		}
+	})
+}
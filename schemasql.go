@@ -0,0 +1,86 @@
+package xmigrate
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	errors "golang.org/x/xerrors"
+)
+
+// WriteSchemaSQL writes schema as schema sql which ParseSchema reads.
+// The output is deterministic: extensions, collations, tables, partitions
+// and indexes are written in this order, each group sorted by name.
+func WriteSchemaSQL(w io.Writer, schema *Schema) error {
+	var stmts []string
+
+	extensions := append([]*ExtensionDef{}, schema.Extensions...)
+	sort.Slice(extensions, func(i, j int) bool { return extensions[i].Name < extensions[j].Name })
+	for _, e := range extensions {
+		stmts = append(stmts, (&CreateExtensionSpec{Def: e}).ToSQLString())
+	}
+
+	collations := append([]*CollationDef{}, schema.Collations...)
+	sort.Slice(collations, func(i, j int) bool { return collations[i].Name < collations[j].Name })
+	for _, c := range collations {
+		stmts = append(stmts, c.ToSQLString())
+	}
+
+	var tables, partitions []*TableDef
+	for _, t := range schema.Tables {
+		if t.PartitionOf != nil {
+			partitions = append(partitions, t)
+			continue
+		}
+		tables = append(tables, t)
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].Name < tables[j].Name })
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i].Name < partitions[j].Name })
+
+	for _, t := range tables {
+		stmts = append(stmts, createTableSQL(t))
+	}
+	for _, t := range partitions {
+		def := *t.PartitionOf
+		def.Name = t.Name
+		def.Key = t.PartitionKey
+		stmts = append(stmts, (&AddPartitionSpec{Def: &def}).ToSQLString())
+	}
+	for _, t := range append(tables, partitions...) {
+		for _, n := range sortedIndexNames(t.Indexes) {
+			stmts = append(stmts, t.Indexes[n].ToSQLString())
+		}
+	}
+
+	for _, s := range stmts {
+		if _, err := fmt.Fprintf(w, "%s;\n\n", s); err != nil {
+			return errors.Errorf("write schema failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// createTableSQL renders CREATE TABLE statement with one element per line.
+func createTableSQL(t *TableDef) string {
+	var elems []string
+	for _, c := range t.OrderedColumns() {
+		elems = append(elems, columnDefSQL(c, t.ColumnCollations[string(ParseIdentifier(c.Name.ToSQLString()))]))
+	}
+	for _, c := range t.Constrains {
+		elems = append(elems, c.ToSQLString())
+	}
+	for _, e := range t.Exclusions {
+		elems = append(elems, fmt.Sprintf("CONSTRAINT %s %s", e.Name.ToSQLString(), e.Def))
+	}
+
+	create := "CREATE TABLE"
+	if t.Storage.Unlogged {
+		create = "CREATE UNLOGGED TABLE"
+	}
+	sql := fmt.Sprintf("%s %s (\n    %s\n)", create, t.Name.ToSQLString(), strings.Join(elems, ",\n    "))
+	if t.PartitionKey != nil {
+		sql += " " + t.PartitionKey.ToSQLString()
+	}
+	return sql + t.Storage.tail()
+}
@@ -0,0 +1,104 @@
+package xmigrate
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestWriteSchemaSQL(t *testing.T) {
+	ctx := context.Background()
+
+	src := `
+create extension if not exists btree_gist;
+create collation case_insensitive (provider = icu, locale = 'und-u-ks-level2', deterministic = false);
+create table item (
+    id serial primary key,
+    account_id int references account (id),
+    name text collate "C" not null,
+    constraint item_name_key unique (account_id, name)
+);
+create table account (
+    id serial primary key,
+    email varchar(255) not null
+) with (fillfactor = 70);
+create table reservation (
+    id int not null,
+    room_id int not null,
+    during tsrange not null,
+    constraint no_overlap exclude using gist (room_id with =, during with &&)
+);
+create table event (
+    id int not null,
+    created_at timestamp not null
+) partition by range (created_at);
+create table event_2019 partition of event for values from ('2019-01-01') to ('2020-01-01');
+create index email_idx on account (email);
+create index name_idx on item (name);
+`
+
+	schema, err := (&SQLSource{Reader: bytes.NewBufferString(src)}).Load(ctx)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	var out bytes.Buffer
+	if err := WriteSchemaSQL(&out, schema); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	t.Run("no diff", func(t *testing.T) {
+		dumped, err := (&SQLSource{Reader: bytes.NewBufferString(out.String())}).Load(ctx)
+		if err != nil {
+			t.Fatalf("%+v\n%s", err, out.String())
+		}
+		diffs, err := DiffSchemas(schema, dumped)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		for _, d := range diffs {
+			t.Errorf("unexpected diff: %s", d.Spec.ToSQLString())
+		}
+	})
+
+	t.Run("deterministic", func(t *testing.T) {
+		reversed := &Schema{Collations: schema.Collations, Extensions: schema.Extensions}
+		for i := len(schema.Tables) - 1; i >= 0; i-- {
+			reversed.Tables = append(reversed.Tables, schema.Tables[i])
+		}
+		var b bytes.Buffer
+		if err := WriteSchemaSQL(&b, reversed); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if b.String() != out.String() {
+			t.Errorf("output must not depend on the order of tables\n%s\n%s", out.String(), b.String())
+		}
+	})
+
+	t.Run("order", func(t *testing.T) {
+		s := strings.ToUpper(out.String())
+		order := []string{
+			"CREATE EXTENSION",
+			"CREATE COLLATION",
+			"CREATE TABLE ACCOUNT",
+			"CREATE TABLE EVENT (",
+			"CREATE TABLE ITEM",
+			"CREATE TABLE RESERVATION",
+			"CREATE TABLE EVENT_2019 PARTITION OF",
+			"CREATE INDEX EMAIL_IDX",
+			"CREATE INDEX NAME_IDX",
+		}
+		prev := -1
+		for _, o := range order {
+			idx := strings.Index(s, o)
+			if idx < 0 {
+				t.Fatalf("%s is not found in\n%s", o, out.String())
+			}
+			if idx < prev {
+				t.Errorf("%s is out of order in\n%s", o, out.String())
+			}
+			prev = idx
+		}
+	})
+}
@@ -0,0 +1,578 @@
+package xmigrate
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+
+	"github.com/akito0107/xsqlparser/sqlast"
+	errors "golang.org/x/xerrors"
+)
+
+// The snapshot is a JSON document which holds Schema without sqlast nodes.
+// Types, defaults and check expressions are kept as sql fragments and are
+// parsed again on reading, in the same way as PGDump does.
+// Names are folded as Identifier, i.e. they are stored as they are in catalog.
+//
+//	{
+//	  "version": 1,
+//	  "tables": [
+//	    {
+//	      "name": "account",
+//	      "columns": [
+//	        {"name": "id", "type": "serial", "not_null": true, "constraints": [{"name": "account_pkey", "type": "primary_key"}]},
+//	        {"name": "email", "type": "varchar(255)", "collation": "C"}
+//	      ],
+//	      "constraints": [{"name": "email_key", "type": "unique", "columns": ["email"]}],
+//	      "indexes": ["CREATE INDEX email_idx ON account (email)"]
+//	    }
+//	  ],
+//	  "collations": [{"name": "ci", "provider": "icu", "locale": "und-u-ks-level2", "deterministic": false}],
+//	  "extensions": [{"name": "btree_gist"}],
+//	  "default_tablespace": "pg_default",
+//	  "time_zone": "UTC"
+//	}
+//
+// The format is versioned by SnapshotVersion. Fields are only added within
+// a version, so older snapshots are read by newer xmigrate, and snapshots of
+// newer versions are rejected instead of being read partially.
+
+// SnapshotVersion is the version of the snapshot format which WriteSnapshot writes.
+const SnapshotVersion = 1
+
+type snapshot struct {
+	Version    int                  `json:"version"`
+	Tables     []*snapshotTable     `json:"tables"`
+	Collations []*snapshotCollation `json:"collations,omitempty"`
+	Extensions []*snapshotExtension `json:"extensions,omitempty"`
+	// DefaultTablespace and TimeZone are empty unless the schema is dumped from a database.
+	DefaultTablespace string `json:"default_tablespace,omitempty"`
+	TimeZone          string `json:"time_zone,omitempty"`
+}
+
+type snapshotTable struct {
+	Name        string                `json:"name"`
+	Columns     []*snapshotColumn     `json:"columns"`
+	Constraints []*snapshotConstraint `json:"constraints,omitempty"`
+	Exclusions  []*snapshotExclusion  `json:"exclusions,omitempty"`
+	// Indexes are CREATE INDEX statements.
+	Indexes      []string              `json:"indexes,omitempty"`
+	PartitionKey *snapshotPartitionKey `json:"partition_key,omitempty"`
+	PartitionOf  *snapshotPartitionOf  `json:"partition_of,omitempty"`
+	Unlogged     bool                  `json:"unlogged,omitempty"`
+	Params       map[string]string     `json:"storage_params,omitempty"`
+	Tablespace   string                `json:"tablespace,omitempty"`
+	// UnsupportedConstraints are names of constraints which xmigrate doesn't manage.
+	UnsupportedConstraints []string `json:"unsupported_constraints,omitempty"`
+}
+
+type snapshotColumn struct {
+	Name        string                `json:"name"`
+	Type        string                `json:"type"`
+	NotNull     bool                  `json:"not_null,omitempty"`
+	Default     string                `json:"default,omitempty"`
+	Collation   string                `json:"collation,omitempty"`
+	Constraints []*snapshotConstraint `json:"constraints,omitempty"`
+	// Identity is ALWAYS or BY DEFAULT for identity columns.
+	Identity  string `json:"identity,omitempty"`
+	Generated bool   `json:"generated,omitempty"`
+}
+
+// snapshotConstraint is either a column constraint or a table constraint.
+// Columns is empty for column constraints.
+type snapshotConstraint struct {
+	Name string `json:"name,omitempty"`
+	// Type is one of primary_key, unique, foreign_key and check.
+	Type       string   `json:"type"`
+	Columns    []string `json:"columns,omitempty"`
+	RefTable   string   `json:"ref_table,omitempty"`
+	RefColumns []string `json:"ref_columns,omitempty"`
+	Check      string   `json:"check,omitempty"`
+}
+
+type snapshotExclusion struct {
+	Name string `json:"name"`
+	Def  string `json:"def"`
+}
+
+type snapshotPartitionKey struct {
+	Strategy string `json:"strategy"`
+	Expr     string `json:"expr"`
+}
+
+type snapshotPartitionOf struct {
+	Parent string `json:"parent"`
+	Bound  string `json:"bound"`
+}
+
+type snapshotCollation struct {
+	Name          string `json:"name"`
+	Provider      string `json:"provider,omitempty"`
+	Locale        string `json:"locale,omitempty"`
+	LcCollate     string `json:"lc_collate,omitempty"`
+	LcCtype       string `json:"lc_ctype,omitempty"`
+	Deterministic bool   `json:"deterministic"`
+}
+
+type snapshotExtension struct {
+	Name   string `json:"name"`
+	Schema string `json:"schema,omitempty"`
+}
+
+// WriteSnapshot writes schema as JSON snapshot.
+func WriteSnapshot(w io.Writer, schema *Schema) error {
+	snap := &snapshot{
+		Version:           SnapshotVersion,
+		Tables:            []*snapshotTable{},
+		DefaultTablespace: schema.DefaultTablespace,
+		TimeZone:          schema.TimeZone,
+	}
+	for _, t := range schema.Tables {
+		st, err := newSnapshotTable(t)
+		if err != nil {
+			return errors.Errorf("table %s: %w", t.Name, err)
+		}
+		snap.Tables = append(snap.Tables, st)
+	}
+	for _, c := range schema.Collations {
+		snap.Collations = append(snap.Collations, &snapshotCollation{
+			Name:          c.Name,
+			Provider:      c.Provider,
+			Locale:        c.Locale,
+			LcCollate:     c.LcCollate,
+			LcCtype:       c.LcCtype,
+			Deterministic: c.Deterministic,
+		})
+	}
+	for _, e := range schema.Extensions {
+		snap.Extensions = append(snap.Extensions, &snapshotExtension{Name: e.Name, Schema: e.Schema})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(snap); err != nil {
+		return errors.Errorf("encode snapshot failed: %w", err)
+	}
+	return nil
+}
+
+// ReadSnapshot reads JSON snapshot written by WriteSnapshot.
+func ReadSnapshot(r io.Reader) (*Schema, error) {
+	var snap snapshot
+	if err := json.NewDecoder(r).Decode(&snap); err != nil {
+		return nil, errors.Errorf("decode snapshot failed: %w", err)
+	}
+	if err := snap.validate(); err != nil {
+		return nil, errors.Errorf("invalid snapshot: %w", err)
+	}
+
+	schema := &Schema{DefaultTablespace: snap.DefaultTablespace, TimeZone: snap.TimeZone}
+	for _, st := range snap.Tables {
+		t, err := st.tableDef()
+		if err != nil {
+			return nil, errors.Errorf("table %s: %w", st.Name, err)
+		}
+		schema.Tables = append(schema.Tables, t)
+	}
+	for _, c := range snap.Collations {
+		schema.Collations = append(schema.Collations, &CollationDef{
+			Name:          c.Name,
+			Provider:      c.Provider,
+			Locale:        c.Locale,
+			LcCollate:     c.LcCollate,
+			LcCtype:       c.LcCtype,
+			Deterministic: c.Deterministic,
+		})
+	}
+	for _, e := range snap.Extensions {
+		schema.Extensions = append(schema.Extensions, &ExtensionDef{Name: e.Name, Schema: e.Schema})
+	}
+
+	return schema, nil
+}
+
+// MarshalSnapshot returns schema as JSON snapshot.
+func MarshalSnapshot(schema *Schema) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := WriteSnapshot(&buf, schema); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalSnapshot parses JSON snapshot.
+func UnmarshalSnapshot(b []byte) (*Schema, error) {
+	return ReadSnapshot(bytes.NewReader(b))
+}
+
+func (s *snapshot) validate() error {
+	switch {
+	case s.Version == 0:
+		return errors.New("version is missing")
+	case s.Version > SnapshotVersion:
+		return errors.Errorf("version %d is newer than supported version %d", s.Version, SnapshotVersion)
+	}
+
+	tables := make(map[string]struct{})
+	for _, t := range s.Tables {
+		if t.Name == "" {
+			return errors.New("table name is missing")
+		}
+		if _, ok := tables[t.Name]; ok {
+			return errors.Errorf("table %s is duplicated", t.Name)
+		}
+		tables[t.Name] = struct{}{}
+		if err := t.validate(); err != nil {
+			return errors.Errorf("table %s: %w", t.Name, err)
+		}
+	}
+	for _, c := range s.Collations {
+		if c.Name == "" {
+			return errors.New("collation name is missing")
+		}
+	}
+	for _, e := range s.Extensions {
+		if e.Name == "" {
+			return errors.New("extension name is missing")
+		}
+	}
+	return nil
+}
+
+func (st *snapshotTable) validate() error {
+	columns := make(map[string]struct{})
+	for _, c := range st.Columns {
+		if c.Name == "" {
+			return errors.New("column name is missing")
+		}
+		if c.Type == "" {
+			return errors.Errorf("type of column %s is missing", c.Name)
+		}
+		if _, ok := columns[c.Name]; ok {
+			return errors.Errorf("column %s is duplicated", c.Name)
+		}
+		columns[c.Name] = struct{}{}
+		switch c.Identity {
+		case "", IdentityAlways, IdentityByDefault:
+		default:
+			return errors.Errorf("unknown identity %s of column %s", c.Identity, c.Name)
+		}
+		for _, con := range c.Constraints {
+			if len(con.Columns) > 0 {
+				return errors.Errorf("column constraint of %s must not have columns", c.Name)
+			}
+			if err := con.validate(); err != nil {
+				return errors.Errorf("column %s: %w", c.Name, err)
+			}
+		}
+	}
+
+	for _, con := range st.Constraints {
+		if err := con.validate(); err != nil {
+			return err
+		}
+		if con.Type != "check" && len(con.Columns) == 0 {
+			return errors.Errorf("columns of constraint %s are missing", con.Name)
+		}
+		for _, c := range con.Columns {
+			if _, ok := columns[c]; !ok {
+				return errors.Errorf("constraint %s refers to unknown column %s", con.Name, c)
+			}
+		}
+	}
+
+	for _, e := range st.Exclusions {
+		if e.Name == "" || e.Def == "" {
+			return errors.New("exclusion must have name and def")
+		}
+	}
+	if k := st.PartitionKey; k != nil {
+		if _, err := parsePartitionKey(k.Strategy + " (" + k.Expr + ")"); err != nil {
+			return err
+		}
+	}
+	if p := st.PartitionOf; p != nil && (p.Parent == "" || p.Bound == "") {
+		return errors.New("partition must have parent and bound")
+	}
+	return nil
+}
+
+func (con *snapshotConstraint) validate() error {
+	switch con.Type {
+	case "primary_key", "unique":
+	case "foreign_key":
+		if con.RefTable == "" {
+			return errors.Errorf("ref_table of constraint %s is missing", con.Name)
+		}
+	case "check":
+		if con.Check == "" {
+			return errors.Errorf("check of constraint %s is missing", con.Name)
+		}
+	default:
+		return errors.Errorf("unknown constraint type %s", con.Type)
+	}
+	return nil
+}
+
+func newSnapshotTable(t *TableDef) (*snapshotTable, error) {
+	st := &snapshotTable{
+		Name:                   string(t.Name),
+		Columns:                []*snapshotColumn{},
+		Unlogged:               t.Storage.Unlogged,
+		Params:                 t.Storage.Params,
+		Tablespace:             t.Storage.Tablespace,
+		UnsupportedConstraints: t.UnsupportedConstraints,
+	}
+
+	for _, c := range t.OrderedColumns() {
+		name := string(ParseIdentifier(c.Name.ToSQLString()))
+		_, generated := t.GeneratedColumns[name]
+		sc := &snapshotColumn{
+			Name:      name,
+			Type:      c.DataType.ToSQLString(),
+			Collation: t.ColumnCollations[name],
+			Identity:  t.IdentityColumns[name],
+			Generated: generated,
+		}
+		if c.Default != nil {
+			sc.Default = c.Default.ToSQLString()
+		}
+		for _, cc := range c.Constraints {
+			if _, ok := cc.Spec.(*sqlast.NotNullColumnSpec); ok {
+				sc.NotNull = true
+				continue
+			}
+			con, err := newSnapshotColumnConstraint(cc)
+			if err != nil {
+				return nil, err
+			}
+			sc.Constraints = append(sc.Constraints, con)
+		}
+		st.Columns = append(st.Columns, sc)
+	}
+
+	for _, c := range t.Constrains {
+		con, err := newSnapshotTableConstraint(c)
+		if err != nil {
+			return nil, err
+		}
+		st.Constraints = append(st.Constraints, con)
+	}
+
+	for _, e := range t.Exclusions {
+		st.Exclusions = append(st.Exclusions, &snapshotExclusion{Name: string(e.Name), Def: e.Def})
+	}
+	for _, n := range sortedIndexNames(t.Indexes) {
+		st.Indexes = append(st.Indexes, t.Indexes[n].ToSQLString())
+	}
+
+	if t.PartitionKey != nil {
+		st.PartitionKey = &snapshotPartitionKey{Strategy: t.PartitionKey.Strategy, Expr: t.PartitionKey.Expr}
+	}
+	if t.PartitionOf != nil {
+		st.PartitionOf = &snapshotPartitionOf{Parent: string(t.PartitionOf.Parent), Bound: t.PartitionOf.Bound}
+	}
+
+	return st, nil
+}
+
+func identNames(idents []*sqlast.Ident) []string {
+	var names []string
+	for _, i := range idents {
+		names = append(names, string(ParseIdentifier(i.ToSQLString())))
+	}
+	return names
+}
+
+func constraintName(name *sqlast.Ident) string {
+	if name == nil {
+		return ""
+	}
+	return string(ParseIdentifier(name.ToSQLString()))
+}
+
+func newSnapshotColumnConstraint(c *sqlast.ColumnConstraint) (*snapshotConstraint, error) {
+	con := &snapshotConstraint{Name: constraintName(c.Name)}
+	switch spec := c.Spec.(type) {
+	case *sqlast.UniqueColumnSpec:
+		con.Type = "unique"
+		if spec.IsPrimaryKey {
+			con.Type = "primary_key"
+		}
+	case *sqlast.ReferencesColumnSpec:
+		con.Type = "foreign_key"
+		con.RefTable = string(ParseIdentifier(spec.TableName.ToSQLString()))
+		con.RefColumns = identNames(spec.Columns)
+	case *sqlast.CheckColumnSpec:
+		con.Type = "check"
+		con.Check = spec.Expr.ToSQLString()
+	default:
+		return nil, errors.Errorf("unsupported column constraint %s", c.ToSQLString())
+	}
+	return con, nil
+}
+
+func newSnapshotTableConstraint(c *sqlast.TableConstraint) (*snapshotConstraint, error) {
+	con := &snapshotConstraint{Name: constraintName(c.Name)}
+	switch spec := c.Spec.(type) {
+	case *sqlast.UniqueTableConstraint:
+		con.Type = "unique"
+		if spec.IsPrimary {
+			con.Type = "primary_key"
+		}
+		con.Columns = identNames(spec.Columns)
+	case *sqlast.ReferentialTableConstraint:
+		con.Type = "foreign_key"
+		con.Columns = identNames(spec.Columns)
+		con.RefTable = string(ParseIdentifier(spec.KeyExpr.TableName.ToSQLString()))
+		con.RefColumns = identNames(spec.KeyExpr.Columns)
+	case *sqlast.CheckTableConstraint:
+		con.Type = "check"
+		con.Check = spec.Expr.ToSQLString()
+	default:
+		return nil, errors.Errorf("unsupported table constraint %s", c.ToSQLString())
+	}
+	return con, nil
+}
+
+func (st *snapshotTable) tableDef() (*TableDef, error) {
+	name := Identifier(st.Name)
+	t := &TableDef{
+		Name:             name,
+		Columns:          make(map[string]*sqlast.ColumnDef),
+		Indexes:          make(map[string]*IndexDef),
+		ColumnCollations: make(map[string]string),
+		Storage: TableStorage{
+			Unlogged:   st.Unlogged,
+			Params:     st.Params,
+			Tablespace: st.Tablespace,
+		},
+		UnsupportedConstraints: st.UnsupportedConstraints,
+	}
+
+	for _, sc := range st.Columns {
+		c := &sqlast.ColumnDef{
+			Name:     sqlast.NewIdent(quoteIdent(sc.Name)),
+			DataType: columnType(sc.Type),
+		}
+		if sc.Default != "" {
+			d, err := parseExpr(sc.Default)
+			if err != nil {
+				return nil, errors.Errorf("column %s: %w", sc.Name, err)
+			}
+			c.Default = d
+		}
+		if sc.NotNull {
+			c.Constraints = append(c.Constraints, &sqlast.ColumnConstraint{Spec: &sqlast.NotNullColumnSpec{}})
+		}
+		for _, con := range sc.Constraints {
+			cc, err := con.columnConstraint()
+			if err != nil {
+				return nil, errors.Errorf("column %s: %w", sc.Name, err)
+			}
+			c.Constraints = append(c.Constraints, cc)
+		}
+		if sc.Collation != "" {
+			t.ColumnCollations[sc.Name] = sc.Collation
+		}
+		if sc.Identity != "" {
+			t.setIdentity(sc.Name, sc.Identity)
+		}
+		if sc.Generated {
+			t.setGenerated(sc.Name)
+		}
+		t.Columns[sc.Name] = c
+		t.ColumnOrder = append(t.ColumnOrder, sc.Name)
+	}
+
+	for _, con := range st.Constraints {
+		tc, err := con.tableConstraint()
+		if err != nil {
+			return nil, err
+		}
+		t.Constrains = append(t.Constrains, tc)
+	}
+
+	for _, e := range st.Exclusions {
+		t.Exclusions = append(t.Exclusions, &ExclusionDef{Name: Identifier(e.Name), Def: e.Def})
+	}
+	for _, src := range st.Indexes {
+		idx, err := parseIndexDef(src)
+		if err != nil {
+			return nil, errors.Errorf("parseIndexDef failed: %w", err)
+		}
+		t.Indexes[string(idx.Name)] = idx
+	}
+
+	if st.PartitionKey != nil {
+		t.PartitionKey = &PartitionKey{Strategy: st.PartitionKey.Strategy, Expr: st.PartitionKey.Expr}
+	}
+	if st.PartitionOf != nil {
+		t.PartitionOf = &PartitionDef{
+			Name:   name,
+			Parent: Identifier(st.PartitionOf.Parent),
+			Bound:  st.PartitionOf.Bound,
+			Key:    t.PartitionKey,
+		}
+	}
+
+	return t, nil
+}
+
+func quotedName(name string) *sqlast.Ident {
+	if name == "" {
+		return nil
+	}
+	return sqlast.NewIdent(quoteIdent(name))
+}
+
+func (con *snapshotConstraint) columnConstraint() (*sqlast.ColumnConstraint, error) {
+	c := &sqlast.ColumnConstraint{Name: quotedName(con.Name)}
+	switch con.Type {
+	case "primary_key":
+		c.Spec = &sqlast.UniqueColumnSpec{IsPrimaryKey: true}
+	case "unique":
+		c.Spec = &sqlast.UniqueColumnSpec{}
+	case "foreign_key":
+		c.Spec = &sqlast.ReferencesColumnSpec{
+			TableName: sqlast.NewSQLObjectName(quoteIdent(con.RefTable)),
+			Columns:   quoteIdents(con.RefColumns),
+		}
+	case "check":
+		expr, err := parseExpr(con.Check)
+		if err != nil {
+			return nil, err
+		}
+		c.Spec = &sqlast.CheckColumnSpec{Expr: expr}
+	default:
+		return nil, errors.Errorf("unknown constraint type %s", con.Type)
+	}
+	return c, nil
+}
+
+func (con *snapshotConstraint) tableConstraint() (*sqlast.TableConstraint, error) {
+	c := &sqlast.TableConstraint{Name: quotedName(con.Name)}
+	switch con.Type {
+	case "primary_key":
+		c.Spec = &sqlast.UniqueTableConstraint{IsPrimary: true, Columns: quoteIdents(con.Columns)}
+	case "unique":
+		c.Spec = &sqlast.UniqueTableConstraint{Columns: quoteIdents(con.Columns)}
+	case "foreign_key":
+		c.Spec = &sqlast.ReferentialTableConstraint{
+			Columns: quoteIdents(con.Columns),
+			KeyExpr: &sqlast.ReferenceKeyExpr{
+				TableName: sqlast.NewIdent(quoteIdent(con.RefTable)),
+				Columns:   quoteIdents(con.RefColumns),
+			},
+		}
+	case "check":
+		expr, err := parseExpr(con.Check)
+		if err != nil {
+			return nil, err
+		}
+		c.Spec = &sqlast.CheckTableConstraint{Expr: expr}
+	default:
+		return nil, errors.Errorf("unknown constraint type %s", con.Type)
+	}
+	return c, nil
+}
@@ -0,0 +1,180 @@
+package xmigrate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/akito0107/xsqlparser/sqlast"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSnapshot_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+
+	src := &SQLSource{Reader: bytes.NewBufferString(`
+create extension if not exists btree_gist;
+create table category (
+    category_id serial primary key,
+    name varchar(255) COLLATE "C" not null,
+    constraint positive_id check (category_id > 0)
+);
+create table item (
+    item_id serial primary key,
+    price int not null default 0,
+    name varchar not null,
+    category_id int references category(category_id),
+    period tstzrange,
+    CONSTRAINT unique_price_and_name UNIQUE (price, name),
+    CONSTRAINT no_overlap EXCLUDE USING gist (category_id WITH =, period WITH &&)
+) with (fillfactor = 70);
+create index cat_name_idx on item (category_id, name);
+create table event (id bigint, created_at timestamp) partition by range (created_at);
+create table event_default partition of event default;
+`)}
+	schema, err := src.Load(ctx)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteSnapshot(&buf, schema); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	loaded, err := ReadSnapshot(&buf)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if len(loaded.Tables) != len(schema.Tables) {
+		t.Fatalf("must be %d tables but %d", len(schema.Tables), len(loaded.Tables))
+	}
+	diffs, err := DiffSchemas(schema, loaded)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	for _, d := range diffs {
+		t.Errorf("must be no diff but %s", d.Spec.ToSQLString())
+	}
+}
+
+func TestReadSnapshot_Error(t *testing.T) {
+	cases := []struct {
+		name string
+		src  string
+	}{
+		{
+			name: "missing version",
+			src:  `{"tables": []}`,
+		},
+		{
+			name: "newer version",
+			src:  `{"version": 100, "tables": []}`,
+		},
+		{
+			name: "unknown constraint type",
+			src:  `{"version": 1, "tables": [{"name": "account", "columns": [{"name": "id", "type": "int", "constraints": [{"type": "primary"}]}]}]}`,
+		},
+		{
+			name: "missing column type",
+			src:  `{"version": 1, "tables": [{"name": "account", "columns": [{"name": "id"}]}]}`,
+		},
+		{
+			name: "duplicated table",
+			src:  `{"version": 1, "tables": [{"name": "account", "columns": []}, {"name": "account", "columns": []}]}`,
+		},
+		{
+			name: "unknown column in constraint",
+			src:  `{"version": 1, "tables": [{"name": "account", "columns": [{"name": "id", "type": "int"}], "constraints": [{"name": "account_pkey", "type": "primary_key", "columns": ["account_id"]}]}]}`,
+		},
+		{
+			name: "foreign key without ref_table",
+			src:  `{"version": 1, "tables": [{"name": "item", "columns": [{"name": "account_id", "type": "int", "constraints": [{"type": "foreign_key"}]}]}]}`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := UnmarshalSnapshot([]byte(c.src)); err == nil {
+				t.Errorf("must be error")
+			}
+		})
+	}
+}
+
+func TestSnapshot_Version(t *testing.T) {
+	b, err := MarshalSnapshot(&Schema{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	var v struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if v.Version != SnapshotVersion {
+		t.Errorf("version must be %d but %d", SnapshotVersion, v.Version)
+	}
+	if _, err := UnmarshalSnapshot(b); err != nil {
+		t.Errorf("%+v", err)
+	}
+}
+
+func TestSnapshot_Database(t *testing.T) {
+	table := func(tablespace string) *TableDef {
+		return &TableDef{
+			Name: "account",
+			Columns: map[string]*sqlast.ColumnDef{
+				"id":    {Name: sqlast.NewIdent("id"), DataType: &sqlast.Int{}},
+				"total": {Name: sqlast.NewIdent("total"), DataType: &sqlast.Int{}},
+			},
+			ColumnOrder:            []string{"id", "total"},
+			Indexes:                make(map[string]*IndexDef),
+			ColumnCollations:       make(map[string]string),
+			Storage:                TableStorage{Tablespace: tablespace},
+			IdentityColumns:        map[string]string{"id": IdentityAlways},
+			GeneratedColumns:       map[string]struct{}{"total": {}},
+			UnsupportedConstraints: []string{"positive_total"},
+		}
+	}
+	// the table is stored in the default tablespace, so pg_tables shows no tablespace
+	schema := &Schema{
+		Tables:            []*TableDef{table("")},
+		DefaultTablespace: "fast_ssd",
+		TimeZone:          "Asia/Tokyo",
+	}
+
+	b, err := MarshalSnapshot(schema)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	loaded, err := UnmarshalSnapshot(b)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if loaded.DefaultTablespace != "fast_ssd" || loaded.TimeZone != "Asia/Tokyo" {
+		t.Errorf("unexpected schema %+v", loaded)
+	}
+	act := loaded.Tables[0]
+	if diff := cmp.Diff(schema.Tables[0].IdentityColumns, act.IdentityColumns); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+	if diff := cmp.Diff(schema.Tables[0].GeneratedColumns, act.GeneratedColumns); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+	if diff := cmp.Diff(schema.Tables[0].UnsupportedConstraints, act.UnsupportedConstraints); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+
+	diffs, err := DiffSchemas(&Schema{Tables: []*TableDef{table("fast_ssd")}}, loaded)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	for _, d := range diffs {
+		t.Errorf("must be no diff but %s", d.Spec.ToSQLString())
+	}
+}
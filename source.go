@@ -0,0 +1,215 @@
+package xmigrate
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+
+	errors "golang.org/x/xerrors"
+)
+
+// Schema is the state of a database schema which SchemaSource loads.
+type Schema struct {
+	Tables     []*TableDef
+	Collations []*CollationDef
+	Extensions []*ExtensionDef
+	// DefaultTablespace is the default tablespace of the database.
+	// It's empty unless the schema is loaded from a database.
+	DefaultTablespace string
+	// TimeZone is the TimeZone setting of the database session.
+	// It's empty unless the schema is loaded from a database.
+	TimeZone string
+}
+
+// SchemaSource loads Schema from somewhere, e.g. schema sql, a live database
+// or a snapshot. Any pair of sources can be compared by DiffSources.
+type SchemaSource interface {
+	Load(ctx context.Context) (*Schema, error)
+}
+
+// Load dumps the database, so PGDump is a SchemaSource.
+// Tables, collations and extensions are read in a single read only transaction,
+// so the migration is planned against a state which existed at a point in time.
+func (p *PGDump) Load(ctx context.Context) (*Schema, error) {
+	version, err := p.ServerVersion(ctx)
+	if err != nil {
+		return nil, errors.Errorf("ServerVersion failed: %w", err)
+	}
+	tx, err := p.beginRead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	tables, err := p.dump(ctx, tx, version)
+	if err != nil {
+		return nil, errors.Errorf("Dump failed: %w", err)
+	}
+	collations, err := p.dumpCollations(ctx, tx, version)
+	if err != nil {
+		return nil, errors.Errorf("DumpCollations failed: %w", err)
+	}
+	extensions, err := p.dumpExtensions(ctx, tx)
+	if err != nil {
+		return nil, errors.Errorf("DumpExtensions failed: %w", err)
+	}
+	tablespace, err := getDefaultTablespace(ctx, tx)
+	if err != nil {
+		return nil, errors.Errorf("getDefaultTablespace failed: %w", err)
+	}
+	timeZone, err := getTimeZone(ctx, tx)
+	if err != nil {
+		return nil, errors.Errorf("getTimeZone failed: %w", err)
+	}
+
+	return &Schema{
+		Tables:            tables,
+		Collations:        collations,
+		Extensions:        extensions,
+		DefaultTablespace: tablespace,
+		TimeZone:          timeZone,
+	}, nil
+}
+
+// SQLFileSource loads schema sql at Path.
+// The output of pg_dump --schema-only is accepted as well.
+type SQLFileSource struct {
+	Path string
+}
+
+func (s *SQLFileSource) Load(ctx context.Context) (*Schema, error) {
+	f, err := os.Open(s.Path)
+	if err != nil {
+		return nil, errors.Errorf("open %s failed: %w", s.Path, err)
+	}
+	defer f.Close()
+
+	return loadSQL(f)
+}
+
+// SQLSource loads schema sql from Reader.
+type SQLSource struct {
+	Reader io.Reader
+}
+
+func (s *SQLSource) Load(ctx context.Context) (*Schema, error) {
+	return loadSQL(s.Reader)
+}
+
+// loadSQL reads schema sql, or the output of pg_dump --schema-only.
+func loadSQL(r io.Reader) (*Schema, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.Errorf("read schema failed: %w", err)
+	}
+
+	if isPGDumpOutput(b) {
+		targ, err := ParsePGDumpSchema(bytes.NewReader(b))
+		if err != nil {
+			return nil, errors.Errorf("ParsePGDumpSchema failed: %w", err)
+		}
+		return SchemaFromTarget(targ)
+	}
+
+	targ, err := ParseSchema(bytes.NewReader(b))
+	if err != nil {
+		return nil, errors.Errorf("ParseSchema failed: %w", err)
+	}
+	return SchemaFromTarget(targ)
+}
+
+// SchemaFromTarget converts the parsed schema sql into Schema.
+func SchemaFromTarget(targ *TargetTable) (*Schema, error) {
+	tables, err := TableDefsFromSchema(targ)
+	if err != nil {
+		return nil, errors.Errorf("TableDefsFromSchema failed: %w", err)
+	}
+	return &Schema{Tables: tables, Collations: targ.Collations, Extensions: targ.Extensions}, nil
+}
+
+// JSONSnapshotSource loads the snapshot written by WriteSnapshot at Path.
+type JSONSnapshotSource struct {
+	Path string
+}
+
+func (s *JSONSnapshotSource) Load(ctx context.Context) (*Schema, error) {
+	f, err := os.Open(s.Path)
+	if err != nil {
+		return nil, errors.Errorf("open %s failed: %w", s.Path, err)
+	}
+	defer f.Close()
+
+	return ReadSnapshot(f)
+}
+
+type versionedSource interface {
+	ServerVersion(ctx context.Context) (ServerVersion, error)
+}
+
+// DiffSources computes the migration which makes current match targ.
+// Schema of current is returned as well, since Inverse needs its tables.
+// When current is a database, the migration is rejected if it needs a newer server.
+func DiffSources(ctx context.Context, targ, current SchemaSource, opts ...DiffOption) ([]*SchemaDiff, *Schema, error) {
+	t, err := targ.Load(ctx)
+	if err != nil {
+		return nil, nil, errors.Errorf("load target failed: %w", err)
+	}
+	c, err := current.Load(ctx)
+	if err != nil {
+		return nil, nil, errors.Errorf("load current failed: %w", err)
+	}
+	// the migration is checked against the server which it's applied to
+	if v, ok := current.(versionedSource); ok && newDiffConfig(opts).serverVersion == 0 {
+		version, err := v.ServerVersion(ctx)
+		if err != nil {
+			return nil, nil, errors.Errorf("ServerVersion failed: %w", err)
+		}
+		opts = append(opts, WithServerVersion(version))
+	}
+
+	diffs, err := DiffSchemas(t, c, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return diffs, c, nil
+}
+
+// DiffSchemas computes the migration which makes current match targ.
+func DiffSchemas(targ, current *Schema, opts ...DiffOption) ([]*SchemaDiff, error) {
+	target := TargetFromTableDefs(targ.Tables)
+	target.Collations = targ.Collations
+	target.Extensions = targ.Extensions
+
+	return diffTarget(target, current, opts)
+}
+
+func diffTarget(targ *TargetTable, current *Schema, opts []DiffOption) ([]*SchemaDiff, error) {
+	if current.DefaultTablespace != "" {
+		opts = append(opts, WithDefaultTablespace(current.DefaultTablespace))
+	}
+	if current.TimeZone != "" {
+		opts = append(opts, WithTimeZone(current.TimeZone))
+	}
+	diffs, err := Diff(targ, current.Tables, opts...)
+	if err != nil {
+		return nil, errors.Errorf("Diff failed: %w", err)
+	}
+
+	conf := newDiffConfig(opts)
+	filter := conf.filter
+	collationDiffs, err := DiffCollations(filter.FilterCollations(targ.Collations), filter.FilterCollations(current.Collations))
+	if err != nil {
+		return nil, errors.Errorf("DiffCollations failed: %w", err)
+	}
+	if conf.serverVersion > 0 {
+		if err := CheckServerVersion(collationDiffs, conf.serverVersion); err != nil {
+			return nil, err
+		}
+	}
+	diffs = append(diffs, collationDiffs...)
+	diffs = append(diffs, DiffExtensions(filter.FilterExtensions(targ.Extensions), filter.FilterExtensions(current.Extensions))...)
+
+	return diffs, nil
+}
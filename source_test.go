@@ -0,0 +1,80 @@
+package xmigrate
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiffSources(t *testing.T) {
+	ctx := context.Background()
+
+	sql := &SQLSource{Reader: bytes.NewBufferString(`
+create extension if not exists btree_gist;
+create table account (
+    id serial primary key,
+    email varchar(255) not null
+);
+create index email_idx on account (email);
+`)}
+
+	t.Run("same schema", func(t *testing.T) {
+		b := NewSchemaBuilder().Extension("btree_gist")
+		b.Table("account").
+			Column("id", "serial", PrimaryKey()).
+			Column("email", "varchar(255)", NotNull()).
+			Index("create index email_idx on account (email)")
+
+		diffs, _, err := DiffSources(ctx, b, sql)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if len(diffs) != 0 {
+			t.Errorf("must be no diff but %d", len(diffs))
+		}
+	})
+
+	t.Run("builder to sql", func(t *testing.T) {
+		sql := &SQLSource{Reader: bytes.NewBufferString(`create table account (id serial primary key, email varchar(255) not null);`)}
+
+		b := NewSchemaBuilder()
+		b.Table("account").
+			Column("id", "serial", PrimaryKey()).
+			Column("email", "varchar(255)", NotNull()).
+			Column("name", "text", Default("''"))
+		b.Table("item").
+			Column("id", "serial", PrimaryKey()).
+			Column("account_id", "int", References("account", "id"))
+
+		diffs, current, err := DiffSources(ctx, b, sql)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if len(current.Tables) != 1 {
+			t.Errorf("must be 1 current table but %d", len(current.Tables))
+		}
+
+		var types []int
+		for _, d := range diffs {
+			types = append(types, int(d.Type))
+		}
+		sort.Ints(types)
+		if diff := cmp.Diff([]int{int(AddColumn), int(AddTable)}, types); diff != "" {
+			t.Errorf("diff: %s", diff)
+		}
+	})
+}
+
+func TestSchemaBuilder_Error(t *testing.T) {
+	b := NewSchemaBuilder()
+	b.Table("account").
+		Column("id", "serial").
+		Index("create index name_idx on item (name)")
+
+	if _, err := b.Build(); err == nil {
+		t.Errorf("must be error")
+	}
+}
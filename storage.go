@@ -0,0 +1,257 @@
+package xmigrate
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	errors "golang.org/x/xerrors"
+)
+
+// TableStorage holds the storage properties of a table.
+// The zero value means a logged table with default parameters in the default tablespace.
+type TableStorage struct {
+	Unlogged bool
+	// Params holds storage parameters (fillfactor, autovacuum_* ...) given with WITH clause.
+	Params     map[string]string
+	Tablespace string
+}
+
+func (s *TableStorage) isZero() bool {
+	return s == nil || (!s.Unlogged && len(s.Params) == 0 && s.Tablespace == "")
+}
+
+// tail renders WITH and TABLESPACE clauses of CREATE TABLE.
+func (s *TableStorage) tail() string {
+	if s == nil {
+		return ""
+	}
+	var b strings.Builder
+	if len(s.Params) > 0 {
+		fmt.Fprintf(&b, " WITH (%s)", storageParamsSQL(s.Params))
+	}
+	if s.Tablespace != "" {
+		fmt.Fprintf(&b, " TABLESPACE %s", quoteIdent(s.Tablespace))
+	}
+	return b.String()
+}
+
+func storageParamsSQL(params map[string]string) string {
+	var opts []string
+	for _, k := range sortedKeys(params) {
+		opts = append(opts, fmt.Sprintf("%s = %s", k, params[k]))
+	}
+	return strings.Join(opts, ", ")
+}
+
+func sortedKeys(m map[string]string) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// parseStorageParams parses the inside of WITH (...) clause and
+// pg_class.reloptions which are formatted as "key=value".
+func parseStorageParams(opts []string) (map[string]string, error) {
+	params := make(map[string]string)
+	for _, opt := range opts {
+		kv := strings.SplitN(opt, "=", 2)
+		key := strings.ToLower(strings.TrimSpace(kv[0]))
+		if key == "" {
+			return nil, errors.Errorf("malformed storage parameter: %s", opt)
+		}
+		// boolean parameters may be written without value
+		value := "true"
+		if len(kv) == 2 {
+			value = strings.ToLower(unquoteLiteral(strings.TrimSpace(kv[1])))
+		}
+		params[key] = value
+	}
+	return params, nil
+}
+
+var (
+	unloggedPattern          = regexp.MustCompile(`(?is)^(\s*create\s+)unlogged\s+(table\s)`)
+	storageWithPattern       = regexp.MustCompile(`(?is)^with\s*\(`)
+	storageTablespacePattern = regexp.MustCompile(`(?is)^tablespace\s+("(?:[^"]|"")+"|\w+)`)
+)
+
+// stripTableStorage cuts UNLOGGED, WITH (...) and TABLESPACE clauses off from
+// CREATE TABLE statement.
+func stripTableStorage(stmt string) (string, *TableStorage, error) {
+	storage := &TableStorage{}
+	if m := unloggedPattern.FindStringSubmatchIndex(stmt); m != nil {
+		storage.Unlogged = true
+		stmt = stmt[m[2]:m[3]] + stmt[m[4]:]
+	}
+
+	head, tail, ok := splitCreateTableTail(stmt)
+	if !ok || tail == "" {
+		if storage.isZero() {
+			return stmt, nil, nil
+		}
+		return stmt, storage, nil
+	}
+
+	if idx := indexTopLevelKeyword(tail, storageWithPattern); idx >= 0 {
+		open := idx + strings.IndexByte(tail[idx:], '(')
+		end := closingParen(tail, open)
+		if end < 0 {
+			return "", nil, errors.Errorf("unbalanced parens: %s", tail)
+		}
+		params, err := parseStorageParams(splitTopLevel(tail[open+1:end], ','))
+		if err != nil {
+			return "", nil, err
+		}
+		storage.Params = params
+		tail = strings.TrimSpace(tail[:idx] + " " + tail[end+1:])
+	}
+
+	if idx := indexTopLevelKeyword(tail, storageTablespacePattern); idx >= 0 {
+		m := storageTablespacePattern.FindStringSubmatch(tail[idx:])
+		storage.Tablespace = string(ParseIdentifier(m[1]))
+		tail = strings.TrimSpace(tail[:idx] + " " + tail[idx+len(m[0]):])
+	}
+
+	if tail != "" {
+		head += " " + tail
+	}
+	if storage.isZero() {
+		return head, nil, nil
+	}
+	return head, storage, nil
+}
+
+// StorageParamsSpec changes storage parameters of a table or an index.
+type StorageParamsSpec struct {
+	// ObjectType is either TABLE or INDEX.
+	ObjectType string
+	Name       Identifier
+	Set        map[string]string
+	Reset      []string
+}
+
+func (s *StorageParamsSpec) ToSQLString() string {
+	var actions []string
+	if len(s.Set) > 0 {
+		actions = append(actions, fmt.Sprintf("SET (%s)", storageParamsSQL(s.Set)))
+	}
+	if len(s.Reset) > 0 {
+		actions = append(actions, fmt.Sprintf("RESET (%s)", strings.Join(s.Reset, ", ")))
+	}
+	return fmt.Sprintf("ALTER %s %s %s", s.ObjectType, s.Name.ToSQLString(), strings.Join(actions, ", "))
+}
+
+type SetLoggedSpec struct {
+	TableName Identifier
+	Unlogged  bool
+}
+
+func (s *SetLoggedSpec) ToSQLString() string {
+	if s.Unlogged {
+		return fmt.Sprintf("ALTER TABLE %s SET UNLOGGED", s.TableName.ToSQLString())
+	}
+	return fmt.Sprintf("ALTER TABLE %s SET LOGGED", s.TableName.ToSQLString())
+}
+
+// SetTablespaceSpec moves a table or an index to another tablespace.
+type SetTablespaceSpec struct {
+	// ObjectType is either TABLE or INDEX.
+	ObjectType string
+	Name       Identifier
+	Tablespace string
+	// PrevTablespace is the tablespace which the object is moved from.
+	// It's empty when the default tablespace of the database is unknown.
+	PrevTablespace string
+}
+
+func (s *SetTablespaceSpec) ToSQLString() string {
+	return fmt.Sprintf("ALTER %s %s SET TABLESPACE %s", s.ObjectType, s.Name.ToSQLString(), quoteIdent(s.Tablespace))
+}
+
+// WithDefaultTablespace tells Diff the default tablespace of the database, which
+// tables and indexes without TABLESPACE clause are stored in. Without it,
+// they are left in whichever tablespace they are.
+func WithDefaultTablespace(name string) DiffOption {
+	return func(c *diffConfig) {
+		c.defaultTablespace = name
+	}
+}
+
+// computeTablespaceDiff returns nil when targ and current are the same tablespace
+// or targ is the default one which is unknown.
+func computeTablespaceDiff(objectType string, name Identifier, targ, current, defaultTablespace string) []*SchemaDiff {
+	if targ == "" {
+		targ = defaultTablespace
+	}
+	if current == "" {
+		current = defaultTablespace
+	}
+	if targ == "" || targ == current {
+		return nil
+	}
+	return []*SchemaDiff{{
+		Type: AlterStorage,
+		Spec: &SetTablespaceSpec{ObjectType: objectType, Name: name, Tablespace: targ, PrevTablespace: current},
+	}}
+}
+
+// computeStorageParamsDiff returns nil when there is no difference.
+// Indexes can't take SET and RESET in a statement, so they are split.
+func computeStorageParamsDiff(objectType string, name Identifier, targ, current map[string]string) []*SchemaDiff {
+	set := make(map[string]string)
+	for _, k := range sortedKeys(targ) {
+		if v, ok := current[k]; !ok || v != targ[k] {
+			set[k] = targ[k]
+		}
+	}
+	var reset []string
+	for _, k := range sortedKeys(current) {
+		if _, ok := targ[k]; !ok {
+			reset = append(reset, k)
+		}
+	}
+
+	if len(set) == 0 && len(reset) == 0 {
+		return nil
+	}
+	if objectType == "INDEX" && len(set) > 0 && len(reset) > 0 {
+		return []*SchemaDiff{
+			{Type: AlterStorage, Spec: &StorageParamsSpec{ObjectType: objectType, Name: name, Set: set}},
+			{Type: AlterStorage, Spec: &StorageParamsSpec{ObjectType: objectType, Name: name, Reset: reset}},
+		}
+	}
+
+	spec := &StorageParamsSpec{ObjectType: objectType, Name: name, Reset: reset}
+	if len(set) > 0 {
+		spec.Set = set
+	}
+	return []*SchemaDiff{{Type: AlterStorage, Spec: spec}}
+}
+
+func computeTableStorageDiff(tableName Identifier, targ *TableStorage, current TableStorage, defaultTablespace string) []*SchemaDiff {
+	if targ == nil {
+		targ = &TableStorage{}
+	}
+
+	var diffs []*SchemaDiff
+	if targ.Unlogged != current.Unlogged {
+		diffs = append(diffs, &SchemaDiff{
+			Type: AlterStorage,
+			Spec: &SetLoggedSpec{TableName: tableName, Unlogged: targ.Unlogged},
+		})
+	}
+	diffs = append(diffs, computeStorageParamsDiff("TABLE", tableName, targ.Params, current.Params)...)
+	diffs = append(diffs, computeTablespaceDiff("TABLE", tableName, targ.Tablespace, current.Tablespace, defaultTablespace)...)
+
+	return diffs
+}
+
+func computeIndexStorageDiff(targ, current *IndexDef, defaultTablespace string) []*SchemaDiff {
+	diffs := computeStorageParamsDiff("INDEX", current.Name, targ.Params, current.Params)
+	return append(diffs, computeTablespaceDiff("INDEX", current.Name, targ.Tablespace, current.Tablespace, defaultTablespace)...)
+}
@@ -0,0 +1,135 @@
+package xmigrate
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStripTableStorage(t *testing.T) {
+	cases := []struct {
+		name    string
+		src     string
+		expect  string
+		storage *TableStorage
+	}{
+		{
+			name:   "plain",
+			src:    "create table account (id int)",
+			expect: "create table account (id int)",
+		},
+		{
+			name:   "storage",
+			src:    `CREATE UNLOGGED TABLE account (id int) WITH (fillfactor = 70, autovacuum_vacuum_scale_factor = '0.01') TABLESPACE "Fast"`,
+			expect: "CREATE TABLE account (id int)",
+			storage: &TableStorage{
+				Unlogged:   true,
+				Params:     map[string]string{"fillfactor": "70", "autovacuum_vacuum_scale_factor": "0.01"},
+				Tablespace: "Fast",
+			},
+		},
+		{
+			name:   "partitioned",
+			src:    "create table event (id int, created_at timestamp) partition by range (created_at) tablespace archive",
+			expect: "create table event (id int, created_at timestamp) partition by range (created_at)",
+			storage: &TableStorage{
+				Tablespace: "archive",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s, storage, err := stripTableStorage(c.src)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if s != c.expect {
+				t.Errorf("unexpected statement %s", s)
+			}
+			if diff := cmp.Diff(storage, c.storage); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestComputeTableStorageDiff(t *testing.T) {
+	cases := []struct {
+		name              string
+		targ              *TableStorage
+		current           TableStorage
+		defaultTablespace string
+		expect            []string
+	}{
+		{
+			name: "same",
+			targ: &TableStorage{Params: map[string]string{"fillfactor": "70"}},
+			current: TableStorage{
+				Params: map[string]string{"fillfactor": "70"},
+			},
+		},
+		{
+			name: "params",
+			targ: &TableStorage{Params: map[string]string{"fillfactor": "70", "autovacuum_enabled": "false"}},
+			current: TableStorage{
+				Params: map[string]string{"fillfactor": "90", "autovacuum_vacuum_scale_factor": "0.01"},
+			},
+			expect: []string{"ALTER TABLE account SET (autovacuum_enabled = false, fillfactor = 70), RESET (autovacuum_vacuum_scale_factor)"},
+		},
+		{
+			name: "reset to default",
+			current: TableStorage{
+				Unlogged:   true,
+				Params:     map[string]string{"fillfactor": "90"},
+				Tablespace: "archive",
+			},
+			defaultTablespace: "fast_ssd",
+			expect: []string{
+				"ALTER TABLE account SET LOGGED",
+				"ALTER TABLE account RESET (fillfactor)",
+				"ALTER TABLE account SET TABLESPACE fast_ssd",
+			},
+		},
+		{
+			name:    "unknown default",
+			current: TableStorage{Tablespace: "archive"},
+		},
+		{
+			name:              "stated default",
+			targ:              &TableStorage{Tablespace: "pg_default"},
+			defaultTablespace: "pg_default",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var act []string
+			for _, d := range computeTableStorageDiff("account", c.targ, c.current, c.defaultTablespace) {
+				act = append(act, d.Spec.ToSQLString())
+			}
+			if diff := cmp.Diff(act, c.expect); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestComputeIndexStorageDiff(t *testing.T) {
+	current := &IndexDef{Name: "name_idx", Params: map[string]string{"fillfactor": "90", "deduplicate_items": "off"}}
+	targ := &IndexDef{Name: "name_idx", Params: map[string]string{"fillfactor": "70"}, Tablespace: "fast_ssd"}
+
+	var act []string
+	for _, d := range computeIndexStorageDiff(targ, current, "") {
+		act = append(act, d.Spec.ToSQLString())
+	}
+
+	expect := []string{
+		"ALTER INDEX name_idx SET (fillfactor = 70)",
+		"ALTER INDEX name_idx RESET (deduplicate_items)",
+		"ALTER INDEX name_idx SET TABLESPACE fast_ssd",
+	}
+	if diff := cmp.Diff(act, expect); diff != "" {
+		t.Errorf("diff: %s", diff)
+	}
+}
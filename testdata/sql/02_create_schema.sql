@@ -13,6 +13,8 @@ create table category (
     name varchar(255) not null
 );
 
+create unique index lower_name_idx on category (lower(name)) where category_id > 0;
+
 create table item (
     item_id serial primary key,
     price int not null,
@@ -40,4 +42,14 @@ create table subcategory(
 create table num_tests(
     id bigserial primary key,
     num numeric (5,2)
-)
\ No newline at end of file
+);
+
+create table type_tests(
+    id int primary key,
+    code char(3),
+    created_at timestamp(3) with time zone,
+    duration interval day to second(2),
+    flags bit(8),
+    scores int[],
+    tags varchar(20)[]
+);
@@ -0,0 +1,112 @@
+package xmigrate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	errors "golang.org/x/xerrors"
+)
+
+// ServerVersion is server_version_num of PostgreSQL, e.g. 150004 for 15.4 and 90624 for 9.6.24.
+type ServerVersion int
+
+const (
+	PG10 ServerVersion = 100000
+	PG11 ServerVersion = 110000
+	PG12 ServerVersion = 120000
+	PG15 ServerVersion = 150000
+	PG17 ServerVersion = 170000
+)
+
+func (v ServerVersion) String() string {
+	if v >= PG10 {
+		return fmt.Sprintf("%d.%d", v/10000, v%10000)
+	}
+	return fmt.Sprintf("%d.%d.%d", v/10000, v/100%100, v%100)
+}
+
+// ServerVersion returns the version of the server. It's queried once and cached.
+// It's safe for concurrent use, and a failed query is retried on the next call.
+func (p *PGDump) ServerVersion(ctx context.Context) (ServerVersion, error) {
+	p.versionMu.Lock()
+	defer p.versionMu.Unlock()
+
+	if p.version != 0 {
+		return p.version, nil
+	}
+	var version int
+	if err := p.db.GetContext(ctx, &version, "select current_setting('server_version_num')::int"); err != nil {
+		return 0, errors.Errorf("getContext failed: %w", err)
+	}
+	p.version = ServerVersion(version)
+
+	return p.version, nil
+}
+
+// WithServerVersion makes Diff reject the migration if it needs a newer server than version.
+// The migration is rejected during planning, so that it doesn't fail halfway through apply.
+func WithServerVersion(version ServerVersion) DiffOption {
+	return func(c *diffConfig) {
+		c.serverVersion = version
+	}
+}
+
+// CheckServerVersion returns an error if any of diffs needs a newer server than version.
+func CheckServerVersion(diffs []*SchemaDiff, version ServerVersion) error {
+	var msgs []string
+	for _, d := range diffs {
+		required, feature := requiredVersion(d.Spec)
+		if required > version {
+			msgs = append(msgs, fmt.Sprintf("%s needs PostgreSQL %s: %s", feature, required, d.Spec.ToSQLString()))
+		}
+	}
+	if len(msgs) > 0 {
+		return errors.Errorf("server version %s is too old:\n%s", version, strings.Join(msgs, "\n"))
+	}
+	return nil
+}
+
+// requiredVersion returns the minimum server version which spec needs and the feature name.
+func requiredVersion(spec DiffSpec) (ServerVersion, string) {
+	switch s := spec.(type) {
+	case *AddTableSpec:
+		if s.PartitionKey != nil {
+			if strings.EqualFold(s.PartitionKey.Strategy, "hash") {
+				return PG11, "hash partitioning"
+			}
+			return PG10, "declarative partitioning"
+		}
+	case *RebuildTableSpec:
+		if s.Step == RebuildCreate {
+			return requiredVersion(s.Table)
+		}
+	case *AddPartitionSpec:
+		if strings.EqualFold(strings.TrimSpace(s.Def.Bound), "default") {
+			return PG11, "default partition"
+		}
+		return PG10, "declarative partitioning"
+	case *AttachPartitionSpec:
+		if strings.EqualFold(strings.TrimSpace(s.Def.Bound), "default") {
+			return PG11, "default partition"
+		}
+		return PG10, "declarative partitioning"
+	case *DetachPartitionSpec:
+		return PG10, "declarative partitioning"
+	case *AddIndexSpec:
+		if s.Def.NullsNotDistinct {
+			return PG15, "NULLS NOT DISTINCT"
+		}
+		if len(s.Def.Include) > 0 {
+			return PG11, "INCLUDE columns"
+		}
+	case *CreateCollationSpec:
+		if !s.Def.Deterministic {
+			return PG12, "nondeterministic collation"
+		}
+		if s.Def.Provider == "icu" {
+			return PG10, "icu collation"
+		}
+	}
+	return 0, ""
+}
@@ -0,0 +1,104 @@
+package xmigrate
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestServerVersion_String(t *testing.T) {
+	cases := []struct {
+		version ServerVersion
+		expect  string
+	}{
+		{version: 90624, expect: "9.6.24"},
+		{version: 100023, expect: "10.23"},
+		{version: 150004, expect: "15.4"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.expect, func(t *testing.T) {
+			if diff := cmp.Diff(c.expect, c.version.String()); diff != "" {
+				t.Errorf("diff: %s", diff)
+			}
+		})
+	}
+}
+
+func TestCheckServerVersion(t *testing.T) {
+	nullsNotDistinct := &SchemaDiff{
+		Type: AddIndex,
+		Spec: &AddIndexSpec{Def: &IndexDef{
+			Name:             "email_idx",
+			Table:            "account",
+			Unique:           true,
+			NullsNotDistinct: true,
+			Columns:          []*IndexColumn{{Expr: "email"}},
+		}},
+	}
+	nondeterministic := &SchemaDiff{
+		Type: AddCollation,
+		Spec: &CreateCollationSpec{Def: &CollationDef{Name: "ci", Provider: "icu", Locale: "und-u-ks-level2"}},
+	}
+	defaultPartition := &SchemaDiff{
+		Type: AddPartition,
+		Spec: &AddPartitionSpec{Def: &PartitionDef{Name: "event_default", Parent: "event", Bound: "DEFAULT"}},
+	}
+	diffs := []*SchemaDiff{nullsNotDistinct, nondeterministic, defaultPartition}
+
+	cases := []struct {
+		version ServerVersion
+		isErr   bool
+	}{
+		{version: 90624, isErr: true},
+		{version: 110000, isErr: true},
+		{version: 140010, isErr: true},
+		{version: 150004, isErr: false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.version.String(), func(t *testing.T) {
+			err := CheckServerVersion(diffs, c.version)
+			if c.isErr && err == nil {
+				t.Errorf("must be error")
+			}
+			if !c.isErr && err != nil {
+				t.Errorf("%+v", err)
+			}
+		})
+	}
+}
+
+func TestPGDump_ServerVersion_Shared(t *testing.T) {
+	ctx := context.Background()
+	db, err := Connect(ctx, ConnInfo{"dbname": "xmigrate_test", "host": "localhost", "port": "5432", "user": "postgres"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer db.Close()
+
+	// a PGDump made from a shared db is used by goroutines (run with -race)
+	dumper := NewPGDumpFromDB(db)
+	versions := make([]ServerVersion, 4)
+	var wg sync.WaitGroup
+	for i := range versions {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			v, err := dumper.ServerVersion(ctx)
+			if err != nil {
+				t.Errorf("%+v", err)
+			}
+			versions[i] = v
+		}(i)
+	}
+	wg.Wait()
+
+	for _, v := range versions {
+		if v == 0 || v != versions[0] {
+			t.Errorf("must be the same version but %v", versions)
+		}
+	}
+}